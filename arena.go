@@ -0,0 +1,87 @@
+package histogram
+
+// node is a distinct value of the tree of a histogram, linked to the other
+// nodes by their indices in the arena, 0 standing for no node
+type node struct {
+	value float64
+	// the amount of samples in the subtree
+	count        int64
+	duplications int64
+	height       int32
+	left         int32
+	right        int32
+	parent       int32
+	smaller      int32
+	larger       int32
+}
+
+// nodeArena hands out the nodes of the tree from a slice and takes back the
+// deleted ones on a free list linked through parent, so a sliding window
+// whose distinct values come and go stops allocating once the slice covers
+// them; the nodes hold no pointer, the garbage collector does not scan the
+// slice, and nothing outside of the histogram can reach a node it reuses.
+// The first node stays zero, the one the index 0 stands for, whose count
+// and height are those of a missing subtree
+type nodeArena struct {
+	nodes []node
+	free  int32
+}
+
+// get returns the index of a new node holding count samples of v
+func (a *nodeArena) get(v float64, count int64) int32 {
+	if len(a.nodes) == 0 {
+		a.nodes = append(a.nodes, node{})
+	}
+	i := a.free
+	if i != 0 {
+		a.free = a.nodes[i].parent
+	} else {
+		i = int32(len(a.nodes))
+		a.nodes = append(a.nodes, node{})
+	}
+	a.nodes[i] = node{value: v, count: count, duplications: count, height: 1}
+	return i
+}
+
+// put takes a node out of the tree for reuse
+func (a *nodeArena) put(i int32) {
+	a.nodes[i] = node{parent: a.free}
+	a.free = i
+}
+
+// reset takes back every node at once, keeping the slice
+func (a *nodeArena) reset() {
+	if len(a.nodes) > 0 {
+		a.nodes = a.nodes[:1]
+	}
+	a.free = 0
+}
+
+// sample returns a single sample of v on its own, a zero item without one;
+// Enqueue and Dequeue take the address of their copy of it and are small
+// enough to be inlined, so the sample of a caller dropping it stays on the
+// stack and nothing the caller keeps is reachable from the histogram
+func sample(v float64, ok bool) HistogramItem {
+	if !ok {
+		return HistogramItem{}
+	}
+	return HistogramItem{Value: v, Height: 1, Count: 1, Duplications: 1}
+}
+
+// slide appends v to list, a window sliding forward through the array
+// starting at base; when the end of the array is reached the list moves
+// back to the start of it as long as it fills at most half, and the array
+// is doubled otherwise, so a queue of steady length stops allocating
+func slide[T any](list []T, base []T, v T) ([]T, []T) {
+	if len(list) == cap(list) {
+		if 2*len(list) > cap(base) {
+			grown := make([]T, len(list), 2*len(list)+1)
+			copy(grown, list)
+			return append(grown, v), grown[:cap(grown)]
+		}
+		n := copy(base, list)
+		clear(base[n:])
+		list = base[:n]
+	}
+	return append(list, v), base
+}
@@ -0,0 +1,147 @@
+package histogram
+
+import (
+	"sync"
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArena_SteadyStateAllocations(t *testing.T) {
+	for _, w := range benchmarkWorkloads() {
+		histogram, _ := New(WithWindowSize(1000), WithAccuracy(2), WithPercentiles(0.5, 0.99), WithThresholds(100))
+		i := 0
+		next := func() float64 {
+			i++
+			return w.values[i&(benchmarkSamples-1)]
+		}
+		// the arena fills up with the churn of the window, from the second
+		// pass on the windows repeat those already seen
+		for j := 0; j < 2*benchmarkSamples; j++ {
+			histogram.Enqueue(next(), 1)
+		}
+		allocs := testing.AllocsPerRun(4096, func() {
+			histogram.Enqueue(next(), 1)
+		})
+		assert.Equal(t, float64(0), allocs, "%v: Enqueue with eviction", w.name)
+		allocs = testing.AllocsPerRun(4096, func() {
+			histogram.Dequeue()
+			histogram.Enqueue(next(), 1)
+		})
+		assert.Equal(t, float64(0), allocs, "%v: Dequeue and Enqueue", w.name)
+		allocs = testing.AllocsPerRun(4096, func() {
+			histogram.DequeueE()
+			histogram.EnqueueE(next(), 1)
+		})
+		assert.Equal(t, float64(0), allocs, "%v: DequeueE and EnqueueE", w.name)
+		assert.Nil(t, histogram.Verify(), w.name)
+	}
+
+	now := time.Unix(0, 0)
+	histogram, _ := New(WithTimeWindow(time.Second), WithAccuracy(1), WithClock(func() time.Time { return now }))
+	j := 0
+	for ; j < 20000; j++ {
+		now = now.Add(time.Millisecond)
+		histogram.Enqueue(float64(j%777), 1)
+	}
+	allocs := testing.AllocsPerRun(4096, func() {
+		now = now.Add(time.Millisecond)
+		histogram.Enqueue(float64(j%777), 1)
+		j++
+	})
+	assert.Equal(t, float64(0), allocs, "Enqueue expiring by time")
+	assert.Equal(t, int64(1001), histogram.Count, "the samples up to a second old")
+}
+
+func TestArena_Nodes(t *testing.T) {
+	histogram := NewHistogram(0, 10, 0)
+	for i := 1; i <= 3; i++ {
+		histogram.Enqueue(float64(i), 1)
+	}
+	first := histogram.Dequeue()
+	assert.Equal(t, &HistogramItem{Value: 1, Height: 1, Count: 1, Duplications: 1}, first, "the dequeued sample on its own")
+	assert.NotEqual(t, int32(0), histogram.tree.free, "the dequeued node is reused")
+	size := len(histogram.tree.nodes)
+	for i := 4; i <= 8; i++ {
+		histogram.Enqueue(float64(i), 1)
+		histogram.Dequeue()
+	}
+	assert.Equal(t, size, len(histogram.tree.nodes), "the new values take the freed nodes")
+	assert.Equal(t, float64(1), first.Value, "the sample is not overwritten")
+	assert.NotSame(t, first, histogram.Dequeue(), "every sample is a copy of its own")
+	assert.Nil(t, histogram.Verify(), "the tree is intact")
+
+	histogram = NewHistogram(2, 10, 0)
+	histogram.Enqueue(100, 1)
+	histogram.Enqueue(101, 1)
+	evicted := histogram.Enqueue(102, 1)
+	assert.Equal(t, float64(100), evicted.Value, "the evicted sample")
+	evicted.Value = 7
+	histogram.Enqueue(1001, 1)
+	histogram.Enqueue(1002, 1)
+	assert.Nil(t, histogram.Verify(), "the sample stays apart from the tree")
+	assert.Equal(t, []float64{1001, 1002}, histogram.queue, "the window")
+
+	// the nodes evicted inside a batch and those of a reset are reused
+	histogram = NewHistogram(3, 10, 0)
+	histogram.EnqueueBatch([]float64{1, 2, 3})
+	histogram.EnqueueBatch([]float64{4, 5})
+	assert.Equal(t, 4, len(histogram.tree.nodes), "evicted and reused within the batch")
+	histogram.Reset()
+	assert.Equal(t, 1, len(histogram.tree.nodes), "the reset takes back every node")
+	assert.Equal(t, int32(0), histogram.tree.free, "and starts over the slice")
+	histogram.Enqueue(9, 1)
+	assert.Equal(t, float64(9), histogram.GetStatistics().Min, "reused after the reset")
+	assert.Nil(t, histogram.Verify(), "the tree is intact")
+
+	// a queue of steady length slides through the same array
+	list, base := []int{}, []int(nil)
+	for i := 0; i < 100; i++ {
+		list, base = slide(list, base, i)
+		if len(list) > 10 {
+			list = list[1:]
+		}
+	}
+	array := &base[0]
+	for i := 0; i < 1000; i++ {
+		list, base = slide(list, base, i)
+		list = list[1:]
+	}
+	assert.Equal(t, array, &base[0], "the array is reused")
+	assert.Equal(t, []int{990, 991, 992, 993, 994, 995, 996, 997, 998, 999}, list, "FIFO order")
+}
+
+func TestArena_ConcurrentSamples(t *testing.T) {
+	// the samples handed out belong to the callers, run with -race
+	histogram := NewHistogram(64, 10, 1)
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			var kept []*HistogramItem
+			for i := 0; i < 2000; i++ {
+				if item := histogram.Enqueue(float64(g*10000+i%97), 1); item != nil {
+					kept = append(kept, item)
+				}
+				if i%3 == 0 {
+					if item := histogram.Dequeue(); item != nil {
+						kept = append(kept, item)
+					}
+				}
+			}
+			for _, item := range kept {
+				assert.True(t, item.Parent == nil && item.Left == nil && item.Right == nil, "detached from the tree")
+				assert.True(t, item.Smaller == nil && item.Larger == nil, "out of the sorted list")
+				assert.Equal(t, int64(1), item.Duplications, "a single sample")
+			}
+		}(g)
+	}
+	wg.Wait()
+	assert.Nil(t, histogram.Verify(), "the tree is intact")
+
+	root := histogram.Tree()
+	assert.Equal(t, histogram.Count, root.Count, "the copy of the tree")
+	root.Count = 0
+	assert.Nil(t, histogram.Verify(), "the copy stays apart from the tree")
+}
@@ -0,0 +1,239 @@
+package histogram
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ValueCount is a value with the amount of its samples
+type ValueCount struct {
+	Value float64
+	Count int
+}
+
+// EnqueueBatch enqueues the values in order as as many Enqueue(v, 1) would,
+// evicting the oldest samples of the window first, but under a single lock
+// and with the tracked percentiles and thresholds settled once at the end;
+// when the batch replaces the whole window the tree is built balanced from
+// the sorted values. It returns the amount of values enqueued, without
+// those dropped by the value policy; under RejectInvalidValues the first
+// refusal is returned, the valid values are enqueued anyway
+func (h *Histogram) EnqueueBatch(values []float64) (int, error) {
+	h.mutex.Lock()
+	var err error
+	runs := make([]ValueCount, 0, len(values))
+	for _, x := range values {
+		v, ok, e := h.admit(x, 1)
+		if !ok {
+			if err == nil {
+				err = e
+			}
+			continue
+		}
+		runs = append(runs, ValueCount{Value: v, Count: 1})
+	}
+	h.enqueueRuns(runs, false)
+	callbacks := h.takeCallbacks()
+	h.mutex.Unlock()
+
+	for _, f := range callbacks {
+		f()
+	}
+	return len(runs), err
+}
+
+// BulkLoad enqueues the samples of pairs sorted by ascending value, in
+// that order, like EnqueueBatch; an empty histogram, or one whose window
+// the pairs fill, is built in O(n) for n pairs. Unsorted pairs or counts
+// below 1 return ErrInvalidValue without changing anything
+func (h *Histogram) BulkLoad(pairs []ValueCount) error {
+	for i, p := range pairs {
+		if p.Count < 1 {
+			return fmt.Errorf("%w: count %v of %v", ErrInvalidValue, p.Count, p.Value)
+		}
+		if i > 0 && pairs[i-1].Value > p.Value {
+			return fmt.Errorf("%w: %v after %v is not sorted", ErrInvalidValue, p.Value, pairs[i-1].Value)
+		}
+	}
+
+	h.mutex.Lock()
+	var err error
+	runs := make([]ValueCount, 0, len(pairs))
+	for _, p := range pairs {
+		// unifying and clamping keep the order
+		v, ok, e := h.admit(p.Value, p.Count)
+		if !ok {
+			if err == nil {
+				err = e
+			}
+			continue
+		}
+		if n := len(runs); n > 0 && runs[n-1].Value == v {
+			runs[n-1].Count += p.Count
+			continue
+		}
+		runs = append(runs, ValueCount{Value: v, Count: p.Count})
+	}
+	h.enqueueRuns(runs, true)
+	callbacks := h.takeCallbacks()
+	h.mutex.Unlock()
+
+	for _, f := range callbacks {
+		f()
+	}
+	return err
+}
+
+// enqueueRuns enqueues runs of admitted samples in FIFO order, ascending
+// tells whether their values are sorted and distinct
+func (h *Histogram) enqueueRuns(runs []ValueCount, ascending bool) {
+	total := int64(0)
+	for _, r := range runs {
+		total += int64(r.Count)
+	}
+	if total == 0 {
+		return
+	}
+	if h.QueueSize > 0 && total > h.QueueSize {
+		// the head of the batch would be evicted by its tail
+		runs, total = dropRuns(runs, total-h.QueueSize), h.QueueSize
+	}
+
+	// the percentiles and thresholds are settled once at the end
+	percentiles, thresholds := h.Percentiles, h.Thresholds
+	h.Percentiles, h.Thresholds = nil, nil
+
+	now := int64(0)
+	if h.config.TimeWindow > 0 {
+		now = h.now().UnixNano()
+	}
+	evicted := int64(0)
+	if h.QueueSize > 0 {
+		evicted = max(0, h.Count+total-h.QueueSize)
+	}
+	if evicted >= h.Count {
+		// the batch replaces the window
+		tallies := h.tallies
+		h.reset()
+		h.tallies = tallies
+		h.loadRuns(runs, ascending, now)
+	} else {
+		for i := int64(0); i < evicted; i++ {
+			h.dequeue()
+		}
+		for _, r := range runs {
+			h.insertRun(r, now)
+		}
+	}
+
+	h.updateMoments()
+	h.expire()
+	h.Percentiles, h.Thresholds = percentiles, thresholds
+	for _, p := range h.Percentiles {
+		h.initPercentileItem(p)
+	}
+	for _, t := range h.Thresholds {
+		t.Count = h.tree.rank(t.Value)
+	}
+	for _, slo := range h.SLOs {
+		slo.evaluate(h)
+	}
+	h.notifyWatchers()
+}
+
+// dropRuns removes the first n samples of runs
+func dropRuns(runs []ValueCount, n int64) []ValueCount {
+	for len(runs) > 0 && int64(runs[0].Count) <= n {
+		n -= int64(runs[0].Count)
+		runs = runs[1:]
+	}
+	if n > 0 {
+		runs = append([]ValueCount{{Value: runs[0].Value, Count: runs[0].Count - int(n)}}, runs[1:]...)
+	}
+	return runs
+}
+
+// insertRun inserts a run into the tree and appends it to the queue
+func (h *Histogram) insertRun(r ValueCount, now int64) {
+	_, is_new, err := h.tree.insert(r.Value, int64(r.Count))
+	if err != nil {
+		h.logger().Warn("histogram: batch enqueue failed", "value", r.Value, "error", err)
+		return
+	}
+	if is_new {
+		h.BucketHistogram.Insert(&HistogramItem{Value: r.Value})
+	}
+	h.appendRun(r.Value, r.Count, now)
+	h.Count += int64(r.Count)
+	h.moments.add(r.Value, int64(r.Count))
+}
+
+// loadRuns builds a perfectly balanced tree out of the runs on an empty histogram
+func (h *Histogram) loadRuns(runs []ValueCount, ascending bool, now int64) {
+	sorted := runs
+	if !ascending {
+		sorted = mergeValueCounts(append([]ValueCount(nil), runs...))
+	}
+
+	t := &h.tree
+	nodes := make([]int32, len(sorted))
+	for i, r := range sorted {
+		n := t.get(r.Value, int64(r.Count))
+		if i > 0 {
+			t.nodes[n].smaller = nodes[i-1]
+			t.nodes[nodes[i-1]].larger = n
+		}
+		nodes[i] = n
+		h.BucketHistogram.Insert(&HistogramItem{Value: r.Value})
+		h.moments.add(r.Value, int64(r.Count))
+		h.Count += int64(r.Count)
+	}
+	t.root = t.linkBalanced(nodes, 0)
+	t.min, t.max = nodes[0], nodes[len(nodes)-1]
+
+	for _, r := range runs {
+		h.appendRun(r.Value, r.Count, now)
+	}
+}
+
+// mergeValueCounts sorts the pairs in place by value and merges the equal values
+func mergeValueCounts(pairs []ValueCount) []ValueCount {
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].Value < pairs[j].Value })
+	merged := pairs[:0]
+	for _, p := range pairs {
+		if n := len(merged); n > 0 && merged[n-1].Value == p.Value {
+			merged[n-1].Count += p.Count
+		} else {
+			merged = append(merged, p)
+		}
+	}
+	return merged
+}
+
+// linkBalanced links the sorted nodes into a subtree of parent whose
+// heights differ by at most one everywhere, returning its root
+func (t *tree) linkBalanced(nodes []int32, parent int32) int32 {
+	if len(nodes) == 0 {
+		return 0
+	}
+	mid := len(nodes) / 2
+	n := nodes[mid]
+	t.nodes[n].parent = parent
+	t.nodes[n].left = t.linkBalanced(nodes[:mid], n)
+	t.nodes[n].right = t.linkBalanced(nodes[mid+1:], n)
+	t.update(n)
+	return n
+}
+
+// appendRun appends count samples of v to the queue
+func (h *Histogram) appendRun(v float64, count int, now int64) {
+	for i := 0; i < count; i++ {
+		h.queue, h.queueArray = slide(h.queue, h.queueArray, v)
+		if h.config.TimeWindow > 0 {
+			h.timestamps, h.stampArray = slide(h.timestamps, h.stampArray, now)
+		}
+		for _, slo := range h.SLOs {
+			slo.enter(h, v)
+		}
+	}
+}
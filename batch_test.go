@@ -0,0 +1,126 @@
+package histogram
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+// assertSameHistogram compares a batch loaded histogram with one fed sample by sample
+func assertSameHistogram(t *testing.T, want *Histogram, got *Histogram, name string) {
+	assert.Nil(t, got.Verify(), "%v: verify", name)
+	assert.Equal(t, want.Count, got.Count, "%v: count", name)
+	if want.Count == 0 {
+		return
+	}
+	assert.Equal(t, want.GetStatistics().Min, got.GetStatistics().Min, "%v: min", name)
+	assert.Equal(t, want.GetStatistics().Max, got.GetStatistics().Max, "%v: max", name)
+	assert.InDelta(t, want.Mean, got.Mean, 1e-9, "%v: mean", name)
+	assert.InDelta(t, want.Variance, got.Variance, 1e-6*(1+want.Variance), "%v: variance", name)
+	for i := range want.queue {
+		if want.queue[i] != got.queue[i] {
+			t.Fatalf("%v: sample %v of the queue is %v, want %v", name, i, got.queue[i], want.queue[i])
+		}
+	}
+	for _, p := range []float64{0.1, 0.5, 0.9, 0.99} {
+		assert.Equal(t, want.GetValueAtPercentile(p), got.GetValueAtPercentile(p), "%v: percentile %v", name, p)
+	}
+	assert.Equal(t, want.GetThresholdPoints(), got.GetThresholdPoints(), "%v: thresholds", name)
+}
+
+func TestBatch_EnqueueBatch(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	for _, c := range []struct {
+		name   string
+		size   int64
+		before int
+		batch  int
+	}{
+		{"empty", 100, 0, 60},
+		{"partial eviction", 100, 80, 50},
+		{"whole window", 100, 80, 100},
+		{"longer than the window", 100, 30, 250},
+		{"unbounded", 0, 40, 300},
+	} {
+		want, _ := New(WithWindowSize(c.size), WithAccuracy(1), WithPercentiles(0.5, 0.9), WithThresholds(20, 50))
+		got, _ := New(WithWindowSize(c.size), WithAccuracy(1), WithPercentiles(0.5, 0.9), WithThresholds(20, 50))
+		if c.size == 0 {
+			want, _ = New(WithUnboundedWindow(), WithAccuracy(1), WithPercentiles(0.5, 0.9), WithThresholds(20, 50))
+			got, _ = New(WithUnboundedWindow(), WithAccuracy(1), WithPercentiles(0.5, 0.9), WithThresholds(20, 50))
+		}
+		for i := 0; i < c.before; i++ {
+			v := float64(rng.Intn(100))
+			want.Enqueue(v, 1)
+			got.Enqueue(v, 1)
+		}
+		values := make([]float64, c.batch)
+		for i := range values {
+			values[i] = math.Round(rng.ExpFloat64()*300) / 10
+			want.Enqueue(values[i], 1)
+		}
+		n, err := got.EnqueueBatch(values)
+		assert.Nil(t, err, c.name)
+		assert.Equal(t, len(values), n, c.name)
+		assertSameHistogram(t, want, got, c.name)
+
+		// the batch leaves a histogram that keeps working
+		for i := 0; i < 30; i++ {
+			v := float64(rng.Intn(100))
+			want.Enqueue(v, 1)
+			got.Enqueue(v, 1)
+			want.Dequeue()
+			got.Dequeue()
+		}
+		assertSameHistogram(t, want, got, c.name+" afterwards")
+	}
+}
+
+func TestBatch_EnqueueBatchInvalidValues(t *testing.T) {
+	histogram, _ := New(WithWindowSize(10), WithValuePolicy(RejectInvalidValues))
+	n, err := histogram.EnqueueBatch([]float64{1, math.NaN(), 2, math.Inf(1), 3})
+	assert.True(t, errors.Is(err, ErrInvalidValue), "the first refusal")
+	assert.Equal(t, 3, n, "the values enqueued")
+	assert.Equal(t, int64(3), histogram.Count, "the valid values are enqueued")
+	assert.Equal(t, int64(1), histogram.GetValueTallies().NaN, "NaN")
+	assert.Equal(t, int64(1), histogram.GetValueTallies().Overflow, "overflow")
+	assert.Nil(t, histogram.Verify(), "verify")
+}
+
+func TestBatch_BulkLoad(t *testing.T) {
+	pairs := []ValueCount{}
+	for i := 0; i < 1000; i++ {
+		pairs = append(pairs, ValueCount{Value: float64(i), Count: 1 + i%3})
+	}
+	want, _ := New(WithWindowSize(5000), WithPercentiles(0.5, 0.99), WithThresholds(100))
+	got, _ := New(WithWindowSize(5000), WithPercentiles(0.5, 0.99), WithThresholds(100))
+	for _, p := range pairs {
+		want.Enqueue(p.Value, p.Count)
+	}
+	assert.Nil(t, got.BulkLoad(pairs), "sorted pairs")
+	assertSameHistogram(t, want, got, "bulk load")
+	// 1000 nodes fit in a perfect tree of 10 levels
+	assert.Equal(t, int32(10), got.tree.nodes[got.tree.root].height, "balanced")
+
+	// a window smaller than the pairs keeps the last samples
+	small, _ := New(WithWindowSize(10))
+	assert.Nil(t, small.BulkLoad(pairs), "sorted pairs")
+	assert.Equal(t, int64(10), small.Count, "the window")
+	assert.Equal(t, float64(995), small.GetStatistics().Min, "the oldest kept sample")
+	assert.Equal(t, float64(999), small.GetStatistics().Max, "the newest sample")
+	assert.Nil(t, small.Verify(), "verify")
+}
+
+func TestBatch_BulkLoadErrors(t *testing.T) {
+	histogram := NewHistogram(100, 1, 1)
+	histogram.Enqueue(5, 1)
+	for _, pairs := range [][]ValueCount{
+		{{Value: 2, Count: 1}, {Value: 1, Count: 1}},
+		{{Value: 1, Count: 1}, {Value: 2, Count: 0}},
+	} {
+		err := histogram.BulkLoad(pairs)
+		assert.True(t, errors.Is(err, ErrInvalidValue), "%v", pairs)
+		assert.Equal(t, int64(1), histogram.Count, "unchanged by %v", pairs)
+	}
+}
@@ -0,0 +1,153 @@
+package histogram
+
+import (
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+)
+
+// amount of pre-generated samples of a workload, a power of two
+const benchmarkSamples = 1 << 16
+
+const benchmarkWindow = 10000
+
+type benchmarkWorkload struct {
+	name   string
+	values []float64
+}
+
+// benchmarkWorkloads returns the sample distributions of the benchmarks,
+// in milliseconds of latency for the continuous ones
+func benchmarkWorkloads() []benchmarkWorkload {
+	rng := rand.New(rand.NewSource(43))
+	uniform := make([]float64, benchmarkSamples)
+	exponential := make([]float64, benchmarkSamples)
+	duplicates := make([]float64, benchmarkSamples)
+	for i := range uniform {
+		uniform[i] = rng.Float64() * 1000
+		exponential[i] = rng.ExpFloat64() * 100
+		// ten distinct values
+		duplicates[i] = float64(rng.Intn(10) * 50)
+	}
+	return []benchmarkWorkload{
+		{"uniform", uniform},
+		{"exponential", exponential},
+		{"pareto", pareto(rng, benchmarkSamples, 1.2)},
+		{"duplicates", duplicates},
+	}
+}
+
+// filledHistogram returns a histogram whose window is full of the workload
+func filledHistogram(w benchmarkWorkload, size int64) *Histogram {
+	histogram := NewHistogram(size, 10, 2)
+	for i := int64(0); i < size; i++ {
+		histogram.Enqueue(w.values[i%benchmarkSamples], 1)
+	}
+	return histogram
+}
+
+// go test -run=^$ -bench=. -benchmem -count=10 > new.txt
+// go run ./cmd/benchcompare old.txt new.txt
+func BenchmarkEnqueue(b *testing.B) {
+	for _, w := range benchmarkWorkloads() {
+		b.Run(w.name+"/eviction", func(b *testing.B) {
+			// every sample slides the oldest out of the full window
+			histogram := filledHistogram(w, benchmarkWindow)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				histogram.Enqueue(w.values[i&(benchmarkSamples-1)], 1)
+			}
+		})
+		b.Run(w.name+"/unbounded", func(b *testing.B) {
+			histogram := NewHistogram(0, 10, 2)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				histogram.Enqueue(w.values[i&(benchmarkSamples-1)], 1)
+			}
+		})
+	}
+}
+
+func BenchmarkGetValueAtPercentile(b *testing.B) {
+	for _, w := range benchmarkWorkloads() {
+		histogram := filledHistogram(w, benchmarkWindow)
+		histogram.AddPercentilePoint(0.99)
+		for _, c := range []struct {
+			name       string
+			percentile float64
+		}{
+			{"tracked", 0.99},
+			{"untracked", 0.95},
+		} {
+			b.Run(w.name+"/"+c.name, func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					histogram.GetValueAtPercentile(c.percentile)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkGetPercentileForValue(b *testing.B) {
+	for _, w := range benchmarkWorkloads() {
+		histogram := filledHistogram(w, benchmarkWindow)
+		b.Run(w.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				histogram.GetPercentileForValue(w.values[i&(benchmarkSamples-1)])
+			}
+		})
+	}
+}
+
+func BenchmarkCalcPercentileOfProduct(b *testing.B) {
+	for _, w := range benchmarkWorkloads() {
+		for _, n := range []int{2, 10, 100} {
+			// every histogram holds another part of the workload
+			histogram_list := make([]*Histogram, n)
+			for j := range histogram_list {
+				histogram := NewHistogram(1000, 10, 2)
+				for k := 0; k < 1000; k++ {
+					histogram.Enqueue(w.values[(j*1000+k)%benchmarkSamples], 1)
+				}
+				histogram_list[j] = histogram
+			}
+			b.Run(fmt.Sprintf("%v/%v", w.name, n), func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					CalcPercentileOfProduct(0.9, histogram_list, false)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkConcurrentContention(b *testing.B) {
+	for _, w := range benchmarkWorkloads() {
+		b.Run(w.name, func(b *testing.B) {
+			histogram := filledHistogram(w, benchmarkWindow)
+			histogram.AddPercentilePoint(0.99)
+			var seed atomic.Int64
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				rng := rand.New(rand.NewSource(seed.Add(1)))
+				for i := rng.Intn(benchmarkSamples); pb.Next(); i++ {
+					// four samples for a query of a tracked and an untracked percentile
+					switch i % 6 {
+					case 4:
+						histogram.GetValueAtPercentile(0.99)
+					case 5:
+						histogram.GetValueAtPercentile(0.5)
+					default:
+						histogram.Enqueue(w.values[i&(benchmarkSamples-1)], 1)
+					}
+				}
+			})
+		})
+	}
+}
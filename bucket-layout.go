@@ -0,0 +1,279 @@
+package histogram
+
+import (
+	"fmt"
+	"math"
+)
+
+// BucketLayout maps the values to the sub histograms of a BucketHistogram
+// and to the buckets within them; both are numbered in ascending order of
+// value, which the product percentile search relies on
+type BucketLayout interface {
+	// SubHistogram returns the index of the sub histogram covering v,
+	// negative when v is out of the range of the layout
+	SubHistogram(v float64) int64
+	// Boundaries returns the range [lower, upper) of a sub histogram
+	Boundaries(sub int64) (float64, float64)
+	// Bucket returns the index of the bucket of v within its sub histogram
+	Bucket(sub int64, v float64) int64
+	// BucketValue returns the lower boundary of a bucket
+	BucketValue(sub int64, bucket int64) float64
+	// Buckets returns the amount of buckets of every sub histogram
+	Buckets() int
+	Validate() error
+}
+
+// fraction of a bucket of the linear layout absorbing the float error
+const linearBucketTolerance = 1e-9
+
+// LinearLayout splits the non-negative values into sub histograms of
+// SubHistogramSize, each holding buckets of BucketSize
+type LinearLayout struct {
+	SubHistogramSize float64
+	BucketSize       float64
+}
+
+func NewLinearLayout(subHistogramSize float64, bucketSize float64) *LinearLayout {
+	return &LinearLayout{
+		SubHistogramSize: subHistogramSize,
+		BucketSize:       bucketSize,
+	}
+}
+
+func (l *LinearLayout) SubHistogram(v float64) int64 {
+	if l.SubHistogramSize == 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+		return -1
+	}
+	idx_value := v / l.SubHistogramSize
+	if math.Abs(l.SubHistogramSize) < 1 {
+		idx_value = math.Round(idx_value)
+	}
+	return int64(idx_value)
+}
+
+func (l *LinearLayout) Boundaries(sub int64) (float64, float64) {
+	return float64(sub) * l.SubHistogramSize, float64(sub+1) * l.SubHistogramSize
+}
+
+func (l *LinearLayout) Bucket(sub int64, v float64) int64 {
+	lower, _ := l.Boundaries(sub)
+	// the values on a boundary may be a float error below it
+	idx := int64(math.Floor((v-lower)/l.BucketSize + linearBucketTolerance))
+	if idx < 0 {
+		return -1
+	}
+	// the last bucket is cut short when the buckets do not divide the
+	// sub histogram, and takes the float error at its upper boundary
+	return min(idx, int64(l.Buckets())-1)
+}
+
+func (l *LinearLayout) BucketValue(sub int64, bucket int64) float64 {
+	lower, _ := l.Boundaries(sub)
+	return lower + float64(bucket)*l.BucketSize
+}
+
+func (l *LinearLayout) Buckets() int {
+	return int(math.Ceil(l.SubHistogramSize/l.BucketSize - linearBucketTolerance))
+}
+
+func (l *LinearLayout) Validate() error {
+	if !(l.SubHistogramSize > 0) || math.IsInf(l.SubHistogramSize, 0) {
+		return fmt.Errorf("sub histogram size %v is not positive", l.SubHistogramSize)
+	}
+	if !(l.BucketSize > 0) || l.BucketSize > l.SubHistogramSize {
+		return fmt.Errorf("bucket size %v is outside (0, sub histogram size]", l.BucketSize)
+	}
+	return nil
+}
+
+// index of the sub histogram of 10^0 in the significant layout,
+// which leaves room for the smallest positive float64
+const significantDecadeOffset = 324
+
+// fraction of a bucket of the significant layout absorbing the float error
+const significantBucketTolerance = 1e-9
+
+// SignificantLayout has a sub histogram per power of ten, split into buckets
+// as wide as the last of Digits significant digits, so the values rounded by
+// Config.SignificantDigits fall into buckets of their own
+type SignificantLayout struct {
+	Digits int
+}
+
+func NewSignificantLayout(digits int) *SignificantLayout {
+	return &SignificantLayout{Digits: digits}
+}
+
+func (l *SignificantLayout) SubHistogram(v float64) int64 {
+	if !(v > 0) || math.IsInf(v, 1) {
+		return -1
+	}
+	return int64(decade(v) + significantDecadeOffset)
+}
+
+func (l *SignificantLayout) Boundaries(sub int64) (float64, float64) {
+	e := int(sub) - significantDecadeOffset
+	return math.Pow10(e), math.Pow10(e + 1)
+}
+
+func (l *SignificantLayout) Bucket(sub int64, v float64) int64 {
+	lower, _ := l.Boundaries(sub)
+	// the values rounded to the digits are at most a float error
+	// below the boundary of their bucket
+	idx := int64(math.Floor((v-lower)/l.bucketSize(sub) + significantBucketTolerance))
+	if idx < 0 || idx >= int64(l.Buckets()) {
+		return -1
+	}
+	return idx
+}
+
+func (l *SignificantLayout) BucketValue(sub int64, bucket int64) float64 {
+	lower, _ := l.Boundaries(sub)
+	return lower + float64(bucket)*l.bucketSize(sub)
+}
+
+func (l *SignificantLayout) Buckets() int {
+	// the leading digit runs from 1 to 9
+	return 9 * int(math.Pow10(l.Digits-1))
+}
+
+func (l *SignificantLayout) Validate() error {
+	if l.Digits <= 0 || l.Digits > maxSignificantDigits {
+		return fmt.Errorf("significant digits %v are outside [1, %v]", l.Digits, maxSignificantDigits)
+	}
+	return nil
+}
+
+func (l *SignificantLayout) bucketSize(sub int64) float64 {
+	return math.Pow10(int(sub) - significantDecadeOffset - l.Digits + 1)
+}
+
+// LogarithmicLayout places v in the bucket k with gamma^k <= v < gamma^(k+1),
+// gamma = (1+RelativeAccuracy)/(1-RelativeAccuracy) as in DDSketch, grouping
+// BucketsPerSubHistogram consecutive buckets into a sub histogram; the memory
+// grows with the logarithm of the range of the values rather than the range
+type LogarithmicLayout struct {
+	RelativeAccuracy       float64
+	BucketsPerSubHistogram int
+}
+
+func NewLogarithmicLayout(relativeAccuracy float64, bucketsPerSubHistogram int) *LogarithmicLayout {
+	return &LogarithmicLayout{
+		RelativeAccuracy:       relativeAccuracy,
+		BucketsPerSubHistogram: bucketsPerSubHistogram,
+	}
+}
+
+func (l *LogarithmicLayout) logGamma() float64 {
+	return math.Log((1 + l.RelativeAccuracy) / (1 - l.RelativeAccuracy))
+}
+
+func (l *LogarithmicLayout) key(v float64) int64 {
+	return int64(math.Floor(math.Log(v) / l.logGamma()))
+}
+
+// offset is the index of the sub histogram of the key 0, leaving room
+// for the key of the smallest positive float64
+func (l *LogarithmicLayout) offset() int64 {
+	size := int64(l.BucketsPerSubHistogram)
+	return -floorDiv(l.key(math.SmallestNonzeroFloat64), size)
+}
+
+func (l *LogarithmicLayout) SubHistogram(v float64) int64 {
+	if !(v > 0) || math.IsInf(v, 1) {
+		return -1
+	}
+	return floorDiv(l.key(v), int64(l.BucketsPerSubHistogram)) + l.offset()
+}
+
+func (l *LogarithmicLayout) Boundaries(sub int64) (float64, float64) {
+	return l.BucketValue(sub, 0), l.BucketValue(sub+1, 0)
+}
+
+func (l *LogarithmicLayout) Bucket(sub int64, v float64) int64 {
+	idx := l.key(v) - (sub-l.offset())*int64(l.BucketsPerSubHistogram)
+	if idx < 0 || idx >= int64(l.BucketsPerSubHistogram) {
+		return -1
+	}
+	return idx
+}
+
+func (l *LogarithmicLayout) BucketValue(sub int64, bucket int64) float64 {
+	k := (sub-l.offset())*int64(l.BucketsPerSubHistogram) + bucket
+	return math.Exp(float64(k) * l.logGamma())
+}
+
+func (l *LogarithmicLayout) Buckets() int {
+	return l.BucketsPerSubHistogram
+}
+
+func (l *LogarithmicLayout) Validate() error {
+	if !(l.RelativeAccuracy > 0 && l.RelativeAccuracy < 1) {
+		return fmt.Errorf("relative accuracy %v is outside (0, 1)", l.RelativeAccuracy)
+	}
+	if l.BucketsPerSubHistogram <= 0 || l.BucketsPerSubHistogram > maxBucketIndex {
+		return fmt.Errorf("%v buckets per sub histogram are outside [1, %v]", l.BucketsPerSubHistogram, maxBucketIndex)
+	}
+	return nil
+}
+
+// index of the sub histogram of 2^0 in the log-linear layout,
+// which leaves room for the smallest positive float64 at 2^-1074
+const logLinearOffset = 1074
+
+// LogLinearLayout is the layout of HdrHistogram: a sub histogram per power
+// of two, split into 2^SubBucketBits buckets of equal width, which bounds
+// the relative error by 2^-SubBucketBits
+type LogLinearLayout struct {
+	SubBucketBits int
+}
+
+func NewLogLinearLayout(subBucketBits int) *LogLinearLayout {
+	return &LogLinearLayout{SubBucketBits: subBucketBits}
+}
+
+func (l *LogLinearLayout) SubHistogram(v float64) int64 {
+	if !(v > 0) || math.IsInf(v, 1) {
+		return -1
+	}
+	_, exp := math.Frexp(v)
+	return int64(exp - 1 + logLinearOffset)
+}
+
+func (l *LogLinearLayout) Boundaries(sub int64) (float64, float64) {
+	e := int(sub) - logLinearOffset
+	return math.Ldexp(1, e), math.Ldexp(1, e+1)
+}
+
+func (l *LogLinearLayout) Bucket(sub int64, v float64) int64 {
+	lower, _ := l.Boundaries(sub)
+	idx := int64(math.Floor((v/lower - 1) * float64(l.Buckets())))
+	if idx < 0 || idx >= int64(l.Buckets()) {
+		return -1
+	}
+	return idx
+}
+
+func (l *LogLinearLayout) BucketValue(sub int64, bucket int64) float64 {
+	lower, _ := l.Boundaries(sub)
+	return lower * (1 + float64(bucket)/float64(l.Buckets()))
+}
+
+func (l *LogLinearLayout) Buckets() int {
+	return 1 << l.SubBucketBits
+}
+
+func (l *LogLinearLayout) Validate() error {
+	if l.SubBucketBits <= 0 || l.SubBucketBits > 16 {
+		return fmt.Errorf("sub bucket bits %v are outside [1, 16]", l.SubBucketBits)
+	}
+	return nil
+}
+
+func floorDiv(a int64, b int64) int64 {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
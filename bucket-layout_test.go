@@ -0,0 +1,208 @@
+package histogram
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+func testLayouts() map[string]BucketLayout {
+	return map[string]BucketLayout{
+		"linear":      NewLinearLayout(1000, 0.5),
+		"wide linear": NewLinearLayout(100, 30),
+		"significant": NewSignificantLayout(3),
+		"logarithmic": NewLogarithmicLayout(0.01, 128),
+		"log-linear":  NewLogLinearLayout(7),
+	}
+}
+
+func TestBucketLayout_Positions(t *testing.T) {
+	rng := rand.New(rand.NewSource(37))
+	for name, layout := range testLayouts() {
+		assert.Nil(t, layout.Validate(), name)
+		last_sub, last_bucket := int64(-1), int64(-1)
+		list := sorted_list(gen_random_list_float(5000, 100, 100))
+		for i := range list {
+			list[i] = math.Abs(list[i]) * math.Exp(rng.Float64()*10-5)
+		}
+		list = sorted_list(list)
+		for _, v := range list {
+			if v == 0 {
+				continue
+			}
+			sub := layout.SubHistogram(v)
+			lower, upper := layout.Boundaries(sub)
+			assert.True(t, lower <= v*(1+1e-12) && v < upper*(1+1e-12), "%v: %v in [%v, %v)", name, v, lower, upper)
+
+			bucket := layout.Bucket(sub, v)
+			assert.True(t, bucket >= 0 && bucket < int64(layout.Buckets()), "%v: bucket %v of %v", name, bucket, v)
+			assert.LessOrEqual(t, layout.BucketValue(sub, bucket), v*(1+1e-12), "%v: bucket of %v", name, v)
+			if bucket+1 < int64(layout.Buckets()) {
+				assert.Greater(t, layout.BucketValue(sub, bucket+1)*(1+1e-12), v, "%v: bucket of %v", name, v)
+			}
+
+			// ascending values never go back to a lower sub histogram or bucket
+			assert.True(t, sub > last_sub || (sub == last_sub && bucket >= last_bucket), "%v: order at %v", name, v)
+			last_sub, last_bucket = sub, bucket
+		}
+		assert.True(t, layout.SubHistogram(-1) < 0 || name == "linear" || name == "wide linear", "%v: negative values", name)
+		assert.True(t, layout.SubHistogram(math.NaN()) < 0, "%v: NaN", name)
+	}
+
+	bad := map[string]BucketLayout{
+		"linear":      NewLinearLayout(1, 2),
+		"significant": NewSignificantLayout(0),
+		"logarithmic": NewLogarithmicLayout(1.5, 128),
+		"log-linear":  NewLogLinearLayout(40),
+	}
+	for name, layout := range bad {
+		_, err := New(WithUnboundedWindow(), WithBucketLayout(layout))
+		assert.True(t, errors.Is(err, ErrInvalidOption), name)
+	}
+	_, err := New(WithUnboundedWindow(), WithBucketLayout(NewLogLinearLayout(7)), WithSubBucketSize(10))
+	assert.True(t, errors.Is(err, ErrInvalidOption), "a sub bucket size conflicts with a layout")
+}
+
+func TestBucketLayout_LinearWideBuckets(t *testing.T) {
+	// buckets much wider than the accuracy unit
+	layout := NewLinearLayout(100, 10)
+	assert.Equal(t, 10, layout.Buckets(), "buckets")
+	assert.Equal(t, int64(1), layout.Bucket(0, 15), "15 in [10, 20)")
+	assert.Equal(t, int64(9), layout.Bucket(0, 99), "99 in [90, 100)")
+	assert.Equal(t, int64(3), NewLinearLayout(1, 0.1).Bucket(0, 0.1+0.2), "a float error above a boundary")
+	assert.Equal(t, int64(7), NewLinearLayout(1, 0.1).Bucket(0, 0.7), "a float error below a boundary")
+	assert.Equal(t, 4, NewLinearLayout(100, 30).Buckets(), "the last bucket is cut short")
+	assert.Equal(t, int64(3), NewLinearLayout(100, 30).Bucket(0, 99), "99 in [90, 100)")
+
+	histogram, _ := New(WithWindowSize(100), WithAccuracy(0), WithBucketLayout(layout))
+	samples := []float64{15, 99, 3, 42, 57, 61, 88, 99, 15, 7}
+	for _, v := range samples {
+		histogram.Enqueue(v, 1)
+	}
+	assert.Nil(t, histogram.Verify(), "every item is in its bucket")
+	for _, sbh := range histogram.BucketHistogram.All() {
+		assert.LessOrEqual(t, sbh.GetLength(), int64(layout.Buckets()), "within the buckets")
+	}
+	// an untracked percentile is a sample, as a tracked one
+	model := &referenceModel{}
+	for _, v := range samples {
+		model.enqueue(v, 1)
+	}
+	for _, p := range []float64{0.1, 0.5, 0.9} {
+		assert.Equal(t, model.percentile(p), histogram.GetValueAtPercentile(p), "at %v", p)
+	}
+}
+
+func TestBucketLayout_WideRange(t *testing.T) {
+	// from 1 microsecond to 60 seconds in milliseconds, which a linear
+	// layout at this accuracy can only bucket with millions of buckets
+	rng := rand.New(rand.NewSource(37))
+	list := make([]float64, 20000)
+	for i := range list {
+		list[i] = math.Exp(rng.Float64()*math.Log(6e7)) * 1e-3
+	}
+
+	for name, layout := range testLayouts() {
+		if name == "linear" || name == "wide linear" {
+			continue
+		}
+		histogram_list := []*Histogram{}
+		for i := 0; i < 3; i++ {
+			histogram, err := New(WithWindowSize(10000), WithAccuracy(6), WithBucketLayout(layout))
+			assert.Nil(t, err, name)
+			for _, v := range list[i*5000 : i*5000+10000] {
+				histogram.Enqueue(v, 1)
+			}
+			histogram_list = append(histogram_list, histogram)
+		}
+
+		// the buckets stay within the size of the layout, and every value
+		// is counted in the bucket the layout places it
+		h := histogram_list[0]
+		bucketed := int64(0)
+		for _, sbh := range h.BucketHistogram.All() {
+			assert.LessOrEqual(t, sbh.GetLength(), int64(layout.Buckets()), name)
+			for _, count := range sbh.All() {
+				bucketed += count
+			}
+		}
+		distinct := int64(0)
+		for i := h.tree.min; i != 0; i = h.tree.nodes[i].larger {
+			distinct++
+			v := h.tree.nodes[i].value
+			idx, _, _ := h.BucketHistogram.CalcPosition(v)
+			sbh := h.BucketHistogram.GetSubHistogram(idx)
+			if assert.NotNil(t, sbh, "%v: sub histogram of %v", name, v) {
+				assert.Greater(t, sbh.GetBucket(sbh.CalcPosition(v)), int64(0), "%v: bucket of %v", name, v)
+			}
+		}
+		assert.Equal(t, distinct, bucketed, name)
+
+		for _, p := range []float64{0.1, 0.5, 0.9, 0.99} {
+			v := CalcPercentileOfProduct(p, histogram_list, false)
+			prod := float64(1)
+			for _, h := range histogram_list {
+				prod *= h.GetPercentileForValue(v)
+			}
+			assert.InDelta(t, p, prod, 0.02, "%v: product of the percentiles at %v", name, v)
+		}
+	}
+}
+
+func TestBucketLayout_EvictSharedBucket(t *testing.T) {
+	for name, layout := range testLayouts() {
+		// 1000 and 1001 share a bucket of every layout, evicting the first
+		// leaves the second in it
+		histogram, err := New(WithWindowSize(2), WithAccuracy(3), WithBucketLayout(layout))
+		assert.Nil(t, err, name)
+		for _, v := range []float64{1000, 1, 1001} {
+			histogram.Enqueue(v, 1)
+		}
+		assert.Nil(t, histogram.Verify(), name)
+		idx, _, _ := histogram.BucketHistogram.CalcPosition(1001)
+		assert.NotNil(t, histogram.BucketHistogram.GetSubHistogram(idx), "%v: 1001 keeps its sub histogram", name)
+		assert.Equal(t, float64(1), histogram.GetValueAtPercentile(0.5), "%v: P50", name)
+		assert.Equal(t, float64(1), histogram.GetValueAtPercentile(0.99), "%v: P99", name)
+		assert.Equal(t, float64(1001), histogram.GetValueAtPercentile(1), "%v: P100", name)
+
+		// a sliding window crowded into few buckets answers with its samples
+		rng := rand.New(rand.NewSource(37))
+		histogram, _ = New(WithWindowSize(50), WithAccuracy(3), WithBucketLayout(layout))
+		model := &referenceModel{size: 50}
+		for i := 0; i < 2000; i++ {
+			v := float64(1000 + rng.Intn(40))
+			if rng.Intn(10) == 0 {
+				v = float64(1 + rng.Intn(5))
+			}
+			histogram.Enqueue(v, 1)
+			model.enqueue(v, 1)
+			if i%50 != 49 {
+				continue
+			}
+			if err := histogram.Verify(); err != nil {
+				t.Fatalf("%v: after %v samples: %v", name, i+1, err)
+			}
+			for _, p := range []float64{0.05, 0.25, 0.5, 0.9, 0.99, 1} {
+				assert.Equal(t, model.percentile(p), histogram.GetValueAtPercentile(p), "%v: P%v after %v samples", name, p*100, i+1)
+			}
+		}
+	}
+}
+
+func TestBucketLayout_Reconfigure(t *testing.T) {
+	histogram := NewHistogram(1000, 10, 1)
+	for i := 1; i <= 1000; i++ {
+		histogram.Enqueue(float64(i), 1)
+	}
+	c := histogram.GetConfig()
+	c.BucketLayout = NewLogLinearLayout(4)
+	assert.Nil(t, histogram.Reconfigure(c), "valid layout")
+	assert.Equal(t, c.BucketLayout, histogram.BucketHistogram.Layout, "the buckets are rebuilt with the layout")
+	assert.Equal(t, 16, histogram.GetMaximumSizeOfSubHistograms(), "buckets of the log-linear layout")
+	idx, lower, upper := histogram.BucketHistogram.CalcPosition(1000)
+	assert.Equal(t, float64(512), lower, "power of two below 1000")
+	assert.Equal(t, float64(1024), upper, "power of two above 1000")
+	assert.Equal(t, int64(9), histogram.BucketHistogram.GetSubHistogram(idx).GetBucket(15), "1000 is bucketed with 992 to 999")
+}
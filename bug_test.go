@@ -109,21 +109,18 @@ func TestBug5_MemoryLeak(t *testing.T) {
 	// The bucket list may have grown very large due to large values
 	// but small values may not use all the allocated space
 	
-	fmt.Printf("Bucket histogram sub-histograms: %d\n", len(hist.BucketHistogram.SubBucketHistograms))
+	fmt.Printf("Bucket histogram sub-histograms: %d\n", hist.BucketHistogram.GetLength())
 	
 	// Check if there are many empty buckets
 	emptyBuckets := 0
 	totalBuckets := 0
-	for _, sbh := range hist.BucketHistogram.SubBucketHistograms {
-		if sbh != nil {
-			for _, item := range sbh.BucketList {
-				totalBuckets++
-				if item == nil {
-					emptyBuckets++
-				}
-			}
+	for _, sbh := range hist.BucketHistogram.All() {
+		totalBuckets += int(sbh.GetLength())
+		for range sbh.All() {
+			emptyBuckets--
 		}
 	}
+	emptyBuckets += totalBuckets
 	
 	fmt.Printf("Empty buckets: %d/%d (%.1f%%)\n", emptyBuckets, totalBuckets, 
 		float64(emptyBuckets)/float64(totalBuckets)*100)
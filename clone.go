@@ -0,0 +1,53 @@
+package histogram
+
+import (
+	"slices"
+	"sync"
+)
+
+// Clone returns an independent deep copy of the histogram: the tree with
+// its sorted list, the buckets, the queue with its timestamps, the tracked
+// percentiles and thresholds, the moments and the tallies, taken in O(n)
+// under the lock. The SLOs and watchers stay with the original, their
+// callbacks belong to it
+func (h *Histogram) Clone() *Histogram {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	c := &Histogram{
+		QueueSize: h.QueueSize,
+		Count:     h.Count,
+		Mean:      h.Mean,
+		Variance:  h.Variance,
+		moments:   h.moments,
+		tallies:   h.tallies,
+		mutex:     &sync.Mutex{},
+	}
+	c.configure(h.config)
+
+	// the indices link the nodes, the arena copies as it is
+	c.tree = h.tree
+	c.tree.nodes = slices.Clone(h.tree.nodes)
+	for i := c.tree.min; i != 0; i = c.tree.nodes[i].larger {
+		c.BucketHistogram.Insert(&HistogramItem{Value: c.tree.nodes[i].value})
+	}
+
+	c.queue = slices.Clone(h.queue)
+	c.timestamps = slices.Clone(h.timestamps)
+
+	if h.Percentiles != nil {
+		c.Percentiles = make(map[string]*PercentileItem, len(h.Percentiles))
+		for key, p := range h.Percentiles {
+			cp := *p
+			c.Percentiles[key] = &cp
+		}
+	}
+	if h.Thresholds != nil {
+		c.Thresholds = make(map[string]*ThresholdItem, len(h.Thresholds))
+		for key, t := range h.Thresholds {
+			ct := *t
+			c.Thresholds[key] = &ct
+		}
+	}
+	return c
+}
@@ -0,0 +1,178 @@
+// Command benchcompare compares two outputs of go test -bench and flags the
+// benchmarks whose median got worse by more than a threshold:
+//
+//	go test -run=^$ -bench=. -benchmem -count=10 > old.txt
+//	# change the code
+//	go test -run=^$ -bench=. -benchmem -count=10 > new.txt
+//	go run ./cmd/benchcompare -threshold 10 old.txt new.txt
+//
+// With several runs of a benchmark a change only counts when the ranges of
+// the old and new runs do not overlap, which filters most of the noise.
+// The exit status is 1 when there is a regression.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// results holds the measurements of every run by benchmark and unit
+type results struct {
+	names  []string
+	values map[string]map[string][]float64
+}
+
+// the -N suffix of GOMAXPROCS is dropped so runs on other machines compare
+var procsSuffix = regexp.MustCompile(`-\d+$`)
+
+func parse(r io.Reader) (*results, error) {
+	res := &results{values: map[string]map[string][]float64{}}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// name, iterations, then pairs of value and unit
+		if len(fields) < 4 || len(fields)%2 != 0 || !strings.HasPrefix(fields[0], "Benchmark") {
+			continue
+		}
+		if _, err := strconv.Atoi(fields[1]); err != nil {
+			continue
+		}
+		name := procsSuffix.ReplaceAllString(fields[0], "")
+		if res.values[name] == nil {
+			res.values[name] = map[string][]float64{}
+			res.names = append(res.names, name)
+		}
+		for i := 2; i < len(fields); i += 2 {
+			v, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("%v: %v", name, err)
+			}
+			unit := fields[i+1]
+			res.values[name][unit] = append(res.values[name][unit], v)
+		}
+	}
+	return res, scanner.Err()
+}
+
+type comparison struct {
+	name       string
+	unit       string
+	old        float64
+	cur        float64
+	delta      float64
+	regression bool
+}
+
+// higherIsBetter tells the throughput units such as MB/s apart
+func higherIsBetter(unit string) bool {
+	return strings.HasSuffix(unit, "/s")
+}
+
+func median(list []float64) float64 {
+	sorted := append([]float64(nil), list...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// compare pairs the benchmarks of both results in the order of the current
+// ones, threshold is the fraction of change tolerated
+func compare(old *results, cur *results, threshold float64) []comparison {
+	list := []comparison{}
+	for _, name := range cur.names {
+		units := make([]string, 0, len(cur.values[name]))
+		for unit := range cur.values[name] {
+			units = append(units, unit)
+		}
+		sort.Strings(units)
+		for _, unit := range units {
+			before, after := old.values[name][unit], cur.values[name][unit]
+			if len(before) == 0 {
+				continue
+			}
+			c := comparison{name: name, unit: unit, old: median(before), cur: median(after)}
+			if c.old != 0 {
+				c.delta = (c.cur - c.old) / c.old
+			} else if c.cur != 0 {
+				c.delta = 1
+			}
+			worse := c.delta
+			if higherIsBetter(unit) {
+				worse = -worse
+			}
+			c.regression = worse > threshold && apart(before, after, higherIsBetter(unit))
+			list = append(list, c)
+		}
+	}
+	return list
+}
+
+// apart is true when every new run is worse than every old run,
+// or when there are too few runs to tell
+func apart(before []float64, after []float64, higher bool) bool {
+	if len(before) < 2 || len(after) < 2 {
+		return true
+	}
+	sort.Float64s(before)
+	sort.Float64s(after)
+	if higher {
+		return after[len(after)-1] < before[0]
+	}
+	return after[0] > before[len(before)-1]
+}
+
+func main() {
+	threshold := flag.Float64("threshold", 10, "percentage of change flagged as a regression")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: benchcompare [-threshold percent] old.txt new.txt\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	list := make([]*results, 2)
+	for i, path := range flag.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		list[i], err = parse(f)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", path, err)
+			os.Exit(2)
+		}
+	}
+
+	regressions := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "benchmark\tunit\told\tnew\tdelta\t")
+	for _, c := range compare(list[0], list[1], *threshold/100) {
+		mark := ""
+		if c.regression {
+			mark = "REGRESSION"
+			regressions++
+		}
+		fmt.Fprintf(w, "%v\t%v\t%.4g\t%.4g\t%+.1f%%\t%v\n", c.name, c.unit, c.old, c.cur, c.delta*100, mark)
+	}
+	w.Flush()
+	if regressions > 0 {
+		fmt.Printf("%v regressions over %v%%\n", regressions, *threshold)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+const oldRuns = `goos: linux
+BenchmarkEnqueue/uniform/eviction-8   	 4000000	       263.1 ns/op	      53 B/op	       0 allocs/op
+BenchmarkEnqueue/uniform/eviction-8   	 4000000	       270.0 ns/op	      53 B/op	       0 allocs/op
+BenchmarkEnqueue/uniform/eviction-8   	 4000000	       266.0 ns/op	      53 B/op	       0 allocs/op
+BenchmarkBucketMemory-8   	      10	 100000000 ns/op	    366401 heap-B/histogram
+BenchmarkThroughput-8   	      10	       100 ns/op	     50.00 MB/s
+PASS
+`
+
+const newRuns = `BenchmarkEnqueue/uniform/eviction-16   	 4000000	       300.0 ns/op	      53 B/op	       1 allocs/op
+BenchmarkEnqueue/uniform/eviction-16   	 4000000	       265.0 ns/op	      53 B/op	       1 allocs/op
+BenchmarkEnqueue/uniform/eviction-16   	 4000000	       310.0 ns/op	      53 B/op	       1 allocs/op
+BenchmarkBucketMemory-16   	      10	 100000000 ns/op	    500000 heap-B/histogram
+BenchmarkThroughput-16   	      10	       100 ns/op	     40.00 MB/s
+BenchmarkAdded-16   	      10	       100 ns/op
+`
+
+func TestBenchcompare_Compare(t *testing.T) {
+	old, err := parse(strings.NewReader(oldRuns))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"BenchmarkEnqueue/uniform/eviction", "BenchmarkBucketMemory", "BenchmarkThroughput"}, old.names, "GOMAXPROCS is dropped")
+	assert.Equal(t, []float64{263.1, 270, 266}, old.values["BenchmarkEnqueue/uniform/eviction"]["ns/op"], "every run")
+
+	cur, err := parse(strings.NewReader(newRuns))
+	assert.Nil(t, err)
+	flagged := map[string]bool{}
+	deltas := map[string]float64{}
+	for _, c := range compare(old, cur, 0.1) {
+		flagged[c.name+" "+c.unit] = c.regression
+		deltas[c.name+" "+c.unit] = c.delta
+	}
+	assert.False(t, flagged["BenchmarkEnqueue/uniform/eviction ns/op"], "the runs overlap")
+	assert.InDelta(t, 300.0/266-1, deltas["BenchmarkEnqueue/uniform/eviction ns/op"], 1e-9, "median")
+	assert.False(t, flagged["BenchmarkEnqueue/uniform/eviction B/op"], "unchanged")
+	assert.True(t, flagged["BenchmarkEnqueue/uniform/eviction allocs/op"], "an allocation more")
+	assert.True(t, flagged["BenchmarkBucketMemory heap-B/histogram"], "custom metric")
+	assert.True(t, flagged["BenchmarkThroughput MB/s"], "lower throughput")
+	_, ok := flagged["BenchmarkAdded ns/op"]
+	assert.False(t, ok, "nothing to compare to")
+}
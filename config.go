@@ -0,0 +1,179 @@
+package histogram
+
+import (
+	"log/slog"
+	"time"
+)
+
+// Config holds the parameters of NewHistogram
+type Config struct {
+	// maximum amount of samples in the sliding window, 0 is unbounded
+	QueueSize int64
+	// range of values covered by a sub bucket histogram, 0 is 10
+	SubBucketHistogramSize float64
+	// decimal places kept by UnifiedValue
+	Accuracy int
+	// significant digits kept by UnifiedValue, which bounds the error
+	// relative to the value; it replaces the decimal places when positive
+	SignificantDigits int
+	// layout of the bucket histogram, nil is the linear layout of
+	// SubBucketHistogramSize, or the significant layout of SignificantDigits;
+	// layouts are compared by identity when reconfiguring
+	BucketLayout BucketLayout
+	// samples older than this are evicted, 0 keeps them regardless of age
+	TimeWindow time.Duration
+	// how GetValueAtPercentile picks a value
+	PercentileMethod PercentileMethod
+	// source of the time for the time window, nil is time.Now
+	Clock func() time.Time
+	// what happens to NaN, ±Inf and the values out of [MinValue, MaxValue],
+	// a range of [0, 0] only excludes NaN and ±Inf
+	ValuePolicy ValuePolicy
+	MinValue    float64
+	MaxValue    float64
+	// receives the warnings about a corrupt tree and the verbose search
+	// of CalcPercentileOfProduct, nil is slog.Default()
+	Logger *slog.Logger
+}
+
+func (h *Histogram) GetConfig() Config {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.config
+}
+
+// Reconfigure changes the configuration in place: a smaller QueueSize
+// evicts the oldest samples, while a new accuracy, amount of significant
+// digits, sub bucket size, bucket layout, value policy or range
+// rebuilds the tree from the queue in FIFO order, keeping the tracked
+// percentiles and thresholds, the SLOs and the watchers; the raw samples
+// are not kept, so the unified values are unified again
+func (h *Histogram) Reconfigure(c Config) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+
+	h.mutex.Lock()
+	if c.Accuracy != h.config.Accuracy || c.SignificantDigits != h.config.SignificantDigits ||
+		c.SubBucketHistogramSize != h.config.SubBucketHistogramSize || c.BucketLayout != h.config.BucketLayout ||
+		c.ValuePolicy != h.config.ValuePolicy || c.MinValue != h.config.MinValue || c.MaxValue != h.config.MaxValue {
+		h.rebuild(c)
+	} else {
+		h.config = c
+		h.QueueSize = c.QueueSize
+		for h.QueueSize > 0 && h.Count > h.QueueSize {
+			if _, ok := h.dequeue(); !ok {
+				break
+			}
+		}
+	}
+	if c.TimeWindow <= 0 {
+		h.timestamps = nil
+	} else if len(h.timestamps) != len(h.queue) {
+		// a new time window starts counting the age of the samples from now
+		now := h.now().UnixNano()
+		h.timestamps = h.timestamps[:0]
+		for range h.queue {
+			h.timestamps = append(h.timestamps, now)
+		}
+	}
+	h.expire()
+	for _, slo := range h.SLOs {
+		slo.evaluate(h)
+	}
+	h.notifyWatchers()
+	callbacks := h.takeCallbacks()
+	h.mutex.Unlock()
+
+	for _, f := range callbacks {
+		f()
+	}
+	return nil
+}
+
+// rebuild enqueues the queue again under c in FIFO order, the samples
+// keep their age, the tallies, the watchers and the alerting of the SLOs
+func (h *Histogram) rebuild(c Config) {
+	values := append([]float64(nil), h.queue...)
+	stamps := append([]int64(nil), h.timestamps...)
+	// the rebuild is silent, the caller notifies the watchers and SLOs
+	// once for the whole change
+	watchers := h.watchers
+	alerting := make([]bool, len(h.SLOs))
+	for i, slo := range h.SLOs {
+		alerting[i] = slo.alerting
+	}
+	tallies := h.tallies
+	h.watchers = nil
+	h.reset()
+	h.configure(c)
+	for i, v := range values {
+		if len(stamps) == len(values) {
+			// the samples keep their age
+			stamp := stamps[i]
+			h.config.Clock = func() time.Time { return time.Unix(0, stamp) }
+		}
+		h.enqueue(v, 1)
+	}
+	h.config.Clock = c.Clock
+	// the values out of a new range were just counted
+	h.tallies.add(tallies)
+	h.watchers = watchers
+	h.callbacks = nil
+	for i, slo := range h.SLOs {
+		slo.alerting = alerting[i]
+	}
+}
+
+func (h *Histogram) now() time.Time {
+	if h.config.Clock != nil {
+		return h.config.Clock()
+	}
+	return time.Now()
+}
+
+func (h *Histogram) logger() *slog.Logger {
+	if h.config.Logger != nil {
+		return h.config.Logger
+	}
+	return slog.Default()
+}
+
+// Expire evicts the samples older than the time window, which Enqueue
+// does on its own; it returns the amount of evicted samples
+func (h *Histogram) Expire() int {
+	h.mutex.Lock()
+	count := h.Count
+	if _, ok := h.expire(); ok {
+		for _, slo := range h.SLOs {
+			slo.evaluate(h)
+		}
+		h.notifyWatchers()
+	}
+	count -= h.Count
+	callbacks := h.takeCallbacks()
+	h.mutex.Unlock()
+
+	for _, f := range callbacks {
+		f()
+	}
+	return int(count)
+}
+
+// expire returns the value of the last evicted sample like enqueue,
+// and whether there is one
+func (h *Histogram) expire() (float64, bool) {
+	if h.config.TimeWindow <= 0 {
+		return 0, false
+	}
+	result, expired := float64(0), false
+	oldest := h.now().Add(-h.config.TimeWindow).UnixNano()
+	for len(h.timestamps) > 0 && h.timestamps[0] < oldest {
+		v, ok := h.dequeue()
+		if !ok {
+			break
+		}
+		result, expired = v, true
+	}
+	return result, expired
+}
@@ -0,0 +1,92 @@
+package histogram
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReset_KeepsConfiguration(t *testing.T) {
+	histogram := NewHistogram(100, float64(10), 1)
+	histogram.AddPercentilePoint(0.5)
+	histogram.AddThresholdPoint(50)
+	slo, _ := histogram.AddSLO(50, 0.9)
+	resolved := 0
+	histogram.OnSLOResolve(slo, func(s *SLOStatus) { resolved++ })
+
+	for i := 0; i < 150; i++ {
+		histogram.Enqueue(float64(i), 1)
+	}
+	assert.True(t, histogram.GetSLOStatus(slo).Alerting, "most samples are above the threshold")
+
+	histogram.Reset()
+	assert.Equal(t, int64(0), histogram.Count, "count should be zero")
+	assert.Equal(t, 0, len(histogram.queue), "queue should be empty")
+	assert.Nil(t, histogram.Tree(), "tree should be empty")
+	assert.Equal(t, float64(0), histogram.Mean, "mean should be zero")
+	assert.Equal(t, 0, histogram.GetLengthOfSubHistograms(), "buckets should be empty")
+	assert.Equal(t, 1, resolved, "reset resolves the alerting SLO")
+	assert.Equal(t, int64(100), histogram.QueueSize, "window size is kept")
+	assert.Equal(t, []float64{0.5}, histogram.ListPercentilePoints(), "tracked percentiles are kept")
+
+	for i := 1; i <= 10; i++ {
+		histogram.Enqueue(float64(i), 1)
+	}
+	assert.Equal(t, float64(5), histogram.GetValueAtPercentile(0.5), "tracked percentile works after reset")
+	assert.Equal(t, float64(1), histogram.GetFractionBelow(50), "threshold works after reset")
+	assert.Equal(t, int64(10), histogram.GetSLOStatus(slo).Good, "SLO works after reset")
+	assert.Equal(t, float64(5.5), histogram.Mean, "moments work after reset")
+}
+
+func TestReconfigure_InPlace(t *testing.T) {
+	rng := rand.New(rand.NewSource(34))
+	histogram := NewHistogram(1000, float64(10), 2)
+	histogram.AddPercentilePoint(0.9)
+	histogram.AddThresholdPoint(50)
+	list := make([]float64, 1000)
+	for i := range list {
+		list[i] = rng.Float64() * 100
+		histogram.Enqueue(list[i], 1)
+	}
+
+	// shrinking the window evicts the oldest samples
+	c := histogram.GetConfig()
+	c.QueueSize = 500
+	assert.Nil(t, histogram.Reconfigure(c), "valid configuration")
+	assert.Equal(t, int64(500), histogram.Count, "the window should be shrunk")
+	assert.Equal(t, histogram.UnifiedValue(list[500]), histogram.queue[0], "the oldest samples are evicted")
+
+	// a coarser accuracy and sub bucket size rebuild the tree
+	c.Accuracy = 0
+	c.SubBucketHistogramSize = 50
+	assert.Nil(t, histogram.Reconfigure(c), "valid configuration")
+	assert.Equal(t, int64(500), histogram.Count, "rebuilding keeps the samples")
+	assert.Equal(t, int64(500), histogram.tree.total(), "rebuilding keeps the samples")
+	assert.Equal(t, float64(50), histogram.BucketHistogram.SubBucketHistogramSize, "new sub bucket size")
+
+	expected := NewHistogram(500, float64(50), 0)
+	expected.AddPercentilePoint(0.9)
+	mean := float64(0)
+	below := int64(0)
+	unified := make([]float64, 500)
+	for i, v := range list[500:] {
+		// the raw samples are not kept, the stored values are unified again
+		unified[i] = math.Round(math.Round(v*100) / 100)
+		expected.Enqueue(unified[i], 1)
+		mean += unified[i]
+		if unified[i] <= 50 {
+			below++
+		}
+	}
+	for i, v := range histogram.queue {
+		assert.Equal(t, unified[i], v, "values are unified with the new accuracy in FIFO order")
+	}
+	assert.Equal(t, expected.GetValueAtPercentile(0.9), histogram.GetValueAtPercentile(0.9), "tracked percentile after rebuild")
+	assert.InDelta(t, mean/500, histogram.Mean, 1e-9, "moments after rebuild")
+	assert.Equal(t, float64(below)/500, histogram.GetFractionBelow(50), "thresholds after rebuild")
+
+	assert.NotNil(t, histogram.Reconfigure(Config{QueueSize: -1}), "negative window is rejected")
+	assert.NotNil(t, histogram.Reconfigure(Config{SubBucketHistogramSize: math.NaN()}), "NaN sub bucket size is rejected")
+	assert.Equal(t, c, histogram.GetConfig(), "rejected configuration is not applied")
+}
@@ -16,7 +16,7 @@ func TestDebugPercentileTracking(t *testing.T) {
 	hist.AddPercentilePoint(0.5) // P50
 	
 	fmt.Printf("\nInitial state:\n")
-	fmt.Printf("  RootItem: %v\n", hist.RootItem)
+	fmt.Printf("  RootItem: %v\n", hist.Tree())
 	fmt.Printf("  Percentiles: %v\n", hist.Percentiles)
 	
 	// Add data one by one and track percentile behavior
@@ -24,17 +24,19 @@ func TestDebugPercentileTracking(t *testing.T) {
 		fmt.Printf("\n--- Adding value %.1f (step %d) ---\n", value, i+1)
 		
 		// Before adding
-		if hist.RootItem != nil {
+		if root := hist.Tree(); root != nil {
+			stats := hist.GetStatistics()
 			fmt.Printf("  Before: RootCount=%d, MinItem=%.1f, MaxItem=%.1f\n", 
-				hist.RootItem.Count, hist.MinItem.Value, hist.MaxItem.Value)
+				root.Count, stats.Min, stats.Max)
 		}
 		
 		// Add the value
 		hist.Enqueue(value, 1)
 		
 		// After adding
+		stats := hist.GetStatistics()
 		fmt.Printf("  After: RootCount=%d, MinItem=%.1f, MaxItem=%.1f\n", 
-			hist.RootItem.Count, hist.MinItem.Value, hist.MaxItem.Value)
+			hist.Tree().Count, stats.Min, stats.Max)
 		
 		// Check percentile
 		p50 := hist.GetValueAtPercentile(0.5)
@@ -44,7 +46,7 @@ func TestDebugPercentileTracking(t *testing.T) {
 		pItem := hist.GetPercentileItem(0.5)
 		if pItem != nil {
 			fmt.Printf("  PercentileItem: Item=%.1f, Count=%d, RealPercentage=%.3f\n", 
-				pItem.Item.Value, pItem.Count, pItem.RealPercentage)
+				pItem.Value, pItem.Count, pItem.RealPercentage)
 		}
 	}
 	
@@ -57,9 +59,9 @@ func TestDebugPercentileTracking(t *testing.T) {
 	fmt.Printf("Target count for P50: %d (out of %d)\n", targetCount, len(data))
 	
 	// Walk through the tree to understand the cumulative counts
-	if hist.RootItem != nil {
+	if root := hist.Tree(); root != nil {
 		fmt.Printf("\nTree structure:\n")
-		printTree(hist.RootItem, 0)
+		printTree(root, 0)
 	}
 }
 
@@ -0,0 +1,53 @@
+package histogram
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+var (
+	// ErrInvalidValue is wrapped by the errors for the samples refused by
+	// RejectInvalidValues and for the percentiles outside of [0, 1]
+	ErrInvalidValue = errors.New("histogram: invalid value")
+	// ErrCorruptTree is wrapped by the errors for the links of the tree that
+	// cannot be right, the operation is abandoned before changing anything
+	ErrCorruptTree = errors.New("histogram: corrupt tree")
+	// ErrEmpty is returned by the operations needing at least one sample
+	ErrEmpty = errors.New("histogram: empty")
+)
+
+// EnqueueE is Enqueue returning ErrInvalidValue for a count below 1 and for
+// the samples refused by RejectInvalidValues, and ErrCorruptTree when the
+// sample cannot be inserted
+func (h *Histogram) EnqueueE(incomingValue float64, count int) (item *HistogramItem, err error) {
+	evicted, err := h.enqueueAndNotify(incomingValue, count, true)
+	if evicted.Count > 0 {
+		item = &evicted
+	}
+	return item, err
+}
+
+// DequeueE is Dequeue returning ErrEmpty for an empty window and
+// ErrCorruptTree when the oldest sample is not in the tree
+func (h *Histogram) DequeueE() (*HistogramItem, error) {
+	item, err := h.dequeueAndNotify(true)
+	if item.Count == 0 {
+		return nil, err
+	}
+	return &item, err
+}
+
+// PercentileE is GetValueAtPercentile returning ErrInvalidValue for p
+// outside of [0, 1] and ErrEmpty for an empty window instead of 0
+func (h *Histogram) PercentileE(p float64) (float64, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if math.IsNaN(p) || p < 0 || p > 1 {
+		return 0, fmt.Errorf("%w: percentile %v is outside [0, 1]", ErrInvalidValue, p)
+	}
+	if h.tree.root == 0 {
+		return 0, ErrEmpty
+	}
+	return h.valueAtPercentile(p), nil
+}
@@ -0,0 +1,104 @@
+package histogram
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"math"
+	"strings"
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrors_EmptyHistogram(t *testing.T) {
+	histogram := NewHistogram(100, 1, 1)
+	_, err := histogram.DequeueE()
+	assert.True(t, errors.Is(err, ErrEmpty), "dequeue")
+	_, err = histogram.PercentileE(0.5)
+	assert.True(t, errors.Is(err, ErrEmpty), "percentile")
+
+	for i := 1; i <= 10; i++ {
+		histogram.Enqueue(float64(i), 1)
+	}
+	v, err := histogram.PercentileE(0.9)
+	assert.Nil(t, err, "valid percentile")
+	assert.Equal(t, histogram.GetValueAtPercentile(0.9), v, "same as GetValueAtPercentile")
+	for _, p := range []float64{-0.1, 1.1, math.NaN()} {
+		_, err = histogram.PercentileE(p)
+		assert.True(t, errors.Is(err, ErrInvalidValue), "percentile %v", p)
+	}
+	item, err := histogram.DequeueE()
+	assert.Nil(t, err, "dequeue")
+	assert.Equal(t, float64(1), item.Value, "the oldest sample")
+}
+
+func TestErrors_CorruptTree(t *testing.T) {
+	var buf bytes.Buffer
+	histogram, _ := New(WithWindowSize(100), WithLogger(slog.New(slog.NewTextHandler(&buf, nil))))
+	for i := 1; i <= 3; i++ {
+		histogram.Enqueue(float64(i), 1)
+	}
+	tree := &histogram.tree
+	root := tree.root
+	assert.Equal(t, float64(2), tree.nodes[root].value, "balanced")
+
+	// a child identical to its parent
+	left := tree.nodes[root].left
+	tree.nodes[root].left = root
+	_, err := histogram.EnqueueE(0, 1)
+	assert.True(t, errors.Is(err, ErrCorruptTree), "identical child")
+	assert.Equal(t, int64(3), histogram.Count, "nothing is enqueued")
+	assert.Equal(t, root, tree.nodes[root].left, "the tree is not repaired behind the back")
+
+	// a cycle of the children
+	tree.nodes[root].left = left
+	tree.nodes[left].left = root
+	_, err = histogram.EnqueueE(0, 1)
+	assert.True(t, errors.Is(err, ErrCorruptTree), "cycle")
+	assert.Equal(t, int64(3), histogram.Count, "nothing is enqueued")
+
+	assert.Equal(t, "", buf.String(), "the E variants do not log")
+	histogram.Enqueue(0, 1)
+	assert.True(t, strings.Contains(buf.String(), "corrupt tree"), "the logger receives the warning")
+	tree.nodes[left].left = 0
+
+	// a queued sample missing from the tree
+	histogram.queue[0] = 7
+	_, err = histogram.DequeueE()
+	assert.True(t, errors.Is(err, ErrCorruptTree), "detached sample")
+	assert.Equal(t, int64(3), histogram.Count, "nothing is dequeued")
+	assert.Nil(t, histogram.Dequeue(), "Dequeue gives up as well")
+}
+
+func TestErrors_InvalidCount(t *testing.T) {
+	histogram := NewHistogram(100, 1, 1)
+	histogram.AddPercentilePoint(0.5)
+	histogram.Enqueue(3, 1)
+	for _, count := range []int{0, -3} {
+		_, err := histogram.EnqueueE(5, count)
+		assert.True(t, errors.Is(err, ErrInvalidValue), "count %v", count)
+		assert.Nil(t, histogram.Enqueue(5, count), "count %v", count)
+		assert.Equal(t, int64(1), histogram.Count, "count %v is not enqueued", count)
+		assert.Equal(t, int32(0), histogram.tree.find(5), "count %v leaves no node", count)
+		assert.Nil(t, histogram.Verify(), "count %v", count)
+	}
+	assert.Equal(t, int64(0), histogram.GetValueTallies().Total(), "not an invalid value")
+	assert.Equal(t, float64(3), histogram.GetValueAtPercentile(0.5), "the tracked percentile")
+}
+
+func TestErrors_InsertOnCorruptTree(t *testing.T) {
+	var buf bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+
+	root := NewHistogramItem(2)
+	root.Insert(1, 1, 0)
+	root.Left = root
+	item, newRoot := root.Insert(0, 1, 0)
+	assert.Nil(t, item, "nothing is inserted")
+	assert.Nil(t, newRoot, "the root is unchanged")
+	assert.Equal(t, root, root.Left, "the identical child is not cut off")
+	assert.Empty(t, buf.String(), "a tree without a histogram logs nothing")
+	_, _, err := root.InsertE(0, 1)
+	assert.True(t, errors.Is(err, ErrCorruptTree), "InsertE returns the error")
+}
@@ -0,0 +1,173 @@
+package histogram
+
+import (
+	"math"
+	"sort"
+	"testing"
+)
+
+// referenceModel is the window as a FIFO queue and a sorted slice of samples
+type referenceModel struct {
+	size   int
+	queue  []float64
+	sorted []float64
+}
+
+func (m *referenceModel) enqueue(v float64, count int) {
+	for i := 0; i < count; i++ {
+		m.queue = append(m.queue, v)
+		at := sort.SearchFloat64s(m.sorted, v)
+		m.sorted = append(m.sorted, 0)
+		copy(m.sorted[at+1:], m.sorted[at:])
+		m.sorted[at] = v
+	}
+	for m.size > 0 && len(m.queue) > m.size {
+		m.dequeue()
+	}
+}
+
+func (m *referenceModel) dequeue() {
+	if len(m.queue) == 0 {
+		return
+	}
+	v := m.queue[0]
+	m.queue = m.queue[1:]
+	at := sort.SearchFloat64s(m.sorted, v)
+	m.sorted = append(m.sorted[:at], m.sorted[at+1:]...)
+}
+
+// rank is the amount of samples no larger than v
+func (m *referenceModel) rank(v float64) int {
+	return sort.Search(len(m.sorted), func(i int) bool { return m.sorted[i] > v })
+}
+
+// percentile is the largest sample whose rank over the total is no larger
+// than p, the smallest sample when there is none, as tracked by PercentileItem
+func (m *referenceModel) percentile(p float64) float64 {
+	result := m.sorted[0]
+	n := float64(len(m.sorted))
+	for i, v := range m.sorted {
+		if (i+1 == len(m.sorted) || m.sorted[i+1] != v) && float64(i+1)/n <= p {
+			result = v
+		}
+	}
+	return result
+}
+
+func (m *referenceModel) meanAndVariance() (float64, float64) {
+	mean, variance := float64(0), float64(0)
+	for _, v := range m.sorted {
+		mean += v
+	}
+	mean /= float64(len(m.sorted))
+	for _, v := range m.sorted {
+		variance += (v - mean) * (v - mean)
+	}
+	return mean, variance / float64(len(m.sorted))
+}
+
+// fuzzConfigs are the accuracies and layouts FuzzHistogramOperations picks
+// from, with the scale of the int8 values so that some share a unified value
+// or a bucket
+var fuzzConfigs = []struct {
+	scale   float64
+	options []Option
+}{
+	{1, []Option{WithAccuracy(1), WithSubBucketSize(8)}},
+	{0.37, []Option{WithAccuracy(2), WithBucketLayout(NewLogLinearLayout(2))}},
+	{1.3, []Option{WithAccuracy(0), WithBucketLayout(NewLogarithmicLayout(0.05, 16))}},
+	{10, []Option{WithSignificantDigits(2)}},
+}
+
+// FuzzHistogramOperations drives a histogram and the reference model with
+// the same operations, decoded from pairs of bytes after the first, which
+// sets the window size modulo 65 and the configuration from fuzzConfigs
+// above it; the corpus is in testdata/fuzz
+//
+// go test -run=^$ -fuzz=FuzzHistogramOperations -fuzztime=1m
+func FuzzHistogramOperations(f *testing.F) {
+	f.Add([]byte{8, 0, 5, 0, 3, 1, 5, 2, 0, 3, 50, 4, 0})
+	f.Add([]byte{0, 0, 10, 1, 20, 1, 20, 3, 100, 3, 0, 4, 0, 2, 0, 4, 0})
+	f.Add([]byte{3, 0, 255, 0, 128, 0, 127, 0, 1, 3, 90, 2, 0, 4, 0})
+	f.Add([]byte{5, 3, 10, 3, 33, 1, 7, 0, 7, 1, 7, 2, 0, 2, 0, 2, 0, 4, 0})
+	f.Add([]byte{65 + 2, 0, 232, 0, 1, 0, 233, 4, 0, 0, 5, 4, 0})
+	f.Add([]byte{130 + 3, 0, 100, 0, 1, 0, 101, 0, 102, 4, 0, 2, 0, 4, 0})
+	f.Add([]byte{195 + 4, 1, 12, 0, 13, 0, 130, 3, 50, 0, 14, 4, 0, 2, 0, 4, 0})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if len(data) == 0 {
+			return
+		}
+		size := int(data[0] % 65)
+		config := fuzzConfigs[int(data[0]/65)%len(fuzzConfigs)]
+		window := WithWindowSize(int64(size))
+		if size == 0 {
+			window = WithUnboundedWindow()
+		}
+		histogram, err := New(append([]Option{window}, config.options...)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		model := &referenceModel{size: size}
+		tracked := []float64{}
+
+		for i := 1; i+1 < len(data); i += 2 {
+			op, arg := data[i]%5, data[i+1]
+			// the model holds the values as the histogram unifies them
+			v := histogram.UnifiedValue(float64(int8(arg)) * config.scale)
+			switch op {
+			case 0, 1:
+				count := 1 + int(op)*int(arg%3)
+				histogram.Enqueue(v, count)
+				model.enqueue(v, count)
+			case 2:
+				histogram.Dequeue()
+				model.dequeue()
+			case 3:
+				p := float64(arg%101) / 100
+				histogram.AddPercentilePoint(p)
+				tracked = append(tracked, p)
+			case 4:
+				for _, x := range model.sorted {
+					want := float64(model.rank(x)) / float64(len(model.sorted))
+					if got := histogram.GetPercentileForValue(x); got != want {
+						t.Fatalf("op %v: rank of %v is %v, want %v", i/2, x, got, want)
+					}
+				}
+				// the untracked percentiles descend the tree, the product of
+				// the histogram alone searches through the buckets
+				for p := float64(arg%7) / 100; len(model.sorted) > 0 && p <= 1; p += 0.07 {
+					if got, want := histogram.GetValueAtPercentile(p), model.percentile(p); got != want {
+						t.Fatalf("op %v: untracked percentile %v is %v, want %v", i/2, p, got, want)
+					}
+					if got, want := CalcPercentileOfProduct(p, []*Histogram{histogram}, false), model.percentile(p); got != want {
+						t.Fatalf("op %v: percentile %v of the buckets is %v, want %v", i/2, p, got, want)
+					}
+				}
+			}
+
+			if err := histogram.Verify(); err != nil {
+				t.Fatalf("op %v: %v", i/2, err)
+			}
+			if histogram.Count != int64(len(model.sorted)) {
+				t.Fatalf("op %v: %v samples, want %v", i/2, histogram.Count, len(model.sorted))
+			}
+			if len(model.sorted) == 0 {
+				continue
+			}
+			if s := histogram.GetStatistics(); s.Min != model.sorted[0] || s.Max != model.sorted[len(model.sorted)-1] {
+				t.Fatalf("op %v: range [%v, %v], want [%v, %v]", i/2, s.Min, s.Max,
+					model.sorted[0], model.sorted[len(model.sorted)-1])
+			}
+			for _, p := range tracked {
+				if got, want := histogram.GetValueAtPercentile(p), model.percentile(p); got != want {
+					t.Fatalf("op %v: percentile %v is %v, want %v", i/2, p, got, want)
+				}
+			}
+			mean, variance := model.meanAndVariance()
+			if math.Abs(histogram.Mean-mean) > 1e-6 || math.Abs(histogram.Variance-variance) > 1e-6*(1+variance) {
+				t.Fatalf("op %v: mean %v and variance %v, want %v and %v", i/2, histogram.Mean, histogram.Variance, mean, variance)
+			}
+		}
+	})
+}
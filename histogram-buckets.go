@@ -1,17 +1,26 @@
 package histogram
 
 import (
-    "log"
     // "fmt"
+    "iter"
     "math"
 )
 
 // Histogram bucket
 type SubBucketHistogram struct {
+    // Deprecated: the buckets are stored sparsely and this list is no
+    // longer filled; use GetBucket, GetLength and All instead
     BucketList []*HistogramItem
+    // width of the first bucket, which every bucket shares
+    // apart from the logarithmic layout
     BucketSize float64
     LowerBoundary float64
     UpperBoundary float64
+
+    layout BucketLayout
+    index int64
+    // the amount of distinct values of each bucket
+    buckets sparseList[int64]
 }
 
 func NewSubBucketHistogram(unit float64, lower float64, upper float64) *SubBucketHistogram{
@@ -24,45 +33,36 @@ func NewSubBucketHistogram(unit float64, lower float64, upper float64) *SubBucke
 }
 
 func (sb *SubBucketHistogram) CalcPosition(v float64) int64 {
+	if sb.layout != nil {
+		return sb.layout.Bucket(sb.index, v)
+	}
 	if sb.BucketSize == 0 {
 		return int64(-1)
 	}
-	idx_value := (v-sb.LowerBoundary)/sb.BucketSize
-	if math.Abs(sb.BucketSize) < 1 {
-		idx_value = math.Round(idx_value)
-	}
-	idx := int64(idx_value)
+	// the values are unified to the buckets, rounding away the float error
+	idx := int64(math.Round((v-sb.LowerBoundary)/sb.BucketSize))
 	if idx < 0 {
 		return int64(-1) // signal invalid/negative index
 	}
 	return idx
 }
 
-const maxBucketIndex = 100000 // reasonable upper bound for bucket indices
+// upper bound of the buckets of a sub histogram for the layouts
+// with a configurable amount of them
+const maxBucketIndex = 100000
 
+// Insert counts the value of a node in its bucket, a bucket wider than
+// the accuracy counts every distinct value falling into it
 func (sb *SubBucketHistogram) Insert(n *HistogramItem) {
 
 	if n == nil {return}
 
-	if sb.BucketList == nil {
-		sb.BucketList = []*HistogramItem{}
-	}
-
 	idx := sb.CalcPosition(n.Value)
-	if idx < 0 || idx > maxBucketIndex {
-		// Do not insert if index is negative or too large
+	if idx < 0 {
+		// Do not insert if index is negative
 		return
 	}
-
-	cur_len := int64(len(sb.BucketList))
-	for i:=int64(0);i<idx-cur_len+1;i++{
-		sb.BucketList = append(sb.BucketList, nil)
-	}
-	if sb.BucketList[idx] != nil {
-		log.Printf("bucket is not empty for %v, there exists %v", n.Value, sb.BucketList[idx].Value)
-		log.Printf("    bucket list length: %v, index: %v", len(sb.BucketList), idx)
-	}
-	sb.BucketList[idx] = n
+	sb.buckets.set(idx, sb.buckets.get(idx)+1)
 }
 
 func (sb *SubBucketHistogram) Delete(n *HistogramItem) {
@@ -71,77 +71,178 @@ func (sb *SubBucketHistogram) Delete(n *HistogramItem) {
 	if idx < 0 {
 		return
 	}
-	if idx < int64(len(sb.BucketList)) {
-		sb.BucketList[idx] = nil
+	if count := sb.buckets.get(idx); count > 0 {
+		sb.buckets.set(idx, count-1)
 	}
 }
 
+// GetBucket returns the amount of distinct values in a bucket
+func (sb *SubBucketHistogram) GetBucket(idx int64) int64 {
+	return sb.buckets.get(idx)
+}
+
+// GetLength returns the index of the last non-empty bucket plus one
+func (sb *SubBucketHistogram) GetLength() int64 {
+	return sb.buckets.length()
+}
+
+// All yields the non-empty buckets and their amount of distinct values
+// in ascending order
+func (sb *SubBucketHistogram) All() iter.Seq2[int64, int64] {
+	return sb.buckets.all()
+}
+
 // Top level bucket histogram
 
+// the sub histograms and their buckets are stored in pages of consecutive
+// indices, so a far outlier only costs a page instead of the gap before it
 type BucketHistogram struct {
+    // Deprecated: the sub histograms are stored sparsely and this list is
+    // no longer filled; use GetSubHistogram, GetLength and All instead
     SubBucketHistograms []*SubBucketHistogram
+    // the parameters of the linear layout built by NewBucketHistogram
     SubBucketHistogramSize float64
     BucketSize float64
+    Layout BucketLayout
+    subHistograms sparseList[*SubBucketHistogram]
+
+    // the emptied pages and sub histograms, reused as the window slides
+    subHistogramPages sparsePool[*SubBucketHistogram]
+    bucketPages sparsePool[int64]
+    freeSubHistograms []*SubBucketHistogram
 }
 
 func NewBucketHistogram( subhistogramSize float64, bucketSize float64) *BucketHistogram{
     var buckets *BucketHistogram = &BucketHistogram{
         BucketSize: bucketSize,
         SubBucketHistogramSize: subhistogramSize,
+        Layout: &LinearLayout{
+            SubHistogramSize: subhistogramSize,
+            BucketSize: bucketSize,
+        },
+    }
+    buckets.subHistograms.pool = &buckets.subHistogramPages
+    return buckets
+}
+
+func NewBucketHistogramWithLayout(layout BucketLayout) *BucketHistogram{
+    var buckets *BucketHistogram = &BucketHistogram{
+        Layout: layout,
+    }
+    buckets.subHistograms.pool = &buckets.subHistogramPages
+    if l, ok := layout.(*LinearLayout); ok {
+        buckets.SubBucketHistogramSize = l.SubHistogramSize
+        buckets.BucketSize = l.BucketSize
     }
     return buckets
 }
 
 func (b *BucketHistogram) CalcPosition(v float64) (int64, float64, float64) {
-    if b.SubBucketHistogramSize == 0 {
+    idx := b.Layout.SubHistogram(v)
+    if idx < 0 {
         return int64(-1), float64(-1), float64(-1)
     }
-    idx_value := v/b.SubBucketHistogramSize
-    if math.Abs(b.SubBucketHistogramSize) < 1 {
-        idx_value = math.Round(idx_value)
-    }
-    idx := int64(idx_value)
     lower, upper := b.GetLowerAndUpperBoundaries(idx)
     return idx, lower, upper
 }
 
+// position returns the sub histogram and the bucket of v, -1 when the
+// buckets do not cover it
+func (b *BucketHistogram) position(v float64) (int64, int64) {
+    idx := b.Layout.SubHistogram(v)
+    if idx < 0 {
+        return int64(-1), int64(-1)
+    }
+    if sb := b.subHistograms.get(idx); sb != nil {
+        return idx, sb.CalcPosition(v)
+    }
+    return idx, b.Layout.Bucket(idx, v)
+}
+
 func (b *BucketHistogram) GetLowerAndUpperBoundaries(idx int64) (float64, float64) {
-    lower := float64(idx)*b.SubBucketHistogramSize
-    upper := float64(idx+1)*b.SubBucketHistogramSize
-    return lower, upper
+    return b.Layout.Boundaries(idx)
+}
+
+// GetValueOfBucket returns the lower boundary of a bucket
+func (b *BucketHistogram) GetValueOfBucket(idx int64, bucket int64) float64 {
+    return b.Layout.BucketValue(idx, bucket)
+}
+
+// GetMaximumSizeOfSubHistograms returns the amount of buckets of a sub histogram
+func (b *BucketHistogram) GetMaximumSizeOfSubHistograms() int {
+    return b.Layout.Buckets()
+}
+
+// GetSubHistogram returns a sub histogram, nil when it holds no value
+func (b *BucketHistogram) GetSubHistogram(idx int64) *SubBucketHistogram {
+    return b.subHistograms.get(idx)
+}
+
+// GetLength returns the index of the last sub histogram plus one
+func (b *BucketHistogram) GetLength() int64 {
+    return b.subHistograms.length()
+}
+
+// All yields the sub histograms holding values in ascending order
+func (b *BucketHistogram) All() iter.Seq2[int64, *SubBucketHistogram] {
+    return b.subHistograms.all()
 }
 
 func (b *BucketHistogram) Insert(n *HistogramItem) {
     if n == nil {return}
 
-    if b.SubBucketHistograms == nil {
-        b.SubBucketHistograms = []*SubBucketHistogram{}
-    } 
-
     idx, lower, upper := b.CalcPosition(n.Value)
-    cur_len := int64(len(b.SubBucketHistograms))
-    for i:=int64(0);i<1+idx-cur_len;i++{
-        b.SubBucketHistograms = append(b.SubBucketHistograms, nil)
+    if idx < 0 {
+        // not covered by the buckets, the tree still holds the value
+        return
     }
 
-    if b.SubBucketHistograms[idx] == nil {
-        b.SubBucketHistograms[idx] = NewSubBucketHistogram(b.BucketSize, lower, upper)
+    sb := b.subHistograms.get(idx)
+    if sb == nil {
+        width := b.GetValueOfBucket(idx, 1) - b.GetValueOfBucket(idx, 0)
+        if l, ok := b.Layout.(*LinearLayout); ok {
+            // exact, without the float error of the difference
+            width = l.BucketSize
+        }
+        if n := len(b.freeSubHistograms); n > 0 {
+            sb = b.freeSubHistograms[n-1]
+            b.freeSubHistograms = b.freeSubHistograms[:n-1]
+            sb.BucketSize, sb.LowerBoundary, sb.UpperBoundary = width, lower, upper
+        } else {
+            sb = NewSubBucketHistogram(width, lower, upper)
+        }
+        sb.layout = b.Layout
+        sb.index = idx
+        sb.buckets.pool = &b.bucketPages
+        b.subHistograms.set(idx, sb)
     } 
 
-    // log.Printf("subhistogram list length: %v, index: %v, value: %v, lower: %v, upper: %v", len(b.SubBucketHistograms), idx, n.Value, lower, upper)
+    // log.Printf("subhistogram list length: %v, index: %v, value: %v, lower: %v, upper: %v", b.GetLength(), idx, n.Value, lower, upper)
 
 
-    b.SubBucketHistograms[idx].Insert(n)
+    sb.Insert(n)
 
 }
 
 func (b *BucketHistogram) Delete(n *HistogramItem) {
     if n == nil {return}
     idx, _, _ := b.CalcPosition(n.Value)
-    if idx < int64(len(b.SubBucketHistograms)) {
-        if b.SubBucketHistograms[idx] != nil {
-            b.SubBucketHistograms[idx].Delete(n)
+    if sb := b.subHistograms.get(idx); sb != nil {
+        sb.Delete(n)
+        if len(sb.buckets.pages) == 0 {
+            // the window slid past the sub histogram
+            b.subHistograms.set(idx, nil)
+            b.freeSubHistograms = append(b.freeSubHistograms, sb)
         }
     }
 }
 
+
+// Reset drops every sub histogram but keeps their memory for reuse
+func (b *BucketHistogram) Reset() {
+    for _, sb := range b.subHistograms.all() {
+        sb.buckets.reset()
+        b.freeSubHistograms = append(b.freeSubHistograms, sb)
+    }
+    b.subHistograms.reset()
+}
@@ -0,0 +1,91 @@
+package histogram
+
+import (
+	"math"
+	"math/rand"
+	"runtime"
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSparseBuckets_List(t *testing.T) {
+	l := sparseList[*HistogramItem]{}
+	a, b, c := NewHistogramItem(1), NewHistogramItem(2), NewHistogramItem(3)
+	l.set(1000000, c)
+	l.set(3, a)
+	l.set(70, b)
+	assert.Equal(t, 3, len(l.pages), "a page per far apart entry")
+	assert.Equal(t, int64(1000001), l.length(), "length up to the last entry")
+	assert.Equal(t, 3, l.count(), "amount of entries")
+	assert.Equal(t, b, l.get(70), "get an entry")
+	assert.Nil(t, l.get(71), "missing entry in a page")
+	assert.Nil(t, l.get(500), "missing page")
+	assert.Nil(t, l.get(-1), "negative index")
+
+	indices := []int64{}
+	for idx, item := range l.all() {
+		indices = append(indices, idx)
+		assert.Equal(t, l.get(idx), item, "yielded entry")
+	}
+	assert.Equal(t, []int64{3, 70, 1000000}, indices, "ascending order")
+
+	l.set(1000000, nil)
+	assert.Equal(t, 2, len(l.pages), "an empty page is dropped")
+	assert.Equal(t, int64(71), l.length(), "length after removing the last entry")
+	l.set(70, nil)
+	l.set(70, nil)
+	assert.Equal(t, 1, l.count(), "clearing twice")
+	l.reset()
+	assert.Equal(t, int64(0), l.length(), "empty after reset")
+}
+
+func TestSparseBuckets_Outlier(t *testing.T) {
+	histogram := NewHistogram(100, 1, 1)
+	for i := 0; i < 99; i++ {
+		histogram.Enqueue(float64(i%10), 1)
+	}
+	histogram.Enqueue(1e6, 1)
+	b := histogram.BucketHistogram
+	assert.Equal(t, int64(1e6+1), b.GetLength(), "the outlier is the last sub histogram")
+	assert.Equal(t, 2, len(b.subHistograms.pages), "the gap before the outlier costs nothing")
+	assert.Equal(t, int64(1), b.GetSubHistogram(1e6).GetBucket(0), "the outlier is bucketed")
+	assert.Equal(t, float64(1e6), histogram.GetValueAtPercentile(1), "the outlier is found by the search")
+	assert.Nil(t, b.SubBucketHistograms, "the deprecated list is not filled")
+	assert.Nil(t, b.GetSubHistogram(3).BucketList, "nor are the deprecated buckets")
+
+	histogram.Enqueue(5, 100)
+	assert.Nil(t, b.GetSubHistogram(1e6), "an emptied sub histogram is dropped")
+	assert.Equal(t, int64(6), b.GetLength(), "length after the window slid")
+}
+
+// pareto returns heavy-tailed samples from 1 upwards, a few reaching millions
+func pareto(rng *rand.Rand, n int, alpha float64) []float64 {
+	list := make([]float64, n)
+	for i := range list {
+		list[i] = math.Pow(1-rng.Float64(), -1/alpha)
+	}
+	return list
+}
+
+// go test -run=^$ -bench=BucketMemory -benchmem
+func BenchmarkBucketMemory(b *testing.B) {
+	list := pareto(rand.New(rand.NewSource(38)), 10000, 0.8)
+	histograms := make([]*Histogram, b.N)
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		histogram := NewHistogram(int64(len(list)), 1, 1)
+		for _, v := range list {
+			histogram.Enqueue(v, 1)
+		}
+		histograms[i] = histogram
+	}
+	b.StopTimer()
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	b.ReportMetric(float64(after.HeapAlloc-before.HeapAlloc)/float64(b.N), "heap-B/histogram")
+	runtime.KeepAlive(histograms)
+}
@@ -1,12 +1,17 @@
 package histogram
 
 import (
-    "log"
     "fmt"
 )
 
+// Deprecated: DEBUG has no effect, the diagnostics go to the slog.Logger
+// set by WithLogger or to slog.Default()
 var DEBUG bool = false
 
+// an AVL tree of 2^63 nodes is less than 1.45*63 high, a deeper descent
+// runs into a cycle of the children
+const maxTreeDepth = 92
+
 
 // Histogram tree 
 type HistogramItem struct {
@@ -38,16 +43,9 @@ func NewHistogramItem(v float64) *HistogramItem {
 
 func (t *HistogramItem) GetRoot() *HistogramItem {
     root := t
-    path := fmt.Sprintf("%v", t.Value)
     for c := t.Parent; c != nil; c = c.Parent {
-        if DEBUG {
-            path = fmt.Sprintf("%v, %v", path, c.Value)
-        }
         root = c
     }
-    if DEBUG {
-        log.Printf("path to root: %v", path)
-    }
     return root
 }
 
@@ -128,6 +126,29 @@ func (t *HistogramItem) FindNoLargerThan(v float64) *HistogramItem {
     }
 }
 
+// FindByRank returns the node holding the sample at the given 0-based rank,
+// counting duplications, by descending on the subtree counts in O(log n)
+func (t *HistogramItem) FindByRank(rank int64) *HistogramItem {
+    if t == nil || rank < 0 || rank >= t.Count {
+        return nil
+    }
+    for c := t; c != nil; {
+        left_count := int64(0)
+        if c.Left != nil {
+            left_count = c.Left.Count
+        }
+        if rank < left_count {
+            c = c.Left
+        } else if rank < left_count + c.Duplications {
+            return c
+        } else {
+            rank -= left_count + c.Duplications
+            c = c.Right
+        }
+    }
+    return nil
+}
+
 func (t *HistogramItem) CumulativeCount() int64 {
     if t == nil {return int64(0)}
 
@@ -155,9 +176,22 @@ func (t *HistogramItem) CumulativeCount() int64 {
 // return the inserted node,
 // and if the root could be changed, then return the new root
 //     but if the root is not changed, then return nil
+//     a corrupt tree is left untouched and both are nil,
+//     InsertE returns the reason
 func (t *HistogramItem) Insert(v float64, count int64, recursion_level int) (*HistogramItem, *HistogramItem) {
-    if recursion_level > 30 {
-        log.Printf("[histogram][insert] recursion level: %v, incoming value: %v, count: %v, histogram item value: %v", recursion_level, v, count, t.Value)
+    item, root, _ := t.insert(v, count, recursion_level)
+    return item, root
+}
+
+// InsertE is Insert returning ErrCorruptTree when the descent meets a child
+// identical to its parent or never ends, the tree is left untouched then
+func (t *HistogramItem) InsertE(v float64, count int64) (*HistogramItem, *HistogramItem, error) {
+    return t.insert(v, count, 0)
+}
+
+func (t *HistogramItem) insert(v float64, count int64, recursion_level int) (*HistogramItem, *HistogramItem, error) {
+    if recursion_level > maxTreeDepth {
+        return nil, nil, fmt.Errorf("%w: inserting %v deeper than %v levels", ErrCorruptTree, v, maxTreeDepth)
     }
     if v == t.Value {
         t.Duplications += count
@@ -165,10 +199,11 @@ func (t *HistogramItem) Insert(v float64, count int64, recursion_level int) (*Hi
         for c := t.Parent; c!= nil; c = c.Parent {
             c.Count += count
         }
-        return t, nil
+        return t, nil, nil
     } else if (t.Left == nil && v < t.Value) || ( t.Right == nil && v > t.Value ) {
         newItem := NewHistogramItem(v)
         newItem.Duplications = count
+        newItem.Count = count
         newItem.Parent = t
         var root *HistogramItem = nil
         if v > t.Value {
@@ -197,29 +232,17 @@ func (t *HistogramItem) Insert(v float64, count int64, recursion_level int) (*Hi
             t.Height += 1
             root = t.UpdateHeight(true)
         }
-        return newItem, root
+        return newItem, root, nil
     } else if v < t.Value {
         if t.Left == t || t.Left.Value == t.Value {
-            log.Printf("[histogram][insert] WARNING: left child is identical, t.Left == t ? %v", t.Left == t)
-            t.Left = nil
-            return t.Insert(v, count, recursion_level+1)
-        } else {
-            if recursion_level > 30 {
-                log.Printf("[histogram][insert] recursive insert to left child")
-            }
-            return t.Left.Insert(v, count, recursion_level+1)
+            return nil, nil, fmt.Errorf("%w: left child of %v is identical", ErrCorruptTree, t.Value)
         }
+        return t.Left.insert(v, count, recursion_level+1)
     } else {
         if t.Right == t || t.Right.Value == t.Value {
-            log.Printf("[histogram][insert] WARNING: right child is identical, t.Right == t ? %v", t.Right == t)
-            t.Right = nil
-            return t.Insert(v, count, recursion_level+1)
-        } else {
-            if recursion_level > 30 {
-                log.Printf("[histogram][insert] recursive insert to right child")
-            }
-            return t.Right.Insert(v, count, recursion_level+1)
+            return nil, nil, fmt.Errorf("%w: right child of %v is identical", ErrCorruptTree, t.Value)
         }
+        return t.Right.insert(v, count, recursion_level+1)
     }
 }
 
@@ -246,9 +269,6 @@ func (t *HistogramItem) Delete() (*HistogramItem, *HistogramItem) {
     var replaced_by *HistogramItem = nil
 
     if t.Left == nil && t.Right == nil {
-        if DEBUG {
-            log.Printf("deleting a leaf node: %v", t.Value)
-        }
         if t.Parent != nil {
             if t.Parent.Left == t {
                 t.Parent.Left = nil
@@ -265,12 +285,6 @@ func (t *HistogramItem) Delete() (*HistogramItem, *HistogramItem) {
         }
 
     } else {
-        if DEBUG {
-            log.Printf("deleting a non-leaf node: %v", t.Value)
-        }
-        if DEBUG {
-                log.Printf("   before searching for replacing node, the node is: %v", t.Describe())
-            }
         if t.Left != nil {
             replaced_by = t.FindLargestInLeft()
             if replaced_by.Parent != t {
@@ -303,10 +317,6 @@ func (t *HistogramItem) Delete() (*HistogramItem, *HistogramItem) {
                 affectedNode_height = replaced_by
             }
             
-            if DEBUG {
-                log.Printf("   the non-leaf node is going to be replaced by %v", replaced_by.Value)
-                log.Printf("   before replacing, the node is: %v", t.Describe())
-            }
             // update stats
             replaced_by.Count = t.Count - t.Duplications
             replaced_by.Height = t.Height
@@ -351,10 +361,6 @@ func (t *HistogramItem) Delete() (*HistogramItem, *HistogramItem) {
             if t.Larger != nil && t.Larger != replaced_by {
                 t.Larger.Smaller = replaced_by
             }
-
-            if DEBUG {
-                log.Printf("   after replacing, the replacing node is: %v", replaced_by.Describe())
-            }
         } 
     }
 
@@ -367,9 +373,6 @@ func (t *HistogramItem) Delete() (*HistogramItem, *HistogramItem) {
 
     // Update Count
     for p := affectedNode_count; p != nil; p = p.Parent {
-        if DEBUG {
-            log.Printf("   updating count for node[%v]", p.Value)
-        }
         p.Count -= t.Duplications
     }
 
@@ -396,55 +399,24 @@ func (t *HistogramItem) CalcHeight() (int64, int64, int64) {
     return t.Height, leftHeight, rightHeight
 }
 
+// the rotations follow the balance of the heavier child, which a deletion
+// can leave heavier on its inner side as well as an insertion,
+// isInserting is kept for the callers
 func (t *HistogramItem) UpdateHeight(isInserting bool) *HistogramItem {
-    if DEBUG {
-        log.Printf("updating height for node: %v", t.Describe())
-    }
     root := t
     for c := t; c != nil; c = c.Parent {
         _, leftHeight, rightHeight := c.CalcHeight()
 
-        if DEBUG {
-            log.Printf("   for node[%v], left height: %v, right height: %v",
-                         c.Value, leftHeight, rightHeight,
-                )
-        }
         if leftHeight - rightHeight > 1 {
-            if DEBUG {
-                log.Printf("      before right rotation, node[%v]: %v", c.Value, c.Describe())
-            }
-
-            if isInserting {
-               if c.Left.Right != nil {
-                    c.Left.LeftRotate()
-                    if DEBUG {
-                        log.Printf("      after left rotation, node[%v]: %v", c.Value, c.Describe())
-                    }
-                } 
+            if c.Left.balance() < 0 {
+                c.Left.LeftRotate()
             }
-            
             c = c.RightRotate()
-            if DEBUG {
-                log.Printf("      after right rotation, node[%v]: %v", c.Value, c.Describe())
-            }
         } else if rightHeight - leftHeight > 1 {
-            if DEBUG {
-                log.Printf("      before left rotation, node[%v]: %v", c.Value, c.Describe())
-            }
-
-            if isInserting {
-                if c.Right.Left != nil {
-                    c.Right.RightRotate()
-                    if DEBUG {
-                        log.Printf("      after right rotation, node[%v]: %v", c.Value, c.Describe())
-                    }
-                }
+            if c.Right.balance() > 0 {
+                c.Right.RightRotate()
             }
-
             c = c.LeftRotate()
-            if DEBUG {
-                log.Printf("      after left rotation, node[%v]: %v", c.Value, c.Describe())
-            }
         }
 
         root = c
@@ -452,11 +424,19 @@ func (t *HistogramItem) UpdateHeight(isInserting bool) *HistogramItem {
     return root
 }
 
-func (t *HistogramItem) LeftRotate() *HistogramItem{
-
-    if DEBUG {
-        log.Printf("   Left rotate node: %v", t.Value)
+// balance is the height of the left subtree less the one of the right subtree
+func (t *HistogramItem) balance() int64 {
+    leftHeight, rightHeight := int64(0), int64(0)
+    if t.Left != nil {
+        leftHeight = t.Left.Height
+    }
+    if t.Right != nil {
+        rightHeight = t.Right.Height
     }
+    return leftHeight - rightHeight
+}
+
+func (t *HistogramItem) LeftRotate() *HistogramItem{
 
     if t.Right == nil {
         return t
@@ -493,10 +473,6 @@ func (t *HistogramItem) LeftRotate() *HistogramItem{
 }
 
 func (t *HistogramItem) RightRotate() *HistogramItem{
-    if DEBUG {
-        log.Printf("   Right rotate node: %v", t.Value)
-    }
-
     if t.Left == nil {
         return t
     }
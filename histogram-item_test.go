@@ -158,3 +158,33 @@ func TestScheduler_CumulativeCount(t *testing.T) {
 
 
 
+
+func TestHistogramItem_FindByRank(t *testing.T) {
+	for size := 10; size <= 100000; size *= 10 {
+		random_sample_list := gen_random_list(size)
+		sorted_sample_list := sorted_list(random_sample_list)
+		root := create_tree(random_sample_list)
+
+		for i := 0; i < len(sorted_sample_list); i++ {
+			node := root.FindByRank(int64(i))
+			assert.NotNil(t, node, "every rank inside the tree should be found")
+			if node == nil || node.Value != sorted_sample_list[i] {
+				assert.Equal(t, sorted_sample_list[i], node.Value, "the value at rank should equal the sorted list")
+				break
+			}
+		}
+		assert.Nil(t, root.FindByRank(-1), "negative rank should not be found")
+		assert.Nil(t, root.FindByRank(int64(size)), "rank beyond the count should not be found")
+	}
+}
+
+func TestInsert_CountOfNewNodes(t *testing.T) {
+	histogram := NewHistogram(0, 10, 0)
+	histogram.Enqueue(5, 3)
+	assert.Equal(t, int64(3), histogram.tree.total(), "a new root counts all its samples")
+	histogram.Enqueue(7, 2)
+	histogram.Enqueue(1, 4)
+	assert.Equal(t, int64(2), histogram.tree.nodes[histogram.tree.find(7)].count, "a new leaf counts all its samples")
+	assert.Equal(t, int64(9), histogram.tree.total(), "the tree counts every sample")
+	assert.Equal(t, float64(7)/9, histogram.GetPercentileForValue(5), "the cumulative count")
+}
@@ -0,0 +1,201 @@
+package histogram
+
+import (
+	"math"
+)
+
+// a peak is reported only if it rises above the higher of its two
+// surrounding valleys by at least this fraction of the tallest peak
+const minModeProminence = 0.05
+
+// points of the grid the kernel density is evaluated on to look for modes
+const kernelModeGridSize = 512
+
+// Mode is a peak of the distribution and the mass of the samples
+// between the valleys on each side of it
+type Mode struct {
+	Value float64
+	Lower float64
+	Upper float64
+	Count int64
+	Mass  float64
+}
+
+// Modes bins the distinct values by the given width and returns the peaks
+// of the binned counts in ascending order of value, a width no larger than
+// the accuracy of the histogram looks for peaks among the distinct values;
+// a negative or non-finite width has no modes
+func (h *Histogram) Modes(binning float64) []*Mode {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	t := &h.tree
+	if t.total() == 0 || !(binning >= 0) || math.IsInf(binning, 1) {
+		return nil
+	}
+
+	// the coarsest resolution, which is the only one with significant digits
+	unit := h.resolution(math.Max(math.Abs(t.value(t.min)), math.Abs(t.value(t.max))))
+	if binning < unit {
+		binning = unit
+	}
+	origin := math.Floor(t.value(t.min)/binning) * binning
+	position := func(v float64) float64 {
+		if binning == unit {
+			// a bin per distinct value, rounding away the float error
+			return math.Round((v - origin) / binning)
+		}
+		return math.Floor((v - origin) / binning)
+	}
+
+	// only the bins holding samples, with an empty bin standing for each
+	// gap between them, so the size follows the distinct values rather
+	// than their range
+	centers := []float64{}
+	density := []float64{}
+	last := float64(0)
+	for i := t.min; i != 0; i = t.nodes[i].larger {
+		x := &t.nodes[i]
+		idx := position(x.value)
+		if len(density) > 0 {
+			if idx == last {
+				density[len(density)-1] += float64(x.duplications)
+				continue
+			}
+			if idx > last+1 {
+				centers = append(centers, origin+(last+1.5)*binning)
+				density = append(density, 0)
+			}
+		}
+		center := origin + (idx+0.5)*binning
+		if binning == unit {
+			// the peak is the distinct value itself
+			center = x.value
+		}
+		centers = append(centers, center)
+		density = append(density, float64(x.duplications))
+		last = idx
+	}
+	return h.findModes(centers, density, binning)
+}
+
+// KernelModes looks for the peaks of a Gaussian kernel density estimate
+// over the distinct values, which is less sensitive to the bin edges
+// than Modes for a small window; a bandwidth that is not positive and
+// finite, or so wide the grid overflows, returns nil
+func (h *Histogram) KernelModes(bandwidth float64) []*Mode {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.tree.total() == 0 || !(bandwidth > 0) || math.IsInf(bandwidth, 1) {
+		return nil
+	}
+
+	lower := h.tree.value(h.tree.min) - 3*bandwidth
+	upper := h.tree.value(h.tree.max) + 3*bandwidth
+	step := (upper - lower) / float64(kernelModeGridSize-1)
+	if math.IsInf(step, 0) {
+		return nil
+	}
+	centers := make([]float64, kernelModeGridSize)
+	for i := range centers {
+		centers[i] = lower + float64(i)*step
+	}
+	density := h.kernelDensity(centers, bandwidth, GaussianKernel)
+	return h.findModes(centers, density, step)
+}
+
+// findModes keeps the local maxima of the density whose prominence is
+// significant, then splits the mass at the lowest point between neighbours
+func (h *Histogram) findModes(centers []float64, density []float64, width float64) []*Mode {
+	size := len(density)
+	if size == 0 {
+		return nil
+	}
+
+	// local maxima, a plateau counts once at its middle
+	peaks := []int{}
+	tallest := float64(0)
+	for i := 0; i < size; {
+		j := i
+		for j+1 < size && density[j+1] == density[i] {
+			j++
+		}
+		rising := i == 0 || density[i-1] < density[i]
+		falling := j == size-1 || density[j+1] < density[j]
+		if rising && falling && density[i] > 0 {
+			peaks = append(peaks, (i+j)/2)
+			if density[i] > tallest {
+				tallest = density[i]
+			}
+		}
+		i = j + 1
+	}
+
+	significant := []int{}
+	for _, p := range peaks {
+		// the lowest point on each side before reaching a higher peak,
+		// the density is zero beyond the ends when there is none
+		left_min, right_min := float64(0), float64(0)
+		for i := p - 1; i >= 0; i-- {
+			if density[i] > density[p] {
+				left_min = minOf(density[i+1 : p+1])
+				break
+			}
+		}
+		for i := p + 1; i < size; i++ {
+			if density[i] > density[p] {
+				right_min = minOf(density[p:i])
+				break
+			}
+		}
+		prominence := density[p] - math.Max(left_min, right_min)
+		if prominence >= minModeProminence*tallest {
+			significant = append(significant, p)
+		}
+	}
+	if len(significant) == 0 {
+		return nil
+	}
+
+	total := float64(0)
+	for _, d := range density {
+		total += d
+	}
+
+	modes := make([]*Mode, len(significant))
+	start := 0
+	for k, p := range significant {
+		end := size - 1
+		if k+1 < len(significant) {
+			// split at the lowest point towards the next peak
+			end = p
+			for i := p; i <= significant[k+1]; i++ {
+				if density[i] < density[end] {
+					end = i
+				}
+			}
+		}
+		mass := float64(0)
+		for i := start; i <= end; i++ {
+			mass += density[i]
+		}
+		modes[k] = &Mode{
+			Value: centers[p],
+			Lower: centers[start] - width/2,
+			Upper: centers[end] + width/2,
+			Mass:  mass / total,
+		}
+		modes[k].Count = int64(math.Round(modes[k].Mass * float64(h.tree.total())))
+		start = end + 1
+	}
+	return modes
+}
+
+func minOf(list []float64) float64 {
+	m := math.Inf(1)
+	for _, v := range list {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
@@ -0,0 +1,90 @@
+package histogram
+
+import (
+	"math"
+	"math/rand"
+	"runtime"
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+func bimodal_histogram(size int, hit_ratio float64) *Histogram {
+	rng := rand.New(rand.NewSource(28))
+	histogram := NewHistogram(int64(size), float64(10), 1)
+	for i := 0; i < size; i++ {
+		if rng.Float64() < hit_ratio {
+			histogram.Enqueue(10+rng.NormFloat64(), 1)
+		} else {
+			histogram.Enqueue(50+3*rng.NormFloat64(), 1)
+		}
+	}
+	return histogram
+}
+
+func TestModes_BimodalLatency(t *testing.T) {
+	histogram := bimodal_histogram(20000, 0.7)
+
+	for name, modes := range map[string][]*Mode{
+		"binned": histogram.Modes(4),
+		"kernel": histogram.KernelModes(2),
+	} {
+		assert.Equal(t, 2, len(modes), "%v: cache hits and misses should be two modes", name)
+		if len(modes) != 2 {
+			continue
+		}
+		assert.InDelta(t, float64(10), modes[0].Value, 2, "%v: the cache hit mode", name)
+		assert.InDelta(t, float64(50), modes[1].Value, 4, "%v: the cache miss mode", name)
+		assert.InDelta(t, 0.7, modes[0].Mass, 0.02, "%v: the mass of the cache hits", name)
+		assert.InDelta(t, 0.3, modes[1].Mass, 0.02, "%v: the mass of the cache misses", name)
+		assert.Less(t, modes[0].Upper, modes[1].Value, "%v: the modes should not overlap", name)
+	}
+}
+
+func TestModes_UnimodalLatency(t *testing.T) {
+	histogram := bimodal_histogram(20000, 1)
+	assert.Equal(t, 1, len(histogram.KernelModes(1)), "a single normal should be one mode")
+	assert.Equal(t, 1, len(histogram.Modes(1)), "a single normal should be one mode")
+
+	modes := histogram.Modes(0)
+	assert.NotEmpty(t, modes, "distinct values should have at least a mode")
+
+	histogram = NewHistogram(10, float64(10), 1)
+	assert.Nil(t, histogram.Modes(1), "empty histogram has no mode")
+	histogram.Enqueue(0.3, 3)
+	histogram.Enqueue(0.7, 1)
+	modes = histogram.Modes(0)
+	assert.Equal(t, 2, len(modes), "separated distinct values are two modes")
+	if len(modes) == 2 {
+		assert.Equal(t, 0.3, modes[0].Value, "the mode should be the value itself")
+		assert.Equal(t, int64(3), modes[0].Count, "the mode owns the samples up to the valley")
+		assert.Equal(t, 0.7, modes[1].Value, "the mode should be the value itself")
+		assert.Equal(t, 0.25, modes[1].Mass, "the mode owns the samples after the valley")
+	}
+}
+
+func TestModes_WideRange(t *testing.T) {
+	histogram := NewHistogram(0, 10, 3)
+	histogram.Enqueue(0.001, 2)
+	histogram.Enqueue(20000, 1)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	modes := histogram.Modes(0)
+	runtime.ReadMemStats(&after)
+	assert.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(1<<16), "the bins follow the distinct values, not the range")
+	assert.Equal(t, 2, len(modes), "two distant values")
+	if len(modes) == 2 {
+		assert.Equal(t, 0.001, modes[0].Value, "the smaller value")
+		assert.Equal(t, int64(2), modes[0].Count, "its samples")
+		assert.Equal(t, float64(20000), modes[1].Value, "the larger value")
+	}
+	assert.Equal(t, 2, len(histogram.Modes(1000)), "a gap of empty bins is a valley")
+	assert.Equal(t, 1, len(histogram.Modes(30000)), "a single bin")
+
+	for _, binning := range []float64{-1, math.NaN(), math.Inf(1)} {
+		assert.Nil(t, histogram.Modes(binning), "binning %v", binning)
+	}
+	for _, bandwidth := range []float64{0, -1, math.NaN(), math.Inf(1), math.MaxFloat64} {
+		assert.Nil(t, histogram.KernelModes(bandwidth), "bandwidth %v", bandwidth)
+	}
+}
@@ -0,0 +1,403 @@
+package histogram
+
+import (
+	"math"
+)
+
+// kahanSum is a compensated (Neumaier) summation, which keeps the rounding
+// error of every add and subtract so long sliding windows do not drift
+type kahanSum struct {
+	Sum          float64
+	Compensation float64
+}
+
+func (k *kahanSum) add(x float64) {
+	t := k.Sum + x
+	if math.Abs(k.Sum) >= math.Abs(x) {
+		k.Compensation += (k.Sum - t) + x
+	} else {
+		k.Compensation += (x - t) + k.Sum
+	}
+	k.Sum = t
+}
+
+func (k *kahanSum) value() float64 {
+	return k.Sum + k.Compensation
+}
+
+// moments keeps the mean and the central moment sums M2, M3 and M4 of the
+// samples in the window with Welford style add-and-remove updates,
+// so every moment based statistic is updated in O(1) and without the
+// catastrophic cancellation of raw power sums
+type moments struct {
+	N    int64
+	Mean float64
+	M2   float64
+	M3   float64
+	M4   float64
+	Sum  kahanSum
+	// sums of log(x) and 1/x, only meaningful when every sample is positive
+	SumLogs        kahanSum
+	SumReciprocals kahanSum
+	NonPositive    int64
+	// removals since the last exact recomputation from the tree
+	removals int64
+}
+
+// add merges count copies of v into the moments,
+// using the pairwise update of Pébay (2008) with a constant second set
+func (m *moments) add(v float64, count int64) {
+	if count <= 0 {
+		return
+	}
+	na, nb := float64(m.N), float64(count)
+	n := na + nb
+	delta := v - m.Mean
+	delta_n := delta / n
+	m2a, m3a := m.M2, m.M3
+
+	m.Mean += delta_n * nb
+	m.M2 += delta * delta_n * na * nb
+	m.M3 += delta*delta_n*delta_n*na*nb*(na-nb) - 3*delta_n*nb*m2a
+	m.M4 += delta*delta_n*delta_n*delta_n*na*nb*(na*na-na*nb+nb*nb) +
+		6*delta_n*delta_n*nb*nb*m2a - 4*delta_n*nb*m3a
+	m.N += count
+
+	c := float64(count)
+	m.Sum.add(c * v)
+	if v > 0 {
+		m.SumLogs.add(c * math.Log(v))
+		m.SumReciprocals.add(c / v)
+	} else {
+		m.NonPositive += count
+	}
+}
+
+// remove is the exact inverse of add
+func (m *moments) remove(v float64, count int64) {
+	if count <= 0 {
+		return
+	}
+	if m.N <= count {
+		*m = moments{}
+		return
+	}
+	n, nb := float64(m.N), float64(count)
+	na := n - nb
+	meana := m.Mean - (v-m.Mean)*nb/na
+	delta := v - meana
+	delta_n := delta / n
+
+	m2a := m.M2 - delta*delta_n*na*nb
+	if m2a < 0 {
+		m2a = 0
+	}
+	m3a := m.M3 - delta*delta_n*delta_n*na*nb*(na-nb) + 3*delta_n*nb*m2a
+	m4a := m.M4 - delta*delta_n*delta_n*delta_n*na*nb*(na*na-na*nb+nb*nb) -
+		6*delta_n*delta_n*nb*nb*m2a + 4*delta_n*nb*m3a
+	if m4a < 0 {
+		m4a = 0
+	}
+	m.Mean, m.M2, m.M3, m.M4 = meana, m2a, m3a, m4a
+	m.N -= count
+	m.removals += count
+
+	c := float64(count)
+	m.Sum.add(-c * v)
+	if v > 0 {
+		m.SumLogs.add(-c * math.Log(v))
+		m.SumReciprocals.add(-c / v)
+	} else {
+		m.NonPositive -= count
+	}
+}
+
+// recompute rebuilds the moments exactly from the (value, duplications)
+// pairs of the sorted linked list, with a two pass algorithm
+func (m *moments) recompute(t *tree) {
+	fresh := moments{}
+	for i := t.min; i != 0; i = t.nodes[i].larger {
+		x := &t.nodes[i]
+		c := float64(x.duplications)
+		fresh.N += x.duplications
+		fresh.Sum.add(c * x.value)
+		if x.value > 0 {
+			fresh.SumLogs.add(c * math.Log(x.value))
+			fresh.SumReciprocals.add(c / x.value)
+		} else {
+			fresh.NonPositive += x.duplications
+		}
+	}
+	if fresh.N > 0 {
+		fresh.Mean = fresh.Sum.value() / float64(fresh.N)
+		m2, m3, m4 := kahanSum{}, kahanSum{}, kahanSum{}
+		for i := t.min; i != 0; i = t.nodes[i].larger {
+			x := &t.nodes[i]
+			c := float64(x.duplications)
+			d := x.value - fresh.Mean
+			d2 := d * d
+			m2.add(c * d2)
+			m3.add(c * d2 * d)
+			m4.add(c * d2 * d2)
+		}
+		fresh.M2, fresh.M3, fresh.M4 = m2.value(), m3.value(), m4.value()
+	}
+	*m = fresh
+}
+
+func (m *moments) mean() float64 {
+	return m.Mean
+}
+
+// centralMoments returns the 2nd, 3rd and 4th population central moments
+func (m *moments) centralMoments() (float64, float64, float64) {
+	if m.N == 0 {
+		return 0, 0, 0
+	}
+	n := float64(m.N)
+	return m.M2 / n, m.M3 / n, m.M4 / n
+}
+
+// minMomentRecomputeInterval bounds how often the moments are rebuilt
+// from the tree for very small windows
+const minMomentRecomputeInterval = 1024
+
+// updateMoments publishes Mean and Variance, and once as many samples as
+// the window holds have slid out, recomputes the moments from the tree
+// so the drift of the incremental updates is bounded; the O(distinct)
+// walk is amortized over the removals
+func (h *Histogram) updateMoments() {
+	m := &h.moments
+	interval := m.N
+	if interval < minMomentRecomputeInterval {
+		interval = minMomentRecomputeInterval
+	}
+	if m.removals >= interval && h.tree.root != 0 {
+		m.recompute(&h.tree)
+	}
+	h.Mean = m.Mean
+	h.Variance, _, _ = m.centralMoments()
+}
+
+// RecomputeMoments rebuilds the moments exactly from the tree
+func (h *Histogram) RecomputeMoments() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.moments.recompute(&h.tree)
+	h.updateMoments()
+}
+
+// Statistics is a point-in-time summary of the samples in the window
+type Statistics struct {
+	Count    int64
+	Sum      float64
+	Min      float64
+	Max      float64
+	Mean     float64
+	Variance float64
+	StdDev   float64
+	Skewness float64
+	// excess kurtosis, 0 for a normal distribution
+	Kurtosis float64
+	// NaN unless every sample is positive
+	GeometricMean float64
+	HarmonicMean  float64
+
+	Median                  float64
+	MedianAbsoluteDeviation float64
+	InterquartileRange      float64
+
+	// the samples left out by the value policy
+	Invalid ValueTallies
+}
+
+// GetStatistics returns the moment based statistics in O(1)
+// and the order based ones in O(log n) from the tree,
+// except the median absolute deviation which walks outwards from the median
+func (h *Histogram) GetStatistics() *Statistics {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.statistics()
+}
+
+func (h *Histogram) statistics() *Statistics {
+	s := &Statistics{Invalid: h.tallies}
+	if h.tree.total() == 0 {
+		return s
+	}
+	m := &h.moments
+	s.Count = m.N
+	s.Sum = m.Sum.value()
+	s.Min = h.tree.value(h.tree.min)
+	s.Max = h.tree.value(h.tree.max)
+	s.Mean = m.mean()
+
+	m2, m3, m4 := m.centralMoments()
+	s.Variance = m2
+	s.StdDev = math.Sqrt(m2)
+	if m2 > 0 {
+		s.Skewness = m3 / math.Pow(m2, 1.5)
+		s.Kurtosis = m4/(m2*m2) - 3
+	}
+
+	if m.NonPositive == 0 {
+		s.GeometricMean = math.Exp(m.SumLogs.value() / float64(m.N))
+		s.HarmonicMean = float64(m.N) / m.SumReciprocals.value()
+	} else {
+		s.GeometricMean = math.NaN()
+		s.HarmonicMean = math.NaN()
+	}
+
+	s.Median = h.interpolatedQuantile(0.5)
+	s.InterquartileRange = h.interpolatedQuantile(0.75) - h.interpolatedQuantile(0.25)
+	s.MedianAbsoluteDeviation = h.medianAbsoluteDeviation(s.Median)
+	return s
+}
+
+// GetSum returns the sum of the samples in the window
+func (h *Histogram) GetSum() float64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.moments.Sum.value()
+}
+
+// GetStdDev returns the population standard deviation of the window
+func (h *Histogram) GetStdDev() float64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	m2, _, _ := h.moments.centralMoments()
+	return math.Sqrt(m2)
+}
+
+// GetTrimmedMean returns the mean after discarding the given fraction
+// of samples from each end of the window, fraction in [0, 0.5); NaN for
+// a NaN fraction
+func (h *Histogram) GetTrimmedMean(fraction float64) float64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.clippedMean(fraction, false)
+}
+
+// GetWinsorizedMean returns the mean after replacing the given fraction
+// of samples at each end of the window by the nearest remaining value;
+// NaN for a NaN fraction
+func (h *Histogram) GetWinsorizedMean(fraction float64) float64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.clippedMean(fraction, true)
+}
+
+// interpolatedQuantile linearly interpolates between the two closest ranks,
+// unlike the nearest rank semantics of the tracked percentiles; NaN for NaN p
+func (h *Histogram) interpolatedQuantile(p float64) float64 {
+	t := &h.tree
+	if t.total() == 0 {
+		return 0
+	}
+	if math.IsNaN(p) {
+		return math.NaN()
+	}
+	n := t.total()
+	if p <= 0 {
+		return t.value(t.min)
+	}
+	if p >= 1 {
+		return t.value(t.max)
+	}
+	pos := p * float64(n-1)
+	lower_rank := int64(math.Floor(pos))
+	upper_rank := int64(math.Ceil(pos))
+	lower := t.value(t.byRank(lower_rank))
+	if upper_rank == lower_rank {
+		return lower
+	}
+	upper := t.value(t.byRank(upper_rank))
+	return lower + (upper-lower)*(pos-float64(lower_rank))
+}
+
+// medianAbsoluteDeviation merges the deviations on both sides of the median
+// in ascending order, walking the sorted linked list outwards
+func (h *Histogram) medianAbsoluteDeviation(median float64) float64 {
+	t := &h.tree
+	if t.total() == 0 {
+		return 0
+	}
+	n := t.total()
+	lower_rank := (n - 1) / 2
+	upper_rank := n / 2
+
+	left := t.noLargerThan(median)
+	right := t.min
+	if left != 0 {
+		right = t.nodes[left].larger
+	}
+
+	seen := int64(0)
+	lower_value, upper_value := float64(0), float64(0)
+	for left != 0 || right != 0 {
+		var next *node
+		if right == 0 || (left != 0 && median-t.nodes[left].value <= t.nodes[right].value-median) {
+			next = &t.nodes[left]
+			left = next.smaller
+		} else {
+			next = &t.nodes[right]
+			right = next.larger
+		}
+		deviation := math.Abs(next.value - median)
+		if seen <= lower_rank && lower_rank < seen+next.duplications {
+			lower_value = deviation
+		}
+		if seen <= upper_rank && upper_rank < seen+next.duplications {
+			upper_value = deviation
+			break
+		}
+		seen += next.duplications
+	}
+	return (lower_value + upper_value) / 2
+}
+
+func (h *Histogram) clippedMean(fraction float64, winsorize bool) float64 {
+	t := &h.tree
+	if t.total() == 0 {
+		return 0
+	}
+	if math.IsNaN(fraction) {
+		return math.NaN()
+	}
+	if fraction < 0 {
+		fraction = 0
+	}
+	if fraction >= 0.5 {
+		return h.interpolatedQuantile(0.5)
+	}
+	n := t.total()
+	k := int64(math.Floor(fraction * float64(n)))
+	if k == 0 {
+		return h.moments.mean()
+	}
+
+	// keep the ranks in [k, n-k)
+	sum := float64(0)
+	rank := int64(0)
+	for i := t.min; i != 0 && rank < n-k; i = t.nodes[i].larger {
+		x := &t.nodes[i]
+		start, end := rank, rank+x.duplications
+		if start < k {
+			start = k
+		}
+		if end > n-k {
+			end = n - k
+		}
+		if end > start {
+			sum += float64(end-start) * x.value
+		}
+		rank += x.duplications
+	}
+
+	if !winsorize {
+		return sum / float64(n-2*k)
+	}
+	sum += float64(k) * t.value(t.byRank(k))
+	sum += float64(k) * t.value(t.byRank(n-1-k))
+	return sum / float64(n)
+}
@@ -0,0 +1,197 @@
+package histogram
+
+import (
+	"math"
+	"math/rand"
+	"os"
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+func reference_statistics(window []float64) *Statistics {
+	sorted := sorted_list(window)
+	n := float64(len(sorted))
+	s := &Statistics{Count: int64(len(sorted)), Min: sorted[0], Max: sorted[len(sorted)-1]}
+	logs, reciprocals := float64(0), float64(0)
+	for _, v := range sorted {
+		s.Sum += v
+		logs += math.Log(v)
+		reciprocals += 1 / v
+	}
+	s.Mean = s.Sum / n
+	m2, m3, m4 := float64(0), float64(0), float64(0)
+	for _, v := range sorted {
+		d := v - s.Mean
+		m2 += d * d / n
+		m3 += d * d * d / n
+		m4 += d * d * d * d / n
+	}
+	s.Variance = m2
+	s.StdDev = math.Sqrt(m2)
+	s.Skewness = m3 / math.Pow(m2, 1.5)
+	s.Kurtosis = m4/(m2*m2) - 3
+	s.GeometricMean = math.Exp(logs / n)
+	s.HarmonicMean = n / reciprocals
+
+	quantile := func(list []float64, p float64) float64 {
+		pos := p * float64(len(list)-1)
+		lower, upper := math.Floor(pos), math.Ceil(pos)
+		return list[int(lower)] + (list[int(upper)]-list[int(lower)])*(pos-lower)
+	}
+	s.Median = quantile(sorted, 0.5)
+	s.InterquartileRange = quantile(sorted, 0.75) - quantile(sorted, 0.25)
+	deviations := make([]float64, len(sorted))
+	for i, v := range sorted {
+		deviations[i] = math.Abs(v - s.Median)
+	}
+	s.MedianAbsoluteDeviation = quantile(sorted_list(deviations), 0.5)
+	return s
+}
+
+func TestStatistics_SlidingWindow(t *testing.T) {
+	window_size := 1000
+	list := gen_random_list_float(5000, 100, float64(10))
+	histogram := NewHistogram(int64(window_size), float64(10), 1)
+
+	for i, v := range list {
+		list[i] = v + 1 // keep every sample positive for the geometric and harmonic means
+		histogram.Enqueue(list[i], 1)
+		if i < 10 || i%499 != 0 {
+			continue
+		}
+		start := i + 1 - window_size
+		if start < 0 {
+			start = 0
+		}
+		expected := reference_statistics(list[start : i+1])
+		actual := histogram.GetStatistics()
+
+		assert.Equal(t, expected.Count, actual.Count, "count should match the window")
+		assert.Equal(t, expected.Min, actual.Min, "min should match the window")
+		assert.Equal(t, expected.Max, actual.Max, "max should match the window")
+		assert.InDelta(t, expected.Sum, actual.Sum, 1e-6*expected.Sum, "sum should match the window")
+		assert.InDelta(t, expected.Mean, actual.Mean, 1e-6, "mean should match the window")
+		assert.InDelta(t, expected.Variance, actual.Variance, 1e-6*expected.Variance, "variance should match the window")
+		assert.InDelta(t, expected.StdDev, actual.StdDev, 1e-6*expected.StdDev, "std dev should match the window")
+		assert.InDelta(t, expected.Skewness, actual.Skewness, 1e-4, "skewness should match the window")
+		assert.InDelta(t, expected.Kurtosis, actual.Kurtosis, 1e-4, "kurtosis should match the window")
+		assert.InDelta(t, expected.GeometricMean, actual.GeometricMean, 1e-6*expected.GeometricMean, "geometric mean should match the window")
+		assert.InDelta(t, expected.HarmonicMean, actual.HarmonicMean, 1e-6*expected.HarmonicMean, "harmonic mean should match the window")
+		assert.Equal(t, expected.Median, actual.Median, "median should match the window")
+		assert.Equal(t, expected.InterquartileRange, actual.InterquartileRange, "IQR should match the window")
+		assert.InDelta(t, expected.MedianAbsoluteDeviation, actual.MedianAbsoluteDeviation, 1e-9, "MAD should match the window")
+	}
+}
+
+func TestStatistics_TrimmedAndWinsorizedMean(t *testing.T) {
+	histogram := NewHistogram(0, float64(10), 0)
+	for _, v := range []float64{1, 2, 2, 3, 4, 5, 6, 7, 8, 100} {
+		histogram.Enqueue(v, 1)
+	}
+
+	assert.Equal(t, float64(138)/10, histogram.GetTrimmedMean(0), "no trimming is the plain mean")
+	assert.Equal(t, float64(37)/8, histogram.GetTrimmedMean(0.1), "trimming drops one sample from each end")
+	assert.Equal(t, float64(2+37+8)/10, histogram.GetWinsorizedMean(0.1), "winsorizing clamps one sample at each end")
+	assert.Equal(t, float64(4.5), histogram.GetTrimmedMean(0.5), "trimming half from each end leaves the median")
+	assert.Equal(t, float64(138), histogram.GetSum(), "sum should be the total of the samples")
+	assert.True(t, math.IsNaN(histogram.GetTrimmedMean(math.NaN())), "trimming a NaN fraction")
+	assert.True(t, math.IsNaN(histogram.GetWinsorizedMean(math.NaN())), "winsorizing a NaN fraction")
+}
+
+func TestStatistics_NonPositiveSamples(t *testing.T) {
+	histogram := NewHistogram(3, float64(10), 1)
+	histogram.Enqueue(0, 1)
+	histogram.Enqueue(2, 1)
+	histogram.Enqueue(4, 1)
+	s := histogram.GetStatistics()
+	assert.True(t, math.IsNaN(s.GeometricMean), "geometric mean is undefined with a zero sample")
+	assert.True(t, math.IsNaN(s.HarmonicMean), "harmonic mean is undefined with a zero sample")
+
+	// the zero slides out of the window
+	histogram.Enqueue(8, 1)
+	s = histogram.GetStatistics()
+	assert.InDelta(t, float64(4), s.GeometricMean, 1e-9, "geometric mean of 2, 4, 8")
+	assert.InDelta(t, float64(3)/(0.5+0.25+0.125), s.HarmonicMean, 1e-9, "harmonic mean of 2, 4, 8")
+}
+
+func exact_moments(window []float64) (float64, float64, float64, float64) {
+	n := float64(len(window))
+	mean := float64(0)
+	for _, v := range window {
+		mean += v
+	}
+	mean /= n
+	m2, m3, m4 := float64(0), float64(0), float64(0)
+	for _, v := range window {
+		d := v - mean
+		m2 += d * d
+		m3 += d * d * d
+		m4 += d * d * d * d
+	}
+	return mean, m2 / n, m3 / n, m4 / n
+}
+
+// slides a window far from zero, where raw power sums and the previous
+// mean/variance updates lose every significant digit; the bare updates are
+// checked as well as the histogram, which also recomputes them from the tree;
+// AVLHIST_LONG_SLIDE=1 slides over 10^8 samples, -short over 10^5
+func TestMoments_DriftOverLongSlide(t *testing.T) {
+	total := 1000000
+	if os.Getenv("AVLHIST_LONG_SLIDE") != "" {
+		total = 100000000
+	} else if testing.Short() {
+		total = 100000
+	}
+	window_size := 1000
+	rng := rand.New(rand.NewSource(26))
+	window := make([]float64, window_size)
+	m := &moments{}
+	histogram, _ := New(WithWindowSize(int64(window_size)), WithAccuracy(1))
+	for i := 0; i < total; i++ {
+		v := 1e6 + math.Round(rng.ExpFloat64()*1000)/10
+		if i >= window_size {
+			m.remove(window[i%window_size], 1)
+		}
+		window[i%window_size] = v
+		m.add(v, 1)
+		histogram.Enqueue(v, 1)
+	}
+
+	mean, m2, m3, m4 := exact_moments(window)
+	actual_m2, actual_m3, actual_m4 := m.centralMoments()
+	assert.Equal(t, int64(window_size), m.N, "count should equal the window size")
+	assert.InDelta(t, mean, m.mean(), 1e-6, "mean should not drift")
+	assert.InDelta(t, m2, actual_m2, 1e-6*m2, "variance should not drift")
+	assert.InDelta(t, m3/math.Pow(m2, 1.5), actual_m3/math.Pow(actual_m2, 1.5), 1e-4, "skewness should not drift")
+	assert.InDelta(t, m4/(m2*m2), actual_m4/(actual_m2*actual_m2), 1e-4, "kurtosis should not drift")
+	assert.InDelta(t, mean*float64(window_size), m.Sum.value(), 1e-3, "sum should not drift")
+
+	s := histogram.GetStatistics()
+	assert.Equal(t, int64(window_size), s.Count, "the histogram holds the window")
+	assert.InDelta(t, mean, s.Mean, 1e-6, "the mean of the histogram should not drift")
+	assert.InDelta(t, m2, s.Variance, 1e-6*m2, "the variance of the histogram should not drift")
+	assert.InDelta(t, m3/math.Pow(m2, 1.5), s.Skewness, 1e-4, "the skewness of the histogram should not drift")
+	assert.InDelta(t, m4/(m2*m2)-3, s.Kurtosis, 1e-4, "the kurtosis of the histogram should not drift")
+	assert.InDelta(t, mean*float64(window_size), s.Sum, 1e-3, "the sum of the histogram should not drift")
+}
+
+func TestMoments_RecomputedOnSlidingWindow(t *testing.T) {
+	window_size := 1000
+	rng := rand.New(rand.NewSource(27))
+	histogram := NewHistogram(int64(window_size), float64(10), 1)
+	list := make([]float64, 200000)
+	for i := range list {
+		list[i] = 1e6 + math.Round(rng.ExpFloat64()*1000)/10
+		histogram.Enqueue(list[i], 1)
+		assert.GreaterOrEqual(t, histogram.Variance, float64(0), "variance should never go negative")
+		if histogram.Variance < 0 {
+			break
+		}
+	}
+	mean, m2, _, _ := exact_moments(list[len(list)-window_size:])
+	assert.InDelta(t, mean, histogram.Mean, 1e-6, "mean should match the window")
+	assert.InDelta(t, m2, histogram.Variance, 1e-6*m2, "variance should match the window")
+
+	histogram.RecomputeMoments()
+	assert.InDelta(t, m2, histogram.Variance, 1e-9*m2, "recomputed variance should be exact")
+}
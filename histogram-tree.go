@@ -0,0 +1,396 @@
+package histogram
+
+import (
+	"fmt"
+)
+
+// tree is the AVL tree of the distinct values of a histogram, threaded by
+// the sorted list of the smaller and larger neighbours, its nodes indices
+// into the arena; it is what the HistogramItem nodes are, without pointers
+type tree struct {
+	nodeArena
+	root int32
+	min  int32
+	max  int32
+}
+
+// reset empties the tree, keeping the arena
+func (t *tree) reset() {
+	t.nodeArena.reset()
+	t.root, t.min, t.max = 0, 0, 0
+}
+
+// total returns the amount of samples in the tree
+func (t *tree) total() int64 {
+	if t.root == 0 {
+		return 0
+	}
+	return t.nodes[t.root].count
+}
+
+// inArena tells whether i is the index of a node
+func (t *tree) inArena(i int32) bool {
+	return i > 0 && int(i) < len(t.nodes)
+}
+
+// value returns the value of a node, 0 for no node
+func (t *tree) value(i int32) float64 {
+	if i == 0 {
+		return 0
+	}
+	return t.nodes[i].value
+}
+
+// insert adds count samples of v, returning the node of v and whether it
+// is new; the descent refuses a child identical to its parent or deeper
+// than an AVL tree can be with ErrCorruptTree, leaving the tree untouched
+func (t *tree) insert(v float64, count int64) (int32, bool, error) {
+	if t.root == 0 {
+		i := t.get(v, count)
+		t.root, t.min, t.max = i, i, i
+		return i, true, nil
+	}
+	parent := t.root
+	for depth := 0; t.nodes[parent].value != v; depth++ {
+		n := &t.nodes[parent]
+		child := n.right
+		if v < n.value {
+			child = n.left
+		}
+		if child == 0 {
+			break
+		}
+		if child == parent || t.nodes[child].value == n.value {
+			return 0, false, fmt.Errorf("%w: a child of %v is identical", ErrCorruptTree, n.value)
+		}
+		if depth >= maxTreeDepth {
+			return 0, false, fmt.Errorf("%w: inserting %v deeper than %v levels", ErrCorruptTree, v, maxTreeDepth)
+		}
+		parent = child
+	}
+	if t.nodes[parent].value == v {
+		t.nodes[parent].duplications += count
+		for c := parent; c != 0; c = t.nodes[c].parent {
+			t.nodes[c].count += count
+		}
+		return parent, false, nil
+	}
+
+	// the arena may move the nodes, they are addressed after get
+	i := t.get(v, count)
+	n, p := &t.nodes[i], &t.nodes[parent]
+	n.parent = parent
+	if v > p.value {
+		p.right = i
+		n.smaller, n.larger = parent, p.larger
+		p.larger = i
+		if n.larger != 0 {
+			t.nodes[n.larger].smaller = i
+		} else {
+			t.max = i
+		}
+	} else {
+		p.left = i
+		n.smaller, n.larger = p.smaller, parent
+		p.smaller = i
+		if n.smaller != 0 {
+			t.nodes[n.smaller].larger = i
+		} else {
+			t.min = i
+		}
+	}
+	for c := parent; c != 0; c = t.nodes[c].parent {
+		t.nodes[c].count += count
+	}
+	t.rebalance(parent)
+	return i, true, nil
+}
+
+// remove takes a sample of node i out of the tree, and with the last one
+// the node itself, which goes back to the arena; the largest node of its
+// left subtree takes its place, so the other nodes keep their indices.
+// It returns whether the node was removed
+func (t *tree) remove(i int32) bool {
+	n := &t.nodes[i]
+	for c := n.parent; c != 0; c = t.nodes[c].parent {
+		t.nodes[c].count--
+	}
+	if n.duplications > 1 {
+		n.duplications--
+		n.count--
+		return false
+	}
+
+	smaller, larger := n.smaller, n.larger
+	if smaller != 0 {
+		t.nodes[smaller].larger = larger
+	} else {
+		t.min = larger
+	}
+	if larger != 0 {
+		t.nodes[larger].smaller = smaller
+	} else {
+		t.max = smaller
+	}
+
+	from := n.parent
+	if n.left == 0 || n.right == 0 {
+		child := n.left
+		if child == 0 {
+			child = n.right
+		}
+		t.replace(n.parent, i, child)
+		if child != 0 {
+			t.nodes[child].parent = n.parent
+		}
+	} else {
+		// the largest node of the left subtree is the smaller neighbour
+		r := &t.nodes[smaller]
+		if r.parent != i {
+			from = r.parent
+			t.nodes[r.parent].right = r.left
+			if r.left != 0 {
+				t.nodes[r.left].parent = r.parent
+			}
+			for c := r.parent; c != i; c = t.nodes[c].parent {
+				t.nodes[c].count -= r.duplications
+			}
+			r.left = n.left
+			t.nodes[n.left].parent = smaller
+		} else {
+			from = smaller
+		}
+		r.right = n.right
+		t.nodes[n.right].parent = smaller
+		r.parent = n.parent
+		t.replace(n.parent, i, smaller)
+		r.height = n.height
+		r.count = n.count - 1
+	}
+	t.put(i)
+	if from != 0 {
+		t.rebalance(from)
+	}
+	return true
+}
+
+// replace links child in place of the child old of parent,
+// at the root when parent is 0
+func (t *tree) replace(parent int32, old int32, child int32) {
+	if parent == 0 {
+		t.root = child
+	} else if t.nodes[parent].left == old {
+		t.nodes[parent].left = child
+	} else {
+		t.nodes[parent].right = child
+	}
+}
+
+// update recomputes the height and the count of a node from its children
+func (t *tree) update(i int32) {
+	n := &t.nodes[i]
+	l, r := &t.nodes[n.left], &t.nodes[n.right]
+	n.height = max(l.height, r.height) + 1
+	n.count = n.duplications + l.count + r.count
+}
+
+// balance is how much higher the left subtree of a node is than the right one
+func (t *tree) balance(i int32) int32 {
+	n := &t.nodes[i]
+	return t.nodes[n.left].height - t.nodes[n.right].height
+}
+
+// rebalance updates the nodes from i up towards the root, rotating those
+// whose subtrees differ by more than one level; the counts above i are
+// already right, so it stops at the first node keeping its height
+func (t *tree) rebalance(i int32) {
+	for c := i; c != 0; c = t.nodes[c].parent {
+		height := t.nodes[c].height
+		t.update(c)
+		if b := t.balance(c); b > 1 {
+			if t.balance(t.nodes[c].left) < 0 {
+				t.rotateLeft(t.nodes[c].left)
+			}
+			c = t.rotateRight(c)
+		} else if b < -1 {
+			if t.balance(t.nodes[c].right) > 0 {
+				t.rotateRight(t.nodes[c].right)
+			}
+			c = t.rotateLeft(c)
+		} else if t.nodes[c].height == height {
+			return
+		}
+	}
+}
+
+// rotateLeft lifts the right child of node i into its place, returning it
+func (t *tree) rotateLeft(i int32) int32 {
+	n := &t.nodes[i]
+	r := n.right
+	rn := &t.nodes[r]
+	n.right = rn.left
+	if rn.left != 0 {
+		t.nodes[rn.left].parent = i
+	}
+	rn.parent = n.parent
+	t.replace(n.parent, i, r)
+	rn.left = i
+	n.parent = r
+	t.update(i)
+	t.update(r)
+	return r
+}
+
+// rotateRight lifts the left child of node i into its place, returning it
+func (t *tree) rotateRight(i int32) int32 {
+	n := &t.nodes[i]
+	l := n.left
+	ln := &t.nodes[l]
+	n.left = ln.right
+	if ln.right != 0 {
+		t.nodes[ln.right].parent = i
+	}
+	ln.parent = n.parent
+	t.replace(n.parent, i, l)
+	ln.right = i
+	n.parent = l
+	t.update(i)
+	t.update(l)
+	return l
+}
+
+// find returns the node of v, 0 if there is none or if the descent is
+// deeper than an AVL tree can be
+func (t *tree) find(v float64) int32 {
+	c := t.root
+	for depth := 0; c != 0 && t.nodes[c].value != v; depth++ {
+		if depth >= maxTreeDepth {
+			return 0
+		}
+		if v < t.nodes[c].value {
+			c = t.nodes[c].left
+		} else {
+			c = t.nodes[c].right
+		}
+	}
+	return c
+}
+
+// noLargerThan returns the node of the largest value no larger than v,
+// 0 below the smallest one
+func (t *tree) noLargerThan(v float64) int32 {
+	found := int32(0)
+	for c := t.root; c != 0; {
+		if t.nodes[c].value <= v {
+			found, c = c, t.nodes[c].right
+		} else {
+			c = t.nodes[c].left
+		}
+	}
+	return found
+}
+
+// cumulative returns the amount of samples no larger than the value of
+// node i, 0 for no node
+func (t *tree) cumulative(i int32) int64 {
+	if i == 0 {
+		return 0
+	}
+	count := t.nodes[i].duplications + t.nodes[t.nodes[i].left].count
+	for pre, cur := i, t.nodes[i].parent; cur != 0; pre, cur = cur, t.nodes[cur].parent {
+		if t.nodes[cur].left != pre {
+			count += t.nodes[cur].duplications + t.nodes[t.nodes[cur].left].count
+		}
+	}
+	return count
+}
+
+// upTo returns the node of the largest value with at most count samples up
+// to it, false if even the smallest one has more
+func (t *tree) upTo(count int64) (int32, bool) {
+	found, before := int32(0), int64(0)
+	for c := t.root; c != 0; {
+		n := &t.nodes[c]
+		if cumulative := before + t.nodes[n.left].count + n.duplications; cumulative <= count {
+			found, before, c = c, cumulative, n.right
+		} else {
+			c = n.left
+		}
+	}
+	return found, found != 0
+}
+
+// rank returns the amount of samples no larger than v
+func (t *tree) rank(v float64) int64 {
+	count := int64(0)
+	for c := t.root; c != 0; {
+		n := &t.nodes[c]
+		if n.value <= v {
+			count += t.nodes[n.left].count + n.duplications
+			c = n.right
+		} else {
+			c = n.left
+		}
+	}
+	return count
+}
+
+// byRank returns the node holding the sample at the 0-based rank,
+// 0 out of the tree
+func (t *tree) byRank(rank int64) int32 {
+	if rank < 0 || rank >= t.total() {
+		return 0
+	}
+	for c := t.root; c != 0; {
+		n := &t.nodes[c]
+		left := t.nodes[n.left].count
+		if rank < left {
+			c = n.left
+		} else if rank < left+n.duplications {
+			return c
+		} else {
+			rank -= left + n.duplications
+			c = n.right
+		}
+	}
+	return 0
+}
+
+// items copies the tree into standalone HistogramItem nodes linked as the
+// tree and the sorted list are, returning the root, nil for an empty tree
+func (t *tree) items() *HistogramItem {
+	if t.root == 0 {
+		return nil
+	}
+	slab := make([]HistogramItem, len(t.nodes))
+	item := func(i int32) *HistogramItem {
+		if i == 0 {
+			return nil
+		}
+		return &slab[i]
+	}
+	for i := t.min; i != 0; i = t.nodes[i].larger {
+		n := &t.nodes[i]
+		slab[i] = HistogramItem{
+			Value:        n.value,
+			Left:         item(n.left),
+			Right:        item(n.right),
+			Parent:       item(n.parent),
+			Smaller:      item(n.smaller),
+			Larger:       item(n.larger),
+			Height:       int64(n.height),
+			Count:        n.count,
+			Duplications: n.duplications,
+		}
+	}
+	return item(t.root)
+}
+
+// Tree returns a copy of the tree of the histogram as HistogramItem nodes,
+// linked as the tree and the sorted list are, nil for an empty window; it
+// takes O(n) and shares nothing with the histogram
+func (h *Histogram) Tree() *HistogramItem {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.tree.items()
+}
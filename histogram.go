@@ -2,34 +2,61 @@ package histogram
 
 import (
 	"math"
+	"sort"
 	"sync"
 	"strconv"
 	"fmt"
-	"log"
+	"errors"
 )
 
 
 type Histogram struct {
+	// Deprecated: the samples are queued by the index of their node in the
+	// arena and this list is no longer filled
 	Queue 		[]*HistogramItem
+	// Deprecated: the nodes live in an arena linked by their indices and this
+	// tree is no longer filled; Tree returns a copy of it
 	RootItem	*HistogramItem
 	QueueSize  	int64
 	Count  		int64
 	BucketHistogram *BucketHistogram
 	Accuracy    float64
+	// Deprecated: no longer filled, GetStatistics returns Min and Max
 	MinItem 	*HistogramItem
+	// Deprecated: no longer filled, GetStatistics returns Min and Max
 	MaxItem     *HistogramItem
 	Percentiles	map[string]*PercentileItem
+	Thresholds  map[string]*ThresholdItem
 	Mean 		float64
 	Variance	float64
+	SLOs        []*SLO
+	watchers    []*Watcher
+	moments     moments
+	tallies     ValueTallies
+
+	// the tree in its arena, the values of the samples in FIFO order and
+	// the arrays the queue slides through
+	tree       tree
+	queue      []float64
+	queueArray []float64
+	stampArray []int64
+	// arrival time of each entry of the queue when there is a time window
+	timestamps  []int64
+	config      Config
+	callbacks   []func()
 	mutex       *sync.Mutex
 }
 
 type PercentileItem struct {
 	Percentile  float64
+	// Deprecated: no longer filled, Value is the value of the percentile
 	Item 		*HistogramItem
 	Key 		string
 	Count       int64
 	RealPercentage float64
+	Value       float64
+	// the node of Value, 0 on an empty histogram
+	node        int32
 }
 
 
@@ -45,25 +72,47 @@ func NewPercentileItem(p float64) *PercentileItem {
 }
 
 func NewHistogram(size int64, subBucketHistogramSize float64, accuracy int) *Histogram {
+	h := &Histogram{
+		mutex: &sync.Mutex{},
+	}
+	h.configure(Config{
+		QueueSize: size,
+		SubBucketHistogramSize: subBucketHistogramSize,
+		Accuracy: accuracy,
+	})
+	return h
+}
+
+// configure applies the configuration to an empty histogram
+func (h *Histogram) configure(c Config) {
 	bs := float64(1)
-	accuracy_factor := math.Pow(10, float64(accuracy))
-	if accuracy != 0 {
+	accuracy_factor := math.Pow(10, float64(c.Accuracy))
+	if c.Accuracy != 0 {
 		bs = float64(1) / accuracy_factor
 	} 
 
-	sbs := subBucketHistogramSize
-	if subBucketHistogramSize == 0 {
+	sbs := c.SubBucketHistogramSize
+	if c.SubBucketHistogramSize == 0 {
 		sbs = float64(10.0)
 	}
 
-	h := &Histogram{
-		Queue: []*HistogramItem{},
-		QueueSize: size,
-		BucketHistogram: NewBucketHistogram(sbs, bs),
-		Accuracy: accuracy_factor,
-		mutex: &sync.Mutex{},
+	h.config = c
+	h.QueueSize = c.QueueSize
+	if c.BucketLayout != nil {
+		h.BucketHistogram = NewBucketHistogramWithLayout(c.BucketLayout)
+	} else if c.SignificantDigits > 0 {
+		h.BucketHistogram = NewBucketHistogramWithLayout(NewSignificantLayout(c.SignificantDigits))
+	} else {
+		h.BucketHistogram = NewBucketHistogram(sbs, bs)
 	}
-	return h
+	h.Accuracy = accuracy_factor
+}
+
+// takeCallbacks hands over the notifications queued while the mutex was held
+func (h *Histogram) takeCallbacks() []func() {
+	callbacks := h.callbacks
+	h.callbacks = nil
+	return callbacks
 }
 
 func (h *Histogram) GetWaterMark() float64 {
@@ -76,16 +125,15 @@ func (h *Histogram) GetWaterMark() float64 {
 }
 
 func (h *Histogram) GetValueOfBucket(subhistogramIndex int, bucketIndex int) float64 {
-	lower_boundary, _ := h.BucketHistogram.GetLowerAndUpperBoundaries(int64(subhistogramIndex))
-	return lower_boundary + float64(bucketIndex) * h.Accuracy
+	return h.UnifiedValue(h.BucketHistogram.GetValueOfBucket(int64(subhistogramIndex), int64(bucketIndex)))
 }
 
 func (h *Histogram) GetLengthOfSubHistograms() int {
-	return len(h.BucketHistogram.SubBucketHistograms)
+	return int(h.BucketHistogram.GetLength())
 }
 
 func (h *Histogram) GetMaximumSizeOfSubHistograms() int {
-	return int(math.Round(h.BucketHistogram.SubBucketHistogramSize*h.Accuracy))
+	return h.BucketHistogram.GetMaximumSizeOfSubHistograms()
 }
 
 func (h *Histogram) GetIndexOfSubHistogram(v float64) int {
@@ -95,21 +143,155 @@ func (h *Histogram) GetIndexOfSubHistogram(v float64) int {
 
 
 func (h *Histogram) UnifiedValue(value float64) float64 {
+	if h.config.SignificantDigits > 0 {
+		return roundSignificant(value, h.config.SignificantDigits)
+	}
 	v := value
 	v = math.Round(v* h.Accuracy)/h.Accuracy
+	if math.IsInf(v, 0) && !math.IsInf(value, 0) {
+		// scaling overflowed, a value this large has no digit below the accuracy
+		return value
+	}
 	return v
 }
 
 func (h *Histogram) AddPercentilePoint(p float64) {
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
+	h.addPercentilePoint(p)
+}
+
+func (h *Histogram) addPercentilePoint(p float64) {
+	if h.GetPercentileItem(p) != nil {
+		return
+	}
 	item := NewPercentileItem(p)
 	if h.Percentiles == nil {
 		h.Percentiles = make(map[string]*PercentileItem)
 	}
+	h.initPercentileItem(item)
 	h.Percentiles[item.Key] = item
 }
 
+// percentileCount returns the largest amount of samples out of total whose
+// cumulative percentage is no larger than p
+func percentileCount(p float64, total int64) int64 {
+	if !(p > 0) {
+		return 0
+	}
+	if p >= 1 {
+		return total
+	}
+	count := min(max(int64(p*float64(total)), 0), total)
+	for count < total && float64(count+1)/float64(total) <= p {
+		count++
+	}
+	for count > 0 && float64(count)/float64(total) > p {
+		count--
+	}
+	return count
+}
+
+// atPercentile is the one rule of the percentiles, tracked or not, of the
+// histograms and the snapshots alike: the value at p is the largest one
+// whose cumulative percentage is no larger than p, or the smallest one if
+// there is none. upTo returns the largest value with at most the given
+// amount of samples up to it, first is the smallest one
+func atPercentile[T any](p float64, total int64, upTo func(count int64) (T, bool), first T) T {
+	if found, ok := upTo(percentileCount(p, total)); ok {
+		return found
+	}
+	return first
+}
+
+// initPercentileItem points a percentile at its node by the rule of
+// atPercentile, which is where Enqueue and Dequeue keep it; the descent is O(log n)
+func (h *Histogram) initPercentileItem(p *PercentileItem) {
+	p.node, p.Value, p.Count, p.RealPercentage = 0, 0, 0, 0
+	t := &h.tree
+	if t.total() == 0 {
+		return
+	}
+	p.node = atPercentile(p.Percentile, t.total(), t.upTo, t.min)
+	p.Count = t.cumulative(p.node)
+	p.Value = t.nodes[p.node].value
+	p.RealPercentage = float64(p.Count)/float64(t.total())
+}
+
+// settlePercentileItem moves a percentile along the sorted linked list until it
+// is again on its node by the rule of atPercentile, the amount of steps is
+// bounded by how far Enqueue or Dequeue shifted the ranks
+func (h *Histogram) settlePercentileItem(p *PercentileItem) {
+	t := &h.tree
+	total_count := float64(t.total())
+	limit := percentileCount(p.Percentile, t.total())
+	for t.nodes[p.node].smaller != 0 && p.Count > limit {
+		p.Count -= t.nodes[p.node].duplications
+		p.node = t.nodes[p.node].smaller
+	}
+	for x := t.nodes[p.node].larger; x != 0 && p.Count+t.nodes[x].duplications <= limit; x = t.nodes[x].larger {
+		p.node = x
+		p.Count += t.nodes[x].duplications
+	}
+	p.Value = t.nodes[p.node].value
+	p.RealPercentage = float64(p.Count)/total_count
+}
+
+// RemovePercentilePoint stops tracking the percentile p and closes its watchers,
+// returns false if it was not tracked
+func (h *Histogram) RemovePercentilePoint(p float64) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	key := PercentileKey(p)
+	if _, ok := h.Percentiles[key]; !ok {
+		return false
+	}
+	delete(h.Percentiles, key)
+	kept := h.watchers[:0]
+	for _, w := range h.watchers {
+		if w.key == key {
+			w.stop()
+		} else {
+			kept = append(kept, w)
+		}
+	}
+	h.watchers = kept
+	return true
+}
+
+// MovePercentilePoint re-targets the tracked percentile from to the percentile to,
+// the watchers of from follow it
+func (h *Histogram) MovePercentilePoint(from float64, to float64) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	item := h.GetPercentileItem(from)
+	if item == nil {
+		return false
+	}
+	delete(h.Percentiles, item.Key)
+	h.addPercentilePoint(to)
+	key := PercentileKey(to)
+	for _, w := range h.watchers {
+		if w.key == item.Key {
+			w.key = key
+			w.Percentile = to
+		}
+	}
+	return true
+}
+
+// ListPercentilePoints returns the tracked percentiles in ascending order
+func (h *Histogram) ListPercentilePoints() []float64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	list := make([]float64, 0, len(h.Percentiles))
+	for _, p := range h.Percentiles {
+		list = append(list, p.Percentile)
+	}
+	sort.Float64s(list)
+	return list
+}
+
 func (h *Histogram) GetPercentileItem(p float64) *PercentileItem {
 	if h.Percentiles == nil {return nil}
 	key:=PercentileKey(p)
@@ -122,295 +304,276 @@ func (h *Histogram) GetPercentileItem(p float64) *PercentileItem {
 func (h *Histogram) GetValueAtPercentile(p float64) float64 {
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
+	return h.valueAtPercentile(p)
+}
+
+func (h *Histogram) valueAtPercentile(p float64) float64 {
+	if h.config.PercentileMethod == InterpolatedPercentile {
+		return h.interpolatedQuantile(p)
+	}
 	percentileItem := h.GetPercentileItem(p)
-	if percentileItem != nil && percentileItem.Item != nil {
-		return percentileItem.Item.Value
+	if percentileItem != nil && percentileItem.node != 0 {
+		return percentileItem.Value
 	}
-
-	return CalcPercentileOfProduct(p, []*Histogram{h}, false)
+	t := &h.tree
+	if t.total() == 0 {
+		return 0
+	}
+	return t.nodes[atPercentile(p, t.total(), t.upTo, t.min)].value
 }
 
 func (h *Histogram) GetPercentileForValue(v float64) float64 {
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
-	item := h.RootItem.FindNoLargerThan(v)
-	if item.Count == 0 {
+	if h.tree.total() == 0 {
 		return 0
 	}
-	count := item.CumulativeCount()
-	return float64(count)/float64(h.RootItem.Count)
+	return float64(h.tree.rank(v))/float64(h.tree.total())
 }
 
 // No matter how the histogram structure is implemented
 // the most important three interfaces decide the overall performance
 
 // the complexity of Enqueue shall be no larger than O(log n)
+// it returns the last sample evicted on its own, detached from the tree
 func (h *Histogram) Enqueue(incomingValue float64, count int) *HistogramItem{
+	evicted, _ := h.enqueueAndNotify(incomingValue, count, false)
+	if evicted.Count == 0 {
+		return nil
+	}
+	return &evicted
+}
 
+// enqueueAndNotify enqueues under the mutex, returning the last sample
+// evicted; a corrupt tree is logged unless quiet
+func (h *Histogram) enqueueAndNotify(incomingValue float64, count int, quiet bool) (HistogramItem, error) {
 	h.mutex.Lock()
-	defer h.mutex.Unlock()
+	evicted, ok, err := h.enqueueE(incomingValue, count)
+	if !quiet && errors.Is(err, ErrCorruptTree) {
+		h.logger().Warn("histogram: enqueue failed", "value", incomingValue, "error", err)
+	}
+	callbacks := h.takeCallbacks()
+	h.mutex.Unlock()
 
-	v := h.UnifiedValue(incomingValue)
+	// callbacks run outside the mutex so they can query the histogram
+	for _, f := range callbacks {
+		f()
+	}
+	return sample(evicted, ok), err
+}
 
-	var result *HistogramItem = nil
+func (h *Histogram) enqueue(incomingValue float64, count int) (float64, bool) {
+	evicted, ok, err := h.enqueueE(incomingValue, count)
+	if errors.Is(err, ErrCorruptTree) {
+		h.logger().Warn("histogram: enqueue failed", "value", incomingValue, "error", err)
+	}
+	return evicted, ok
+}
 
-	var item *HistogramItem = nil
-	var newRoot *HistogramItem = nil
-	if h.RootItem != nil {
-		item, newRoot = h.RootItem.Insert(v, int64(count), 0)
-		if newRoot != nil {
-			h.RootItem = newRoot
-		}
-		if h.MinItem.Smaller != nil {
-			h.MinItem = h.MinItem.Smaller
-		}
-		if h.MaxItem.Larger != nil {
-			h.MaxItem = h.MaxItem.Larger
-		}
+func (h *Histogram) enqueueE(incomingValue float64, count int) (float64, bool, error) {
 
-		total_count := float64(h.RootItem.Count)
+	v, ok, err := h.admit(incomingValue, count)
+	if !ok {
+		return 0, false, err
+	}
+
+	first := h.tree.root == 0
+	item, is_new, err := h.tree.insert(v, int64(count))
+	if err != nil {
+		return 0, false, err
+	}
+	if first {
 		for _, p := range h.Percentiles {
-			if v <= p.Item.Value {
-				p.Count += 1
-				percentValue := float64(p.Count)/total_count
-				p.RealPercentage = percentValue
-				for x:=p.Item.Smaller; x != nil && percentValue > p.Percentile; x=x.Smaller {
-					p.Item = x
-					p.Count -= x.Larger.Duplications
-					p.RealPercentage = float64(p.Count)/total_count
-					percentValue = float64(p.Count-x.Duplications)/total_count
-				}
-			} else {
-				percentValue := float64(p.Count)/total_count
-				p.RealPercentage = percentValue
-				for x:=p.Item.Larger; x != nil && percentValue < p.Percentile; x=x.Larger {
-					percentValue := float64(p.Count + x.Duplications)/total_count
-					if percentValue <= p.Percentile {
-						p.Item = x
-						p.Count += x.Duplications
-						p.RealPercentage = float64(p.Count)/total_count
-					}
-				}
-			}
+			p.node, p.Value = item, v
+			p.Count = int64(count)
+			p.RealPercentage = float64(1)
 		}
 	} else {
-		item = NewHistogramItem(v)
-		h.RootItem = item
-		h.MinItem = item
-		h.MaxItem = item
-		item.Duplications = int64(count)
 		for _, p := range h.Percentiles {
-			p.Item = item
-			p.Count = int64(count)
-			p.RealPercentage = float64(1)
+			if v <= p.Value {
+				p.Count += int64(count)
+			}
+			h.settlePercentileItem(p)
+		}
+	}
+	for _, t := range h.Thresholds {
+		if v <= t.Value {
+			t.Count += int64(count)
 		}
 	}
-	if item != nil && item.Duplications == int64(count) {
-		h.BucketHistogram.Insert(item)
+	if is_new {
+		h.BucketHistogram.Insert(&HistogramItem{Value: v})
+	}
+	now := int64(0)
+	if h.config.TimeWindow > 0 {
+		now = h.now().UnixNano()
 	}
 	for i := 0; i<count; i++{
-		h.Queue = append(h.Queue, item)
+		h.queue, h.queueArray = slide(h.queue, h.queueArray, v)
+		if h.config.TimeWindow > 0 {
+			h.timestamps, h.stampArray = slide(h.timestamps, h.stampArray, now)
+		}
+		for _, slo := range h.SLOs {
+			slo.enter(h, v)
+		}
 	}
 
 	// mean and variance and count
-	countPre := h.Count
 	h.Count += int64(count)
-	meanPre := h.Mean
-	if h.Count > 0 {
-		h.Mean = (h.Mean * float64(countPre) + v*float64(count)) / float64(h.Count)
-	} else {
-		h.Mean = 0
+	h.moments.add(v, int64(count))
+	h.updateMoments()
+
+	evicted, is_evicted := float64(0), false
+	for h.QueueSize > 0 && h.Count > h.QueueSize && err == nil {
+		evicted, err = h.dequeueE()
+		is_evicted = is_evicted || err == nil
 	}
-	if h.Count > 0 {
-		a := float64(countPre)/float64(h.Count)*h.Variance
-		b := float64(countPre)/float64(h.Count)*math.Pow(h.Mean - meanPre, 2)
-		c := float64(int64(count))/float64(h.Count)*math.Pow(v - h.Mean, 2)
-		h.Variance = a + b + c
-	} else {
-		h.Variance = 0
+	if err == nil {
+		if expired, ok := h.expire(); ok {
+			evicted, is_evicted = expired, true
+		}
 	}
 
-	for h.QueueSize > 0 && h.Count > h.QueueSize {
-		result = h.Dequeue()
+	for _, slo := range h.SLOs {
+		slo.evaluate(h)
 	}
-	return result
+	h.notifyWatchers()
+	return evicted, is_evicted, err
 }
 
-// findItemAtRank finds the item at the given rank (0-based)
-func (h *Histogram) findItemAtRank(targetRank float64) *HistogramItem {
-	if h.RootItem == nil {
-		return nil
+// Reset empties the histogram, keeping its configuration, the tracked
+// percentiles and thresholds, the SLOs and the watchers
+func (h *Histogram) Reset() {
+	h.mutex.Lock()
+	h.reset()
+	for _, slo := range h.SLOs {
+		slo.evaluate(h)
 	}
-	
-	// Use iterative inorder traversal to find the exact rank
-	var currentRank float64 = 0
-	
-	// Stack for iterative traversal
-	stack := []*HistogramItem{}
-	current := h.RootItem
-	
-	for current != nil || len(stack) > 0 {
-		// Go to leftmost node
-		for current != nil {
-			stack = append(stack, current)
-			current = current.Smaller
-		}
-		
-		// Process current node
-		current = stack[len(stack)-1]
-		stack = stack[:len(stack)-1]
-		
-		// Check if target rank falls within current node's duplications
-		if currentRank <= targetRank && targetRank < currentRank + float64(current.Duplications) {
-			return current
-		}
-		
-		// Move to next position
-		currentRank += float64(current.Duplications)
-		
-		// Move to right subtree
-		current = current.Larger
+	callbacks := h.takeCallbacks()
+	h.mutex.Unlock()
+
+	for _, f := range callbacks {
+		f()
 	}
-	
-	return nil
 }
 
-// calculateCumulativeCount calculates the cumulative count up to the given item
-func (h *Histogram) calculateCumulativeCount(item *HistogramItem) int64 {
-	if item == nil {
-		return 0
+// reset keeps the arena, the queue and the bucket slices to reuse their memory
+func (h *Histogram) reset() {
+	h.tree.reset()
+	h.queue = h.queue[:0]
+	h.timestamps = h.timestamps[:0]
+	h.Count = 0
+	h.moments = moments{}
+	h.tallies = ValueTallies{}
+	h.Mean = 0
+	h.Variance = 0
+	h.BucketHistogram.Reset()
+	for _, p := range h.Percentiles {
+		p.node, p.Value, p.Count, p.RealPercentage = 0, 0, 0, 0
 	}
-	
-	var count int64 = 0
-	
-	// Add count from left subtree
-	if item.Smaller != nil {
-		count += item.Smaller.Count
+	for _, t := range h.Thresholds {
+		t.Count = 0
+	}
+	for _, slo := range h.SLOs {
+		slo.reset()
 	}
-	
-	// Add duplications from current item
-	count += item.Duplications
-	
-	return count
 }
 
 // the complexity of Dequeue shall be no larger than O(log n)
+// it returns the dequeued sample on its own, detached from the tree
 func (h *Histogram) Dequeue() *HistogramItem {
+	item, _ := h.dequeueAndNotify(false)
+	if item.Count == 0 {
+		return nil
+	}
+	return &item
+}
 
-	var item *HistogramItem = nil
-
-	if len(h.Queue) > 0 {
-		item = h.Queue[0]
-		h.Queue = h.Queue[1:]
-		h.Count -= 1
-		smaller := item.Smaller
-		larger := item.Larger
-		replacedItem, newRoot := item.Delete()
-		is_node_removed := false
-		if newRoot != nil || (newRoot == nil && replacedItem == nil) {
-			is_node_removed = true
-			h.RootItem = newRoot
-			// the item is deleted 
-			if item == h.MaxItem {
-				h.MaxItem = smaller
-			}
-			if item == h.MinItem {
-				h.MinItem = larger
-			}
-			h.BucketHistogram.Delete(item)
-		}
-		total_count := float64(0)
-		if h.RootItem != nil {
-			total_count = float64(h.RootItem.Count)
+// dequeueAndNotify dequeues under the mutex, returning the sample;
+// a corrupt tree is logged unless quiet
+func (h *Histogram) dequeueAndNotify(quiet bool) (HistogramItem, error) {
+	h.mutex.Lock()
+	v, err := h.dequeueE()
+	if err == nil {
+		for _, slo := range h.SLOs {
+			slo.evaluate(h)
 		}
-		if total_count > 0 {
-			deletedValue := item.Value
-			for _, p := range h.Percentiles {
-				// before_v:=p.Item.Value
-
-				if item == p.Item || deletedValue <= p.Item.Value {
-					p.Count--
-					if item == p.Item || deletedValue == p.Item.Value {
-						if is_node_removed {
-							// item node is removed from the tree
-							if larger != nil {
-								p.Item = larger
-								p.Count += larger.Duplications	
-							} else if smaller != nil {
-								p.Item = smaller
-							} else {
-								p.Item = nil
-							}
-						} 
-					}
-					percentile := float64(p.Count)/total_count
-					p.RealPercentage = percentile
-
-					if p.Item != nil {
-						for x:=p.Item.Larger; x!=nil && percentile < p.Percentile; x=x.Larger {
-							percentile = float64(p.Count + x.Duplications)/total_count
-							if percentile <= p.Percentile {
-								p.Item = x
-								p.Count += x.Duplications
-								p.RealPercentage = percentile
-							}
-						}
-					}
-				} else if deletedValue > p.Item.Value {
-					percentile := float64(p.Count)/total_count
-					p.RealPercentage = percentile
-					for x:=p.Item.Smaller; x!=nil && percentile > p.Percentile; x=x.Smaller {
-						p.Item = x
-						p.Count -= x.Larger.Duplications
-						p.RealPercentage = percentile
-						percentile = float64(p.Count-x.Duplications)/total_count
-					}
-				}
+		h.notifyWatchers()
+	} else if !quiet && errors.Is(err, ErrCorruptTree) {
+		h.logger().Warn("histogram: dequeue failed", "error", err)
+	}
+	callbacks := h.takeCallbacks()
+	h.mutex.Unlock()
 
-				// after_v:=p.Item.Value
-				// smaller_v:=float64(-1)
-				// if p.Item.Smaller !=nil {
-				// 	smaller_v = p.Item.Smaller.Value
-				// }
-				// larger_v:=float64(-1)
-				// if p.Item.Larger !=nil {
-				// 	larger_v = p.Item.Larger.Value
-				// }
-				// if before_v != after_v {
-				// 	cc := p.Item.CumulativeCount()
-				// 	log.Printf("deleted item %v, before %v, after: %v, smaller: %v, larger: %v,    percentile: %v, real: %v(%v/%v)[%v]", 
-				// 		deletedValue, before_v, after_v, smaller_v, larger_v,
-				// 		p.Percentile, float64(cc)/float64(h.RootItem.Count), 
-				// 		cc, total_count, p.RealPercentage,
-				// 	)	
-				// }
-				
-			}
-		}
-		
+	for _, f := range callbacks {
+		f()
 	}
+	return sample(v, err == nil), err
+}
 
-	if item != nil && h.Count > 0 {
-		meanPre := h.Mean
-		if h.Count > 0 {
-			h.Mean = (h.Mean * float64(h.Count+1) - item.Value) / float64(h.Count)
-		} else {
-			h.Mean = 0
+func (h *Histogram) dequeue() (float64, bool) {
+	v, err := h.dequeueE()
+	if errors.Is(err, ErrCorruptTree) {
+		h.logger().Warn("histogram: dequeue failed", "error", err)
+	}
+	return v, err == nil
+}
+
+func (h *Histogram) dequeueE() (float64, error) {
+
+	if len(h.queue) == 0 {
+		return 0, ErrEmpty
+	}
+	t := &h.tree
+	v := h.queue[0]
+	item := t.find(v)
+	if item == 0 || t.nodes[item].duplications <= 0 {
+		return 0, fmt.Errorf("%w: the oldest sample %v is not in the tree", ErrCorruptTree, v)
+	}
+
+	for _, slo := range h.SLOs {
+		slo.leave(h, v)
+	}
+	h.queue = h.queue[1:]
+	if len(h.timestamps) > 0 {
+		h.timestamps = h.timestamps[1:]
+	}
+	h.Count -= 1
+	h.moments.remove(v, 1)
+	for _, th := range h.Thresholds {
+		if v <= th.Value {
+			th.Count--
 		}
-		if h.Count > 0 {
-			a := float64(h.Count+1)/float64(h.Count)*h.Variance
-			b := math.Pow(meanPre - h.Mean, 2)
-			c := float64(1)/float64(h.Count)*math.Pow(item.Value - meanPre, 2)
-			h.Variance = a - b - c
-		} else {
-			h.Variance = 0
+	}
+	smaller, larger := t.nodes[item].smaller, t.nodes[item].larger
+	is_node_removed := t.remove(item)
+	if is_node_removed {
+		h.BucketHistogram.Delete(&HistogramItem{Value: v})
+	}
+	if t.total() > 0 {
+		for _, p := range h.Percentiles {
+			if item == p.node || v <= p.Value {
+				p.Count--
+			}
+			if item == p.node && is_node_removed {
+				// the node held a single sample, Count is already the cumulative count of smaller
+				if smaller != 0 {
+					p.node = smaller
+				} else {
+					p.node = larger
+					p.Count += t.nodes[larger].duplications
+				}
+			}
+			h.settlePercentileItem(p)
+		}
+	} else {
+		for _, p := range h.Percentiles {
+			p.node, p.Value, p.Count, p.RealPercentage = 0, 0, 0, 0
 		}
-	} else if item != nil && h.Count == 0 {
-		h.Mean = 0
-		h.Variance = 0
 	}
 
-	return item
+	h.updateMoments()
+	return v, nil
 }
 
 func SearchPercentileByMultiply(
@@ -456,11 +619,11 @@ func SearchPercentileByMultiply(
 			if len(opt_out_mask) > i && opt_out_mask[i] {continue}
 
 			h := histogram_list[i]
-			node := h.RootItem.FindNoLargerThan(criteria)
-			if node == h.MaxItem {
+			node := h.tree.noLargerThan(criteria)
+			if node == h.tree.max {
 				burnt_out_indices = append(burnt_out_indices, i)
 			} else {
-				cc := node.CumulativeCount()
+				cc := h.tree.cumulative(node)
 				product *= float64(cc)/float64(h.Count)
 			}
 		}
@@ -533,7 +696,6 @@ func SearchPercentileByMultiply(
 							mid, prod, criteria_value,
 							1, verbose,
 						)
-						break
 					}
 				}
 
@@ -546,8 +708,9 @@ func SearchPercentileByMultiply(
 	}
 
 	if verbose {
+		logger := histogram_list[0].logger()
 		if subhistogram_index < 0 && iteration_count == 1 {
-			log.Printf("iterations to search %v percentile:", p*float64(100))
+			logger.Info(fmt.Sprintf("iterations to search %v percentile:", p*float64(100)))
 		}
 		placeholder := "" 
 		if subhistogram_index < 0 {
@@ -563,22 +726,22 @@ func SearchPercentileByMultiply(
 		} else {
 			directionStr = ", next go down"
 		}
-		log.Printf("%v%v iteration: %v, burn out %v histograms, idx: %v, lower: %v, upper: %v, criteria: %v%v",
+		logger.Info(fmt.Sprintf("%v%v iteration: %v, burn out %v histograms, idx: %v, lower: %v, upper: %v, criteria: %v%v",
 			placeholder, iteration_count,
 			prod, len(burnt_out_indices),
 			mid, lower_search_index, upper_search_index,
 			math.Round(criteria_value*10)/10, directionStr,
-		)
+		))
 	}
 	
 
 	if got_the_result {
 		return criteria_value
-	} else if lower >= upper {
+	} else if lower > upper {
 		if last_criteria >= 0 && last_prod >= 0 {
 			if math.Abs(p-last_prod) < math.Abs(p-prod) {
 				if verbose {
-					log.Print("   due to larger distance, the last iteration is discarded")
+					histogram_list[0].logger().Info("   due to larger distance, the last iteration is discarded")
 				}
 				return last_criteria
 			}
@@ -615,8 +778,8 @@ func CalcPercentileOfProduct(percentile float64, histogram_list []*Histogram, ve
 		// 	}
 		// }
 		percentileItem := histogram_list[0].GetPercentileItem(percentile)
-		if percentileItem != nil && percentileItem.Item != nil {
-			return percentileItem.Item.Value
+		if percentileItem != nil && percentileItem.node != 0 {
+			return percentileItem.Value
 		}
 	}
 
@@ -648,8 +811,8 @@ func CalcPercentileOfProduct(percentile float64, histogram_list []*Histogram, ve
 			h := good_histogram_list[i]
 			r := h.GetPercentileItem(percentile)
 			v := start_point
-			if r != nil && r.Item != nil {
-				v = r.Item.Value
+			if r != nil && r.node != 0 {
+				v = r.Value
 			}
 			if v > start_point {
 				start_point = v
@@ -666,13 +829,78 @@ func CalcPercentileOfProduct(percentile float64, histogram_list []*Histogram, ve
 		1, verbose,
 	)
 
-	if verbose {
-		log.Printf("   the point for %v percentile is %v", percentile*float64(100), criteria_value)
-		log.Println("")
+	criteria_value = settleProduct(percentile, good_histogram_list, criteria_value)
+
+	if verbose && len(good_histogram_list) > 0 {
+		good_histogram_list[0].logger().Info(fmt.Sprintf("   the point for %v percentile is %v", percentile*float64(100), criteria_value))
 	}
 
 	return criteria_value
 }
 
+// settleProduct moves the criteria found on the bucket boundaries onto the
+// values of the histograms, to the largest one whose product of cumulative
+// percentages is no larger than p, or the smallest one if there is none,
+// which is the rule of the tracked percentiles; the search leaves it within
+// a bucket of the result, so only a few values are stepped over
+func settleProduct(p float64, histogram_list []*Histogram, criteria float64) float64 {
+	product := func(v float64) float64 {
+		prod := float64(1)
+		for _, h := range histogram_list {
+			if h.tree.total() == 0 {
+				continue
+			}
+			prod *= float64(h.tree.rank(v))/float64(h.tree.total())
+		}
+		return prod
+	}
+	// the neighbours of v among the values of all the histograms
+	below := func(v float64, inclusive bool) (bool, float64) {
+		found, result := false, float64(0)
+		for _, h := range histogram_list {
+			item := h.tree.noLargerThan(v)
+			if item != 0 && !inclusive && h.tree.nodes[item].value == v {
+				item = h.tree.nodes[item].smaller
+			}
+			if item != 0 && (!found || h.tree.nodes[item].value > result) {
+				found, result = true, h.tree.nodes[item].value
+			}
+		}
+		return found, result
+	}
+	above := func(v float64) (bool, float64) {
+		found, result := false, float64(0)
+		for _, h := range histogram_list {
+			item := h.tree.min
+			if smaller := h.tree.noLargerThan(v); smaller != 0 {
+				item = h.tree.nodes[smaller].larger
+			}
+			if item != 0 && (!found || h.tree.nodes[item].value < result) {
+				found, result = true, h.tree.nodes[item].value
+			}
+		}
+		return found, result
+	}
+
+	found, t := below(criteria, true)
+	if !found {
+		if found, t = above(criteria); !found {
+			// no values at all
+			return criteria
+		}
+	}
+	for product(t) > p {
+		smaller, v := below(t, false)
+		if !smaller {
+			return t
+		}
+		t = v
+	}
+	for larger, v := above(t); larger && product(v) <= p; larger, v = above(t) {
+		t = v
+	}
+	return t
+}
+
 
 
@@ -44,41 +44,33 @@ func TestScheduler_CreateHistogram(t *testing.T) {
 		}
 		assert.Equal(t, int64(window_size), histogram.Count, "histogram size should equal window size")
 
-		assert.NotNil(t, histogram.RootItem, "root item should not be nil")
+		root := histogram.Tree()
+		assert.NotNil(t, root, "root item should not be nil")
 
-		assert.Equal(t, int64(window_size), histogram.RootItem.Count, "histogram root count should equal window size")
+		assert.Equal(t, int64(window_size), root.Count, "histogram root count should equal window size")
 
-		assert.Equal(t, int64(window_size), int64(len(histogram.Queue)), "histogram queue length should equal window size")
+		assert.Equal(t, int64(window_size), int64(len(histogram.queue)), "histogram queue length should equal window size")
+
+		min_node := root
+		for ; min_node.Left != nil ; min_node = min_node.Left {}
+		max_node := root
+		for ; max_node.Right != nil; max_node = max_node.Right {}
 
 		sumAllBuckets := int64(0)
 		bucket_count := 0
-		max_possible_bucket_count := len(histogram.BucketHistogram.SubBucketHistograms)*buckets_in_subhisto
-		for i:=0; i<len(histogram.BucketHistogram.SubBucketHistograms); i++ {
-			sbh := histogram.BucketHistogram.SubBucketHistograms[i]
-			if sbh != nil {
-				bucket_count += len(sbh.BucketList)
-				for j:=0; j<len(sbh.BucketList);j++ {
-					bucketItem := sbh.BucketList[j]
-					if bucketItem != nil {
-						sumAllBuckets+=bucketItem.Duplications
-						// log.Printf(" i: %v, j: %v, value: %v, duplication: %v, count: %v", i, j, bucketItem.Value, bucketItem.Duplications, bucketItem.Count)
-
-						idx:=sbh.CalcPosition(bucketItem.Value)
-						assert.Equal(t, int64(j), idx, "the index should be equal")
-
-						idx,_,_ = histogram.BucketHistogram.CalcPosition(bucketItem.Value)
-						assert.Equal(t, int64(i), idx, "the index should be equal")
-
-					}
-				}
+		max_possible_bucket_count := int(histogram.BucketHistogram.GetLength())*buckets_in_subhisto
+		for _, sbh := range histogram.BucketHistogram.All() {
+			bucket_count += int(sbh.GetLength())
+		}
+		// every value is counted in the bucket it falls into
+		for bucketItem := min_node; bucketItem != nil; bucketItem = bucketItem.Larger {
+			i, _, _ := histogram.BucketHistogram.CalcPosition(bucketItem.Value)
+			sbh := histogram.BucketHistogram.GetSubHistogram(i)
+			if sbh != nil && sbh.GetBucket(sbh.CalcPosition(bucketItem.Value)) > 0 {
+				sumAllBuckets+=bucketItem.Duplications
 			}
 		}
 
-		min_node := histogram.RootItem
-		for ; min_node.Left != nil ; min_node = min_node.Left {}
-		max_node := histogram.RootItem
-		for ; max_node.Right != nil; max_node = max_node.Right {}
-
 		node_amount := 0
 		min_value := min_node.Value
 		max_value := max_node.Value
@@ -89,19 +81,19 @@ func TestScheduler_CreateHistogram(t *testing.T) {
 			node_amount++
 			sum_value += p.Value * float64(p.Duplications)
 		}
-		avg_value = sum_value / float64(histogram.RootItem.Count)
+		avg_value = sum_value / float64(root.Count)
 
 		for p:=min_node; p!=nil; p=p.Larger{
 			variance += math.Pow((p.Value-avg_value), 2) * float64(p.Duplications)
 		}
-		variance /= float64(histogram.RootItem.Count)
+		variance /= float64(root.Count)
 
-		assert.Equal(t, histogram.MinItem, min_node, "min node should be identical")
-		assert.Equal(t, histogram.MaxItem, max_node, "max node should be identical")
+		assert.True(t, min_node.Smaller == nil && min_node.Value == histogram.GetStatistics().Min, "min node should be identical")
+		assert.True(t, max_node.Larger == nil && max_node.Value == histogram.GetStatistics().Max, "max node should be identical")
 
 		for _, v := range percentile_list {
 			p := histogram.GetPercentileItem(v)
-			cc := p.Item.CumulativeCount()
+			cc := histogram.tree.cumulative(p.node)
 			// log.Printf("%v percentile(%v), real percentage: %v(%v), count: %v, total: %v, min: %v(%v), max: %v(%v), p-node: %v, cumulativeCount: %v, real: %v", 
 			// 	v*float64(100), p.Percentile, 
 			// 	p.RealPercentage, float64(p.Count)/float64(histogram.RootItem.Count),
@@ -112,8 +104,8 @@ func TestScheduler_CreateHistogram(t *testing.T) {
 			// )
 			// The histogram uses "nearest rank" approach, so we need to be more lenient
 			// with the accuracy expectations
-			percentileDiff := p.Percentile - float64(cc)/float64(histogram.RootItem.Count)
-			realPercentileDiff := p.RealPercentage - float64(cc)/float64(histogram.RootItem.Count)
+			percentileDiff := p.Percentile - float64(cc)/float64(root.Count)
+			realPercentileDiff := p.RealPercentage - float64(cc)/float64(root.Count)
 			
 			// Allow for some variance in the nearest rank approach
 			assert.GreaterOrEqual(t, percentileDiff, -0.1, "percentile point should be reasonably accurate")
@@ -127,7 +119,7 @@ func TestScheduler_CreateHistogram(t *testing.T) {
 		if verbose {
 			log.Printf("window size: %v, sample amount: %v, percentile points: %v, height of avl tree: %v", 
 				window_size, sample_size, len(percentile_list),
-				histogram.RootItem.Height,
+				root.Height,
 			)
 			log.Printf("   nodes: %v (%v%%, %v%%, %v%%), amount of buckets: %v (%v%%, %v%%), maximum possible buckets: %v", 
 				node_amount, 
@@ -159,6 +151,21 @@ func TestScheduler_CreateHistogram(t *testing.T) {
 	
 }
 
+func TestSearchPercentileByMultiply_EqualBounds(t *testing.T) {
+	histogram := NewHistogram(0, 10, 0)
+	for _, v := range []float64{0, 1, 2, 3} {
+		histogram.Enqueue(v, 1)
+	}
+	list := []*Histogram{histogram}
+	idx := histogram.GetIndexOfSubHistogram(0)
+	// 0.75 is reached at the bucket of 2, the last index left once the
+	// search in [0, 2] went up from the bucket of 1
+	v := SearchPercentileByMultiply(0.75, -1, list, make([]bool, 1), 0, 2, true, idx, 0, 0, 1, false)
+	assert.Equal(t, float64(2), v, "the index where lower meets upper is evaluated")
+	v = SearchPercentileByMultiply(0.75, -1, list, make([]bool, 1), 2, 2, true, idx, 0, 0, 1, false)
+	assert.Equal(t, float64(2), v, "a search starting with equal bounds")
+}
+
 // to do the benchmark:
 // ref: https://blog.logrocket.com/benchmarking-golang-improve-function-performance/
 // GOMAXPROCS=1 go test -run=MultiplyHistograms -bench=MultiplyHistograms -count=10 -timeout 99999s
@@ -232,4 +239,15 @@ func BenchmarkTestScheduler_MultiplyHistograms(t *testing.B) {
 
 
 	
+}
+
+func TestPercentileForValue_OutOfRange(t *testing.T) {
+	histogram := NewHistogram(10, 1, 0)
+	assert.Equal(t, float64(0), histogram.GetPercentileForValue(5), "empty histogram")
+	for i := 1; i <= 4; i++ {
+		histogram.Enqueue(float64(i*10), 1)
+	}
+	assert.Equal(t, float64(0), histogram.GetPercentileForValue(5), "below the smallest sample")
+	assert.Equal(t, float64(0.5), histogram.GetPercentileForValue(25), "between samples")
+	assert.Equal(t, float64(1), histogram.GetPercentileForValue(50), "above the largest sample")
 }
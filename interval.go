@@ -0,0 +1,186 @@
+package histogram
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Interval is the histogram of the samples recorded in [Start, End)
+type Interval struct {
+	Start     time.Time
+	End       time.Time
+	Histogram *Histogram
+}
+
+// IntervalRecorder records samples into tumbling intervals of a fixed
+// duration, aligned to multiples of it, and keeps a ring of the last
+// completed ones for rolling views. The intervals rotate when the recorder
+// is used, or on Tick for a recorder driven by a time.Ticker, so an idle
+// recorder hands over its completed intervals on the next call
+type IntervalRecorder struct {
+	interval time.Duration
+	options  []Option
+	now      func() time.Time
+
+	current *Interval
+	// the completed intervals, ring[head] is the oldest once the ring is full
+	ring      []*Interval
+	head      int
+	completed int
+	handlers  []func(*Interval)
+	mutex     sync.Mutex
+}
+
+// NewIntervalRecorder builds a recorder of intervals of the given duration
+// keeping the last keep completed ones. The options configure the histogram
+// of every interval, whose window is the interval itself, so a window
+// option is refused; WithClock sets the clock of the recorder as well
+func NewIntervalRecorder(interval time.Duration, keep int, opts ...Option) (*IntervalRecorder, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("%w: interval must be positive, got %v", ErrInvalidOption, interval)
+	}
+	if keep < 1 {
+		return nil, fmt.Errorf("%w: at least one interval must be kept, got %v", ErrInvalidOption, keep)
+	}
+	r := &IntervalRecorder{
+		interval: interval,
+		options:  append(append([]Option(nil), opts...), WithUnboundedWindow()),
+		ring:     make([]*Interval, keep),
+	}
+	h, err := New(r.options...)
+	if err != nil {
+		return nil, err
+	}
+	r.now = time.Now
+	if clock := h.GetConfig().Clock; clock != nil {
+		r.now = clock
+	}
+	start := r.now().Truncate(interval)
+	r.current = &Interval{Start: start, End: start.Add(interval), Histogram: h}
+	return r, nil
+}
+
+// OnInterval calls f with every completed interval, outside of the lock
+func (r *IntervalRecorder) OnInterval(f func(*Interval)) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.handlers = append(r.handlers, f)
+}
+
+// Enqueue records count samples of v into the current interval
+func (r *IntervalRecorder) Enqueue(v float64, count int) {
+	r.mutex.Lock()
+	done := r.rotate()
+	// under the lock, so no sample lands in an interval already handed over
+	r.current.Histogram.Enqueue(v, count)
+	r.mutex.Unlock()
+	r.handOver(done)
+}
+
+// Tick rotates the intervals if the current one is over
+func (r *IntervalRecorder) Tick() {
+	r.mutex.Lock()
+	done := r.rotate()
+	r.mutex.Unlock()
+	r.handOver(done)
+}
+
+// Current returns the interval being recorded
+func (r *IntervalRecorder) Current() *Interval {
+	r.mutex.Lock()
+	done := r.rotate()
+	current := r.current
+	r.mutex.Unlock()
+	r.handOver(done)
+	return current
+}
+
+// Last returns up to n of the last completed intervals, oldest first
+func (r *IntervalRecorder) Last(n int) []*Interval {
+	r.mutex.Lock()
+	done := r.rotate()
+	list := r.last(n)
+	r.mutex.Unlock()
+	r.handOver(done)
+	return list
+}
+
+// Merged returns a histogram of the samples of the last n completed
+// intervals, configured as the interval histograms, with the sum of their
+// tallies of invalid values; its queue is in ascending order of value
+// rather than of arrival
+func (r *IntervalRecorder) Merged(n int) *Histogram {
+	pairs := []ValueCount{}
+	tallies := ValueTallies{}
+	for _, i := range r.Last(n) {
+		snapshot := i.Histogram.Snapshot()
+		for v, count := range snapshot.All() {
+			pairs = append(pairs, ValueCount{Value: v, Count: int(count)})
+		}
+		tallies.add(snapshot.Invalid)
+	}
+
+	// the options were valid when the recorder was built
+	h, _ := New(r.options...)
+	h.BulkLoad(mergeValueCounts(pairs))
+	h.tallies.add(tallies)
+	return h
+}
+
+func (r *IntervalRecorder) last(n int) []*Interval {
+	kept := min(r.completed, len(r.ring))
+	n = max(min(n, kept), 0)
+	list := make([]*Interval, 0, n)
+	for k := kept - n; k < kept; k++ {
+		list = append(list, r.ring[(r.head+k)%len(r.ring)])
+	}
+	return list
+}
+
+// rotate completes the intervals that are over and returns them, the
+// intervals of a gap longer than the ring are skipped beyond the empty
+// ones the ring can keep
+func (r *IntervalRecorder) rotate() []*Interval {
+	now := r.now()
+	if now.Before(r.current.End) {
+		return nil
+	}
+	done := []*Interval{}
+	for !now.Before(r.current.End) {
+		r.push(r.current)
+		done = append(done, r.current)
+		start := r.current.End
+		if gap := now.Sub(start) / r.interval; gap > time.Duration(len(r.ring)) {
+			start = start.Add((gap - time.Duration(len(r.ring))) * r.interval)
+		}
+		// the options were valid when the recorder was built
+		h, _ := New(r.options...)
+		r.current = &Interval{Start: start, End: start.Add(r.interval), Histogram: h}
+	}
+	return done
+}
+
+func (r *IntervalRecorder) push(i *Interval) {
+	if r.completed < len(r.ring) {
+		r.ring[r.completed] = i
+	} else {
+		r.ring[r.head] = i
+		r.head = (r.head + 1) % len(r.ring)
+	}
+	r.completed++
+}
+
+func (r *IntervalRecorder) handOver(done []*Interval) {
+	if len(done) == 0 {
+		return
+	}
+	r.mutex.Lock()
+	handlers := r.handlers
+	r.mutex.Unlock()
+	for _, i := range done {
+		for _, f := range handlers {
+			f(i)
+		}
+	}
+}
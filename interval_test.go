@@ -0,0 +1,93 @@
+package histogram
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIntervalRecorder_Intervals(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 3, 0, time.UTC)
+	clock := func() time.Time { return now }
+	recorder, err := NewIntervalRecorder(10*time.Second, 3, WithClock(clock), WithPercentiles(0.5))
+	assert.Nil(t, err, "new")
+	completed := []*Interval{}
+	recorder.OnInterval(func(i *Interval) { completed = append(completed, i) })
+
+	current := recorder.Current()
+	assert.Equal(t, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), current.Start, "aligned to the interval")
+	assert.Equal(t, 10*time.Second, current.End.Sub(current.Start), "the interval")
+
+	all := NewHistogram(0, 10, 0)
+	for i := 0; i < 5; i++ {
+		for j := 1; j <= 10; j++ {
+			v := float64(i*100 + j)
+			recorder.Enqueue(v, 1)
+			all.Enqueue(v, 1)
+		}
+		now = now.Add(10 * time.Second)
+	}
+	assert.Equal(t, int64(0), recorder.Current().Histogram.Count, "a new interval")
+	assert.Equal(t, 5, len(completed), "handed over")
+	for i, c := range completed {
+		assert.Equal(t, int64(10), c.Histogram.Count, "interval %v", i)
+		assert.Equal(t, float64(i*100+5), c.Histogram.GetValueAtPercentile(0.5), "median of interval %v", i)
+	}
+
+	last := recorder.Last(10)
+	assert.Equal(t, 3, len(last), "only three are kept")
+	assert.Equal(t, completed[2:], last, "oldest first")
+	assert.Equal(t, completed[4:], recorder.Last(1), "the last one")
+
+	merged := recorder.Merged(2)
+	assert.Nil(t, merged.Verify(), "verify")
+	assert.Equal(t, int64(20), merged.Count, "two intervals")
+	assert.Equal(t, float64(310), merged.GetValueAtPercentile(0.5), "median of the last two")
+	assert.Equal(t, float64(301), merged.GetStatistics().Min, "min")
+}
+
+func TestIntervalRecorder_Gap(t *testing.T) {
+	now := time.Unix(1000, 0)
+	recorder, _ := NewIntervalRecorder(time.Second, 2, WithClock(func() time.Time { return now }))
+	completed := 0
+	recorder.OnInterval(func(i *Interval) { completed++ })
+	recorder.Enqueue(1, 1)
+
+	// an hour later only the ring's worth of empty intervals is handed over
+	now = now.Add(time.Hour)
+	recorder.Tick()
+	assert.Equal(t, 3, completed, "the recorded one and two empty ones")
+	last := recorder.Last(2)
+	assert.Equal(t, int64(0), last[0].Histogram.Count, "empty")
+	assert.Equal(t, last[1].End, recorder.Current().Start, "contiguous")
+	assert.Equal(t, now, recorder.Current().Start, "caught up")
+}
+
+func TestIntervalRecorder_Options(t *testing.T) {
+	_, err := NewIntervalRecorder(0, 1)
+	assert.True(t, errors.Is(err, ErrInvalidOption), "interval")
+	_, err = NewIntervalRecorder(time.Second, 0)
+	assert.True(t, errors.Is(err, ErrInvalidOption), "keep")
+	_, err = NewIntervalRecorder(time.Second, 1, WithWindowSize(10))
+	assert.True(t, errors.Is(err, ErrInvalidOption), "a window")
+}
+
+func TestIntervalRecorder_MergedTallies(t *testing.T) {
+	now := time.Unix(1000, 0)
+	recorder, _ := NewIntervalRecorder(time.Second, 3, WithClock(func() time.Time { return now }),
+		WithValueRange(0, 100), WithValuePolicy(ClampInvalidValues))
+	for _, v := range []float64{1, math.NaN(), 200, 2} {
+		recorder.Enqueue(v, 1)
+	}
+	now = now.Add(time.Second)
+	for _, v := range []float64{-5, math.NaN(), 3} {
+		recorder.Enqueue(v, 1)
+	}
+	now = now.Add(time.Second)
+
+	merged := recorder.Merged(2)
+	assert.Equal(t, int64(5), merged.Count, "the clamped samples are kept")
+	assert.Equal(t, ValueTallies{NaN: 2, Underflow: 1, Overflow: 1, Clamped: 2}, merged.GetValueTallies(), "the tallies of both intervals")
+}
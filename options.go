@@ -0,0 +1,294 @@
+package histogram
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrInvalidOption is wrapped by the errors of New and Reconfigure
+var ErrInvalidOption = errors.New("histogram: invalid option")
+
+// the accuracy is a power of ten, beyond these the unified values
+// lose the precision of a float64
+const (
+	minAccuracy = -15
+	maxAccuracy = 15
+)
+
+// PercentileMethod decides how GetValueAtPercentile picks a value
+type PercentileMethod int
+
+const (
+	// the largest sample whose cumulative fraction is no larger than p,
+	// which is what the tracked percentiles maintain
+	NearestRankPercentile PercentileMethod = iota
+	// linear interpolation between the two closest ranks
+	InterpolatedPercentile
+)
+
+func (m PercentileMethod) String() string {
+	switch m {
+	case NearestRankPercentile:
+		return "nearest-rank"
+	case InterpolatedPercentile:
+		return "interpolated"
+	}
+	return fmt.Sprintf("PercentileMethod(%d)", int(m))
+}
+
+// Option configures the histogram built by New
+type Option func(*options) error
+
+type options struct {
+	config      Config
+	windowSet   bool
+	unbounded   bool
+	subBucket   bool
+	percentiles []float64
+	thresholds  []float64
+}
+
+// WithWindowSize keeps at most size samples in the sliding window
+func WithWindowSize(size int64) Option {
+	return func(o *options) error {
+		if size <= 0 {
+			return fmt.Errorf("%w: window size must be positive, got %v", ErrInvalidOption, size)
+		}
+		o.config.QueueSize = size
+		o.windowSet = true
+		return nil
+	}
+}
+
+// WithTimeWindow evicts the samples older than d, either alone
+// or together with a window size
+func WithTimeWindow(d time.Duration) Option {
+	return func(o *options) error {
+		if d <= 0 {
+			return fmt.Errorf("%w: time window must be positive, got %v", ErrInvalidOption, d)
+		}
+		o.config.TimeWindow = d
+		o.windowSet = true
+		return nil
+	}
+}
+
+// WithUnboundedWindow keeps every sample until Dequeue or Reset,
+// which NewHistogram does for a size of 0
+func WithUnboundedWindow() Option {
+	return func(o *options) error {
+		o.unbounded = true
+		return nil
+	}
+}
+
+// WithAccuracy keeps the given decimal places of every sample,
+// a negative accuracy rounds to tens, hundreds and so on
+func WithAccuracy(decimalPlaces int) Option {
+	return func(o *options) error {
+		o.config.Accuracy = decimalPlaces
+		return nil
+	}
+}
+
+// WithSignificantDigits keeps the given significant digits of every sample
+// instead of decimal places, so a 0.0312ms and a 45123ms latency both keep
+// an error below 0.5% with 3 digits; the buckets then cover the positive values
+func WithSignificantDigits(digits int) Option {
+	return func(o *options) error {
+		if digits <= 0 {
+			return fmt.Errorf("%w: significant digits must be positive, got %v", ErrInvalidOption, digits)
+		}
+		o.config.SignificantDigits = digits
+		return nil
+	}
+}
+
+// WithSubBucketSize sets the range of values covered by each sub bucket histogram
+func WithSubBucketSize(size float64) Option {
+	return func(o *options) error {
+		if math.IsNaN(size) || math.IsInf(size, 0) || size <= 0 {
+			return fmt.Errorf("%w: sub bucket size must be a positive number, got %v", ErrInvalidOption, size)
+		}
+		o.config.SubBucketHistogramSize = size
+		o.subBucket = true
+		return nil
+	}
+}
+
+// WithBucketLayout replaces the linear layout of the bucket histogram,
+// with a LogarithmicLayout or LogLinearLayout for values spanning
+// several orders of magnitude
+func WithBucketLayout(layout BucketLayout) Option {
+	return func(o *options) error {
+		if layout == nil {
+			return fmt.Errorf("%w: bucket layout must not be nil", ErrInvalidOption)
+		}
+		o.config.BucketLayout = layout
+		return nil
+	}
+}
+
+// WithValuePolicy decides what happens to NaN, ±Inf and the values out of the range
+func WithValuePolicy(p ValuePolicy) Option {
+	return func(o *options) error {
+		o.config.ValuePolicy = p
+		return nil
+	}
+}
+
+// WithValueRange sets the range of the valid values, either bound may be infinite
+func WithValueRange(min float64, max float64) Option {
+	return func(o *options) error {
+		if math.IsNaN(min) || math.IsNaN(max) || min >= max {
+			return fmt.Errorf("%w: invalid value range [%v, %v]", ErrInvalidOption, min, max)
+		}
+		o.config.MinValue = min
+		o.config.MaxValue = max
+		return nil
+	}
+}
+
+// WithLogger sets the logger of the remaining diagnostics, the errors are
+// returned by the E variants such as EnqueueE
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) error {
+		o.config.Logger = logger
+		return nil
+	}
+}
+
+func WithPercentileMethod(m PercentileMethod) Option {
+	return func(o *options) error {
+		o.config.PercentileMethod = m
+		return nil
+	}
+}
+
+// WithPercentiles tracks the given percentiles from the first sample
+func WithPercentiles(ps ...float64) Option {
+	return func(o *options) error {
+		for _, p := range ps {
+			if math.IsNaN(p) || p < 0 || p > 1 {
+				return fmt.Errorf("%w: percentile must be within [0, 1], got %v", ErrInvalidOption, p)
+			}
+		}
+		o.percentiles = append(o.percentiles, ps...)
+		return nil
+	}
+}
+
+// WithThresholds tracks the fraction of samples below the given values
+func WithThresholds(vs ...float64) Option {
+	return func(o *options) error {
+		for _, v := range vs {
+			if math.IsNaN(v) {
+				return fmt.Errorf("%w: threshold must not be NaN", ErrInvalidOption)
+			}
+		}
+		o.thresholds = append(o.thresholds, vs...)
+		return nil
+	}
+}
+
+// WithClock replaces time.Now for the time window, mostly for tests
+func WithClock(now func() time.Time) Option {
+	return func(o *options) error {
+		if now == nil {
+			return fmt.Errorf("%w: clock must not be nil", ErrInvalidOption)
+		}
+		o.config.Clock = now
+		return nil
+	}
+}
+
+// New builds a histogram from the options; unlike NewHistogram it does not
+// read zero values as defaults, so the window has to be given explicitly
+// with WithWindowSize, WithTimeWindow or WithUnboundedWindow
+func New(opts ...Option) (*Histogram, error) {
+	o := &options{}
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	if o.unbounded && o.windowSet {
+		return nil, fmt.Errorf("%w: an unbounded window conflicts with a window size or time window", ErrInvalidOption)
+	}
+	if !o.unbounded && !o.windowSet {
+		return nil, fmt.Errorf("%w: no window, use WithWindowSize, WithTimeWindow or WithUnboundedWindow", ErrInvalidOption)
+	}
+	if o.subBucket && o.config.BucketLayout != nil {
+		return nil, fmt.Errorf("%w: a sub bucket size only applies to the default linear layout", ErrInvalidOption)
+	}
+	if !o.subBucket {
+		o.config.SubBucketHistogramSize = 10
+	}
+	if err := o.config.validate(); err != nil {
+		return nil, err
+	}
+
+	h := &Histogram{
+		mutex: &sync.Mutex{},
+	}
+	h.configure(o.config)
+	sort.Float64s(o.percentiles)
+	for _, p := range o.percentiles {
+		h.addPercentilePoint(p)
+	}
+	for _, v := range o.thresholds {
+		h.addThresholdPoint(v)
+	}
+	return h, nil
+}
+
+// validate reports the configurations the histogram cannot work with
+func (c Config) validate() error {
+	if c.QueueSize < 0 {
+		return fmt.Errorf("%w: negative queue size %v", ErrInvalidOption, c.QueueSize)
+	}
+	if c.TimeWindow < 0 {
+		return fmt.Errorf("%w: negative time window %v", ErrInvalidOption, c.TimeWindow)
+	}
+	if c.Accuracy < minAccuracy || c.Accuracy > maxAccuracy {
+		return fmt.Errorf("%w: accuracy %v is outside [%v, %v]", ErrInvalidOption, c.Accuracy, minAccuracy, maxAccuracy)
+	}
+	if c.SignificantDigits < 0 || c.SignificantDigits > maxSignificantDigits {
+		return fmt.Errorf("%w: significant digits %v are outside [0, %v]", ErrInvalidOption, c.SignificantDigits, maxSignificantDigits)
+	}
+	if c.SignificantDigits > 0 && c.Accuracy != 0 {
+		return fmt.Errorf("%w: accuracy in decimal places conflicts with significant digits", ErrInvalidOption)
+	}
+	sbs := c.SubBucketHistogramSize
+	if math.IsNaN(sbs) || math.IsInf(sbs, 0) || sbs < 0 {
+		return fmt.Errorf("%w: invalid sub bucket histogram size %v", ErrInvalidOption, sbs)
+	}
+	if sbs == 0 {
+		sbs = 10
+	}
+	if c.BucketLayout != nil {
+		if err := c.BucketLayout.Validate(); err != nil {
+			return fmt.Errorf("%w: invalid bucket layout: %v", ErrInvalidOption, err)
+		}
+	} else if unit := math.Pow(10, -float64(c.Accuracy)); c.SignificantDigits == 0 && sbs < unit {
+		return fmt.Errorf("%w: sub bucket size %v is smaller than the accuracy unit %v", ErrInvalidOption, sbs, unit)
+	}
+	if c.ValuePolicy != CountInvalidValues && c.ValuePolicy != RejectInvalidValues && c.ValuePolicy != ClampInvalidValues {
+		return fmt.Errorf("%w: unknown value policy %v", ErrInvalidOption, c.ValuePolicy)
+	}
+	if c.hasValueRange() && (math.IsNaN(c.MinValue) || math.IsNaN(c.MaxValue) || c.MinValue >= c.MaxValue) {
+		return fmt.Errorf("%w: invalid value range [%v, %v]", ErrInvalidOption, c.MinValue, c.MaxValue)
+	}
+	if c.PercentileMethod != NearestRankPercentile && c.PercentileMethod != InterpolatedPercentile {
+		return fmt.Errorf("%w: unknown percentile method %v", ErrInvalidOption, c.PercentileMethod)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package histogram
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptions_New(t *testing.T) {
+	histogram, err := New(
+		WithWindowSize(100),
+		WithAccuracy(1),
+		WithSubBucketSize(5),
+		WithPercentiles(0.99, 0.5),
+		WithThresholds(30),
+	)
+	assert.Nil(t, err, "valid options")
+	c := histogram.GetConfig()
+	assert.Equal(t, int64(100), c.QueueSize, "window size")
+	assert.Equal(t, float64(5), c.SubBucketHistogramSize, "sub bucket size")
+	assert.Equal(t, 1, c.Accuracy, "accuracy")
+	assert.Equal(t, []float64{0.5, 0.99}, histogram.ListPercentilePoints(), "tracked percentiles")
+
+	for i := 1; i <= 200; i++ {
+		histogram.Enqueue(float64(i)/2, 1)
+	}
+	assert.Equal(t, int64(100), histogram.Count, "the window is bounded")
+	assert.Equal(t, float64(75), histogram.GetValueAtPercentile(0.5), "tracked median")
+	assert.Equal(t, float64(0), histogram.GetFractionBelow(30), "tracked threshold")
+
+	// the defaults of New match NewHistogram apart from the window
+	histogram, err = New(WithUnboundedWindow())
+	assert.Nil(t, err, "unbounded window")
+	assert.Equal(t, NewHistogram(0, 0, 0).BucketHistogram.SubBucketHistogramSize,
+		histogram.BucketHistogram.SubBucketHistogramSize, "default sub bucket size")
+	assert.Equal(t, int64(0), histogram.QueueSize, "unbounded")
+}
+
+func TestOptions_NewRejectsInvalidOptions(t *testing.T) {
+	cases := map[string][]Option{
+		"no window":             {WithAccuracy(1)},
+		"zero window":           {WithWindowSize(0)},
+		"negative window":       {WithWindowSize(-1)},
+		"negative time window":  {WithTimeWindow(-time.Second)},
+		"unbounded with a size": {WithUnboundedWindow(), WithWindowSize(10)},
+		"NaN sub bucket size":   {WithWindowSize(10), WithSubBucketSize(math.NaN())},
+		"negative sub bucket":   {WithWindowSize(10), WithSubBucketSize(-10)},
+		"zero sub bucket":       {WithWindowSize(10), WithSubBucketSize(0)},
+		"sub bucket below unit": {WithWindowSize(10), WithAccuracy(-2), WithSubBucketSize(10)},
+		"accuracy out of range": {WithWindowSize(10), WithAccuracy(20)},
+		"percentile above 1":    {WithWindowSize(10), WithPercentiles(0.5, 99)},
+		"NaN threshold":         {WithWindowSize(10), WithThresholds(math.NaN())},
+		"unknown method":        {WithWindowSize(10), WithPercentileMethod(PercentileMethod(7))},
+		"nil clock":             {WithTimeWindow(time.Second), WithClock(nil)},
+	}
+	for name, opts := range cases {
+		histogram, err := New(opts...)
+		assert.Nil(t, histogram, name)
+		assert.True(t, errors.Is(err, ErrInvalidOption), name)
+	}
+
+	histogram := NewHistogram(10, 10, 0)
+	assert.True(t, errors.Is(histogram.Reconfigure(Config{Accuracy: -3}), ErrInvalidOption),
+		"Reconfigure shares the validation")
+}
+
+func TestOptions_TimeWindow(t *testing.T) {
+	now := time.Unix(1000, 0)
+	clock := func() time.Time { return now }
+	histogram, err := New(WithTimeWindow(10*time.Second), WithClock(clock), WithPercentiles(0.5))
+	assert.Nil(t, err, "valid options")
+
+	for i := 0; i < 20; i++ {
+		histogram.Enqueue(float64(i), 1)
+		now = now.Add(time.Second)
+	}
+	// the last Enqueue at 1019s kept the samples from 1009s, a sample
+	// exactly as old as the time window stays
+	assert.Equal(t, int64(11), histogram.Count, "enqueue expires the old samples")
+	assert.Equal(t, 1, histogram.Expire(), "the sample of 1009s expires at 1020s")
+	assert.Equal(t, int64(10), histogram.Count, "count after expiry")
+	assert.Equal(t, float64(10), histogram.GetStatistics().Min, "the oldest samples are gone")
+	assert.Equal(t, float64(14), histogram.GetValueAtPercentile(0.5), "tracked median after expiry")
+
+	now = now.Add(5 * time.Second)
+	histogram.Enqueue(100, 1)
+	assert.Equal(t, int64(6), histogram.Count, "the samples from 1015s are kept")
+	assert.Equal(t, len(histogram.queue), len(histogram.timestamps), "a timestamp per queued sample")
+
+	// the ages survive a rebuild
+	c := histogram.GetConfig()
+	c.Accuracy = 1
+	assert.Nil(t, histogram.Reconfigure(c), "valid configuration")
+	now = now.Add(2 * time.Second)
+	assert.Equal(t, 2, histogram.Expire(), "ages are kept across a rebuild")
+
+	// the time window combines with a window size
+	histogram, _ = New(WithTimeWindow(time.Hour), WithWindowSize(3), WithClock(clock))
+	for i := 0; i < 5; i++ {
+		histogram.Enqueue(float64(i), 1)
+	}
+	assert.Equal(t, int64(3), histogram.Count, "the size still bounds the window")
+	assert.Equal(t, 3, len(histogram.timestamps), "evicted samples drop their timestamps")
+}
+
+func TestOptions_InterpolatedPercentile(t *testing.T) {
+	histogram, _ := New(WithUnboundedWindow(), WithPercentileMethod(InterpolatedPercentile))
+	nearest, _ := New(WithUnboundedWindow(), WithPercentiles(0.5))
+	for i := 1; i <= 4; i++ {
+		histogram.Enqueue(float64(i*10), 1)
+		nearest.Enqueue(float64(i*10), 1)
+	}
+	assert.Equal(t, float64(25), histogram.GetValueAtPercentile(0.5), "interpolated median")
+	assert.Equal(t, float64(20), nearest.GetValueAtPercentile(0.5), "nearest rank median")
+	assert.True(t, math.IsNaN(histogram.GetValueAtPercentile(math.NaN())), "NaN percentile")
+}
@@ -0,0 +1,172 @@
+package histogram
+
+import (
+	"math"
+)
+
+type Kernel int
+
+const (
+	GaussianKernel Kernel = iota
+	EpanechnikovKernel
+)
+
+type BandwidthRule int
+
+const (
+	SilvermanBandwidth BandwidthRule = iota
+	ScottBandwidth
+)
+
+// the ratio of the canonical bandwidths of the Epanechnikov and Gaussian kernels,
+// so a rule of thumb derived for the Gaussian kernel gives the same smoothing
+const epanechnikovBandwidthFactor = 2.214
+
+// the most points PDF evaluates, larger requests are clamped to it
+const maxPDFPoints = 10000
+
+type PDFPoint struct {
+	Value   float64
+	Density float64
+}
+
+type PDF struct {
+	Points    []*PDFPoint `json:"points,omitempty"`
+	Bandwidth float64     `json:"bandwidth,omitempty"`
+	Kernel    Kernel      `json:"kernel"`
+}
+
+// support returns how far from a sample the kernel is worth evaluating
+func (k Kernel) support(bandwidth float64) float64 {
+	if k == EpanechnikovKernel {
+		return bandwidth
+	}
+	return 6 * bandwidth
+}
+
+// weight returns the unnormalized kernel at u = distance / bandwidth
+func (k Kernel) weight(u float64) float64 {
+	if k == EpanechnikovKernel {
+		if u <= -1 || u >= 1 {
+			return 0
+		}
+		return 1 - u*u
+	}
+	return math.Exp(-0.5 * u * u)
+}
+
+// norm makes the kernel integrate to one
+func (k Kernel) norm(bandwidth float64) float64 {
+	if k == EpanechnikovKernel {
+		return 0.75 / bandwidth
+	}
+	return 1 / (bandwidth * math.Sqrt(2*math.Pi))
+}
+
+// Bandwidth returns the rule of thumb bandwidth for the kernel,
+// based on the maintained variance and the interquartile range of the window
+func (h *Histogram) Bandwidth(rule BandwidthRule, kernel Kernel) float64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.bandwidth(rule, kernel)
+}
+
+func (h *Histogram) bandwidth(rule BandwidthRule, kernel Kernel) float64 {
+	if h.tree.total() == 0 {
+		return 0
+	}
+	n := float64(h.tree.total())
+	sigma := math.Sqrt(h.Variance)
+	iqr := h.interpolatedQuantile(0.75) - h.interpolatedQuantile(0.25)
+
+	bw := float64(0)
+	if rule == ScottBandwidth {
+		bw = 1.06 * sigma * math.Pow(n, -0.2)
+	} else {
+		spread := sigma
+		if iqr > 0 && iqr/1.34 < spread {
+			spread = iqr / 1.34
+		}
+		bw = 0.9 * spread * math.Pow(n, -0.2)
+	}
+	if bw <= 0 {
+		// a single distinct value, smooth over the accuracy of the histogram
+		bw = h.resolution(h.tree.value(h.tree.min))
+	}
+	if kernel == EpanechnikovKernel {
+		bw *= epanechnikovBandwidthFactor
+	}
+	return bw
+}
+
+// PDF estimates the density at evenly spaced points covering the window,
+// a bandwidth no larger than 0 selects it by the Silverman rule, a NaN or
+// infinite one returns no points, and points are clamped to maxPDFPoints;
+// the cost is O(points + distinct values in the kernel support of each point)
+// so it is cheap on a large window with few distinct values
+func (h *Histogram) PDF(points int, bandwidth float64, kernel Kernel) *PDF {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	pdf := &PDF{Kernel: kernel}
+	if h.tree.total() == 0 || points <= 0 || math.IsNaN(bandwidth) || math.IsInf(bandwidth, 0) {
+		return pdf
+	}
+	points = min(points, maxPDFPoints)
+	if bandwidth <= 0 {
+		bandwidth = h.bandwidth(SilvermanBandwidth, kernel)
+	}
+
+	margin := 3 * bandwidth
+	if kernel == EpanechnikovKernel {
+		margin = bandwidth
+	}
+	lower, upper := h.tree.value(h.tree.min)-margin, h.tree.value(h.tree.max)+margin
+	if math.IsInf(upper-lower, 0) {
+		// the bandwidth is so wide the grid overflows
+		return pdf
+	}
+	pdf.Bandwidth = bandwidth
+	values := make([]float64, points)
+	if points == 1 {
+		values[0] = (lower + upper) / 2
+	} else {
+		step := (upper - lower) / float64(points-1)
+		for i := range values {
+			values[i] = lower + float64(i)*step
+		}
+	}
+
+	density := h.kernelDensity(values, bandwidth, kernel)
+	pdf.Points = make([]*PDFPoint, points)
+	for i := range values {
+		pdf.Points[i] = &PDFPoint{Value: values[i], Density: density[i]}
+	}
+	return pdf
+}
+
+// kernelDensity evaluates a kernel density estimate at the sorted points,
+// iterating the distinct values and their duplications instead of the
+// raw samples, and skipping the values outside the support of the kernel
+func (h *Histogram) kernelDensity(points []float64, bandwidth float64, kernel Kernel) []float64 {
+	density := make([]float64, len(points))
+	t := &h.tree
+	if t.total() == 0 || len(points) == 0 {
+		return density
+	}
+	norm := kernel.norm(bandwidth) / float64(t.total())
+	cutoff := kernel.support(bandwidth)
+
+	first := t.min
+	for i, p := range points {
+		for first != 0 && t.nodes[first].value < p-cutoff {
+			first = t.nodes[first].larger
+		}
+		sum := float64(0)
+		for x := first; x != 0 && t.nodes[x].value <= p+cutoff; x = t.nodes[x].larger {
+			sum += float64(t.nodes[x].duplications) * kernel.weight((p-t.nodes[x].value)/bandwidth)
+		}
+		density[i] = sum * norm
+	}
+	return density
+}
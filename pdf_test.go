@@ -0,0 +1,68 @@
+package histogram
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+func integrate_pdf(pdf *PDF) float64 {
+	area := float64(0)
+	for i := 1; i < len(pdf.Points); i++ {
+		a, b := pdf.Points[i-1], pdf.Points[i]
+		area += (b.Value - a.Value) * (a.Density + b.Density) / 2
+	}
+	return area
+}
+
+func TestPDF_NormalWindow(t *testing.T) {
+	window_size := 100000
+	rng := rand.New(rand.NewSource(29))
+	histogram := NewHistogram(int64(window_size), float64(10), 1)
+	for i := 0; i < window_size; i++ {
+		histogram.Enqueue(100+10*rng.NormFloat64(), 1)
+	}
+
+	for _, kernel := range []Kernel{GaussianKernel, EpanechnikovKernel} {
+		pdf := histogram.PDF(256, 0, kernel)
+		assert.Equal(t, 256, len(pdf.Points), "amount of points should be as requested")
+		assert.Greater(t, pdf.Bandwidth, float64(0), "the bandwidth should be selected automatically")
+		assert.InDelta(t, float64(1), integrate_pdf(pdf), 0.01, "the density should integrate to one")
+
+		peak := pdf.Points[0]
+		for _, p := range pdf.Points {
+			if p.Density > peak.Density {
+				peak = p
+			}
+		}
+		assert.InDelta(t, float64(100), peak.Value, 2, "the peak should be at the mean")
+		assert.InDelta(t, 1/(10*math.Sqrt(2*math.Pi)), peak.Density, 0.003, "the peak density of N(100, 10)")
+	}
+
+	silverman := histogram.Bandwidth(SilvermanBandwidth, GaussianKernel)
+	scott := histogram.Bandwidth(ScottBandwidth, GaussianKernel)
+	assert.InDelta(t, 0.9*10*math.Pow(float64(window_size), -0.2), silverman, 0.1, "silverman rule of thumb")
+	assert.InDelta(t, 1.06*10*math.Pow(float64(window_size), -0.2), scott, 0.1, "scott rule of thumb")
+	assert.InDelta(t, silverman*epanechnikovBandwidthFactor, histogram.Bandwidth(SilvermanBandwidth, EpanechnikovKernel), 1e-9, "the bandwidth is rescaled for the Epanechnikov kernel")
+}
+
+func TestPDF_EdgeCases(t *testing.T) {
+	histogram := NewHistogram(10, float64(10), 1)
+	assert.Empty(t, histogram.PDF(10, 0, GaussianKernel).Points, "empty histogram has no density")
+
+	histogram.Enqueue(5, 4)
+	pdf := histogram.PDF(101, 0, EpanechnikovKernel)
+	assert.Equal(t, 0.1*epanechnikovBandwidthFactor, pdf.Bandwidth, "a single value falls back to the accuracy")
+	assert.InDelta(t, float64(1), integrate_pdf(pdf), 0.01, "the density should integrate to one")
+	assert.Equal(t, float64(0), pdf.Points[0].Density, "the Epanechnikov kernel has a bounded support")
+
+	pdf = histogram.PDF(3, 1, GaussianKernel)
+	assert.Equal(t, float64(5), pdf.Points[1].Value, "the grid is centered on the single value")
+	assert.InDelta(t, 1/math.Sqrt(2*math.Pi), pdf.Points[1].Density, 1e-9, "the density of a single value is the kernel")
+
+	for _, bandwidth := range []float64{math.NaN(), math.Inf(1), math.Inf(-1), math.MaxFloat64} {
+		assert.Empty(t, histogram.PDF(10, bandwidth, GaussianKernel).Points, "bandwidth %v", bandwidth)
+	}
+	assert.Equal(t, maxPDFPoints, len(histogram.PDF(math.MaxInt, 1, GaussianKernel).Points), "points are clamped")
+}
@@ -0,0 +1,87 @@
+package histogram
+
+import (
+	"math/rand"
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPercentilePoints_AddAfterData(t *testing.T) {
+	rng := rand.New(rand.NewSource(32))
+	percentile_list := []float64{0.1, 0.5, 0.9, 0.99}
+	early := NewHistogram(1000, float64(10), 1)
+	late := NewHistogram(1000, float64(10), 1)
+	for _, p := range percentile_list {
+		early.AddPercentilePoint(p)
+	}
+
+	for i := 0; i < 5000; i++ {
+		v := float64(rng.Intn(500))
+		count := 1 + rng.Intn(3)
+		early.Enqueue(v, count)
+		late.Enqueue(v, count)
+		if i == 2500 {
+			// adding points to a populated histogram must not break Enqueue
+			for _, p := range percentile_list {
+				late.AddPercentilePoint(p)
+			}
+		}
+		if i < 2500 || i%100 != 0 {
+			continue
+		}
+		for _, p := range percentile_list {
+			e, l := early.GetPercentileItem(p), late.GetPercentileItem(p)
+			assert.Equal(t, e.Value, l.Value, "late point at %v should track the same item", p)
+			assert.Equal(t, e.Count, l.Count, "late point at %v should have the same count", p)
+			assert.Equal(t, late.tree.cumulative(l.node), l.Count, "count of %v should be the cumulative count of the item", p)
+		}
+	}
+}
+
+func TestPercentilePoints_RemoveListAndMove(t *testing.T) {
+	histogram := NewHistogram(100, float64(10), 0)
+	for i := 1; i <= 10; i++ {
+		histogram.Enqueue(float64(i), 1)
+	}
+	histogram.AddPercentilePoint(0.9)
+	histogram.AddPercentilePoint(0.25)
+	histogram.AddPercentilePoint(0.5)
+	assert.Equal(t, []float64{0.25, 0.5, 0.9}, histogram.ListPercentilePoints(), "points should be listed in order")
+	assert.Equal(t, float64(2), histogram.GetValueAtPercentile(0.25), "initialized with nearest rank semantics")
+	assert.Equal(t, float64(5), histogram.GetValueAtPercentile(0.5), "initialized with nearest rank semantics")
+	assert.Equal(t, float64(9), histogram.GetValueAtPercentile(0.9), "initialized with nearest rank semantics")
+
+	histogram.AddPercentilePoint(0.05)
+	assert.Equal(t, float64(1), histogram.GetValueAtPercentile(0.05), "below the first rank falls back to the smallest")
+
+	assert.True(t, histogram.RemovePercentilePoint(0.05), "tracked point should be removed")
+	assert.False(t, histogram.RemovePercentilePoint(0.05), "untracked point cannot be removed")
+	assert.Nil(t, histogram.GetPercentileItem(0.05), "removed point should not be tracked")
+
+	w := histogram.Subscribe(0.9, WatchOptions{})
+	assert.True(t, histogram.MovePercentilePoint(0.9, 0.75), "tracked point should be moved")
+	assert.False(t, histogram.MovePercentilePoint(0.9, 0.75), "untracked point cannot be moved")
+	assert.Equal(t, []float64{0.25, 0.5, 0.75}, histogram.ListPercentilePoints(), "the moved point replaces the old one")
+	assert.Equal(t, 0.75, w.Percentile, "watchers follow the moved point")
+
+	histogram.Enqueue(11, 1)
+	assert.Equal(t, float64(8), histogram.GetValueAtPercentile(0.75), "the moved point keeps tracking")
+	histogram.Unwatch(w)
+}
+
+func TestPercentilePoints_RemoveClosesWatchers(t *testing.T) {
+	histogram := NewHistogram(100, float64(10), 0)
+	removed := histogram.Subscribe(0.5, WatchOptions{})
+	other := histogram.Subscribe(0.9, WatchOptions{})
+	assert.True(t, histogram.RemovePercentilePoint(0.5), "tracked point should be removed")
+	_, open := <-removed.C
+	assert.False(t, open, "the watcher of the removed point is closed")
+	assert.Equal(t, 1, len(histogram.watchers), "only the other watcher is kept")
+
+	histogram.Enqueue(5, 1)
+	e := <-other.C
+	assert.Equal(t, float64(5), e.New, "the other watcher keeps notifying")
+	// unwatching a closed watcher does nothing
+	histogram.Unwatch(removed)
+	histogram.Unwatch(other)
+}
@@ -450,7 +450,7 @@ func TestPercentileConcurrentAccess(t *testing.T) {
 	
 	// Verify histogram is still functional
 	assert.Greater(t, hist.Count, int64(0))
-	assert.NotNil(t, hist.RootItem)
+	assert.NotNil(t, hist.Tree())
 }
 
 // Test percentile calculation accuracy with known distributions
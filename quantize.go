@@ -0,0 +1,56 @@
+package histogram
+
+import (
+	"math"
+)
+
+// with more digits a sub histogram of the significant layout
+// would hold more than maxBucketIndex buckets
+const maxSignificantDigits = 5
+
+// decade returns the exponent e with 10^e <= v < 10^(e+1) for a positive v,
+// correcting the float error of Log10 around the powers of ten
+func decade(v float64) int {
+	e := int(math.Floor(math.Log10(v)))
+	if math.Pow10(e+1) <= v {
+		e++
+	} else if math.Pow10(e) > v {
+		e--
+	}
+	return e
+}
+
+// roundSignificant keeps the given significant digits of v, so the error
+// is at most half a unit of the last digit relative to the value
+func roundSignificant(v float64, digits int) float64 {
+	if v == 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+		return v
+	}
+	// the step of the last digit as a power of ten, divide by its inverse
+	// when it is below 1 since only the powers of ten above 1 are exact
+	step := decade(math.Abs(v)) - digits + 1
+	if step >= 0 {
+		r := math.Round(v/math.Pow10(step)) * math.Pow10(step)
+		if math.IsInf(r, 0) {
+			// rounding up overflowed next to the largest float, round down
+			return math.Trunc(v/math.Pow10(step)) * math.Pow10(step)
+		}
+		return r
+	}
+	if -step > 308 {
+		// the inverse overflows, these values are below any practical resolution
+		return v
+	}
+	return math.Round(v*math.Pow10(-step)) / math.Pow10(-step)
+}
+
+// resolution returns the distance between two neighbouring unified values around v
+func (h *Histogram) resolution(v float64) float64 {
+	if n := h.config.SignificantDigits; n > 0 {
+		if v == 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+			return math.Pow10(1 - n)
+		}
+		return math.Pow10(decade(math.Abs(v)) - n + 1)
+	}
+	return float64(1) / h.Accuracy
+}
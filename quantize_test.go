@@ -0,0 +1,108 @@
+package histogram
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuantize_RoundSignificant(t *testing.T) {
+	cases := [][3]float64{
+		// value, digits, expected
+		{0.031234, 2, 0.031},
+		{45123, 2, 45000},
+		{45678, 3, 45700},
+		{-45678, 3, -45700},
+		{9.996, 3, 10},
+		{1000, 1, 1000},
+		{0.001, 3, 0.001},
+		{0, 3, 0},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c[2], roundSignificant(c[0], int(c[1])), "rounding %v to %v digits", c[0], c[1])
+	}
+	for _, e := range []int{-300, -20, -3, 0, 1, 3, 15, 300} {
+		assert.Equal(t, e, decade(math.Pow10(e)), "a power of ten is the start of its decade")
+		assert.Equal(t, e-1, decade(math.Nextafter(math.Pow10(e), 0)), "just below a power of ten")
+	}
+
+	rng := rand.New(rand.NewSource(36))
+	for i := 0; i < 10000; i++ {
+		v := math.Exp(rng.Float64()*40 - 20)
+		r := roundSignificant(v, 3)
+		assert.LessOrEqual(t, math.Abs(r-v)/v, 0.005+1e-12, "relative error of %v", v)
+	}
+}
+
+func TestQuantize_SignificantDigits(t *testing.T) {
+	histogram, err := New(WithUnboundedWindow(), WithSignificantDigits(2), WithPercentiles(0.5))
+	assert.Nil(t, err, "valid options")
+	_, err = New(WithUnboundedWindow(), WithSignificantDigits(2), WithAccuracy(1))
+	assert.NotNil(t, err, "decimal places conflict with significant digits")
+	_, err = New(WithUnboundedWindow(), WithSignificantDigits(maxSignificantDigits+1))
+	assert.NotNil(t, err, "too many digits for the buckets")
+
+	// a decimal accuracy of 1 loses the small latencies and keeps the large ones exact
+	decimal := NewHistogram(0, 10, 1)
+	assert.Equal(t, float64(0), decimal.UnifiedValue(0.03), "decimal places lose small values")
+	assert.Equal(t, float64(0.03), histogram.UnifiedValue(0.03), "significant digits keep small values")
+	assert.Equal(t, float64(45000), histogram.UnifiedValue(45123.4), "significant digits round large values")
+
+	for _, v := range []float64{0.0312, 0.0318, 4.56, 45123, 45999, 0} {
+		histogram.Enqueue(v, 1)
+	}
+	assert.Equal(t, float64(0.031), histogram.tree.value(histogram.tree.nodes[histogram.tree.min].larger), "rounded to two digits")
+	assert.Equal(t, float64(46000), histogram.GetStatistics().Max, "rounded up into the next bucket")
+	assert.Equal(t, float64(0.032), histogram.GetValueAtPercentile(0.5), "tracked median")
+
+	// a sub histogram per decade, a bucket per value of the leading digits
+	b := histogram.BucketHistogram
+	assert.Equal(t, 90, histogram.GetMaximumSizeOfSubHistograms(), "buckets from 10 to 99")
+	idx, lower, upper := b.CalcPosition(45000)
+	assert.Equal(t, float64(10000), lower, "lower boundary of the decade")
+	assert.Equal(t, float64(100000), upper, "upper boundary of the decade")
+	sub := b.GetSubHistogram(idx)
+	assert.Equal(t, float64(1000), sub.BucketSize, "buckets as wide as the second digit")
+	assert.Equal(t, int64(1), sub.GetBucket(35), "bucket of 45")
+	assert.Equal(t, float64(45000), histogram.GetValueOfBucket(int(idx), 35), "value of the bucket")
+	idx, _, _ = b.CalcPosition(0.031)
+	assert.Equal(t, float64(0.031), histogram.GetValueOfBucket(int(idx), 21), "value of a bucket below 1")
+	idx, _, _ = b.CalcPosition(0)
+	assert.Equal(t, int64(-1), idx, "zero is not bucketed")
+
+	// Dequeue removes the oldest value from its bucket
+	histogram.Dequeue()
+	idx, _, _ = b.CalcPosition(0.031)
+	assert.Equal(t, int64(1), b.GetSubHistogram(idx).GetBucket(22), "still bucketed")
+	assert.Equal(t, int64(0), b.GetSubHistogram(idx).GetBucket(21), "deleted from the buckets")
+}
+
+func TestQuantize_ProductPercentile(t *testing.T) {
+	rng := rand.New(rand.NewSource(36))
+	histogram_list := []*Histogram{}
+	for i := 0; i < 3; i++ {
+		histogram, _ := New(WithWindowSize(20000), WithSignificantDigits(3))
+		for j := 0; j < 20000; j++ {
+			// from 1 microsecond to 60 seconds, in milliseconds
+			histogram.Enqueue(math.Exp(rng.Float64()*math.Log(6e7))*1e-3, 1)
+		}
+		histogram_list = append(histogram_list, histogram)
+	}
+	for _, p := range []float64{0.1, 0.5, 0.9, 0.99} {
+		v := CalcPercentileOfProduct(p, histogram_list, false)
+		prod := float64(1)
+		for _, h := range histogram_list {
+			prod *= h.GetPercentileForValue(v)
+		}
+		assert.InDelta(t, p, prod, 0.01, "product of the percentiles at %v", v)
+	}
+}
+
+func TestQuantize_ValueOfBucketWithDecimalPlaces(t *testing.T) {
+	histogram := NewHistogram(0, 10, 1)
+	histogram.Enqueue(23.4, 1)
+	idx := histogram.GetIndexOfSubHistogram(23.4)
+	assert.Equal(t, 100, histogram.GetMaximumSizeOfSubHistograms(), "buckets of 0.1 in 10")
+	assert.Equal(t, 23.4, histogram.GetValueOfBucket(idx, 34), "buckets are as wide as the accuracy")
+}
@@ -0,0 +1,389 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	histogram "github.com/robin98sun/avlhist-go"
+)
+
+// the first byte of a binary frame, which no StatsD line starts with:
+//
+//	0x00 | name length (1 byte) | name | amount of values (2 bytes) | values
+//
+// the amount and the float64 values are big endian
+const frameMarker = 0x00
+
+// the largest UDP datagram
+const maxDatagram = 65535
+
+// ListenerConfig configures a Listener
+type ListenerConfig struct {
+	// the options of the histograms created for unknown names,
+	// without them the samples of unknown names are dropped
+	Create []histogram.Option
+	// the registry size up to which Create adds histograms, 1024 by
+	// default, so that unknown names cannot grow it without bound
+	MaxHistograms int
+	// the samples buffered for the registry, 4096 by default; TCP
+	// connections wait when it is full, UDP datagrams are dropped
+	QueueSize int
+	// the lowest StatsD sample rate, 0.01 by default; lower rates are
+	// raised to it, so a value stands for at most 1/MinSampleRate samples
+	MinSampleRate float64
+	Logger        *slog.Logger
+}
+
+// ListenerStats counts the samples seen by a Listener
+type ListenerStats struct {
+	Received int64
+	Enqueued int64
+	// UDP samples that found the queue full
+	Dropped int64
+	// samples the value policy of their histogram dropped or refused
+	Rejected int64
+	// lines and frames that could not be parsed
+	Malformed int64
+	// StatsD metrics other than timers, histograms and distributions
+	Ignored int64
+	// samples for names without a histogram
+	Unknown int64
+	// samples for new names once the registry holds MaxHistograms
+	Refused int64
+}
+
+type sample struct {
+	name  string
+	value float64
+	count int
+}
+
+// Listener routes the samples it reads from TCP connections and UDP
+// datagrams to the histograms of a Registry by name. It reads the StatsD
+// lines name:value|ms, with the types h and d as well, an optional sample
+// rate |@0.1 and tags, and the compact binary frames of frameMarker
+type Listener struct {
+	registry *Registry
+	config   ListenerConfig
+	samples  chan sample
+	worker   sync.WaitGroup
+	readers  sync.WaitGroup
+
+	received  atomic.Int64
+	enqueued  atomic.Int64
+	dropped   atomic.Int64
+	rejected  atomic.Int64
+	malformed atomic.Int64
+	ignored   atomic.Int64
+	unknown   atomic.Int64
+	refused   atomic.Int64
+
+	mutex   sync.Mutex
+	closed  bool
+	closers map[io.Closer]struct{}
+}
+
+func NewListener(registry *Registry, c ListenerConfig) *Listener {
+	if c.QueueSize <= 0 {
+		c.QueueSize = 4096
+	}
+	if c.MaxHistograms <= 0 {
+		c.MaxHistograms = 1024
+	}
+	if !(c.MinSampleRate > 0 && c.MinSampleRate <= 1) {
+		c.MinSampleRate = 0.01
+	}
+	if c.Logger == nil {
+		c.Logger = slog.Default()
+	}
+	l := &Listener{
+		registry: registry,
+		config:   c,
+		samples:  make(chan sample, c.QueueSize),
+		closers:  map[io.Closer]struct{}{},
+	}
+	l.worker.Add(1)
+	go l.run()
+	return l
+}
+
+// Stats returns the counters since the listener was built
+func (l *Listener) Stats() ListenerStats {
+	return ListenerStats{
+		Received:  l.received.Load(),
+		Enqueued:  l.enqueued.Load(),
+		Dropped:   l.dropped.Load(),
+		Rejected:  l.rejected.Load(),
+		Malformed: l.malformed.Load(),
+		Ignored:   l.ignored.Load(),
+		Unknown:   l.unknown.Load(),
+		Refused:   l.refused.Load(),
+	}
+}
+
+// track registers what Close closes, false once the listener is closed
+func (l *Listener) track(c io.Closer) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.closed {
+		return false
+	}
+	l.closers[c] = struct{}{}
+	l.readers.Add(1)
+	return true
+}
+
+func (l *Listener) untrack(c io.Closer) {
+	l.mutex.Lock()
+	delete(l.closers, c)
+	l.mutex.Unlock()
+	l.readers.Done()
+}
+
+// Close stops the listeners and connections being served, then waits
+// until the buffered samples are in the registry
+func (l *Listener) Close() error {
+	l.mutex.Lock()
+	if l.closed {
+		l.mutex.Unlock()
+		return nil
+	}
+	l.closed = true
+	for c := range l.closers {
+		c.Close()
+	}
+	l.mutex.Unlock()
+
+	l.readers.Wait()
+	close(l.samples)
+	l.worker.Wait()
+	return nil
+}
+
+// ServeUDP reads datagrams until the connection or the listener is closed
+func (l *Listener) ServeUDP(conn net.PacketConn) error {
+	if !l.track(conn) {
+		return net.ErrClosed
+	}
+	defer l.untrack(conn)
+	buf := make([]byte, maxDatagram)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		l.parse(bufio.NewReader(bytes.NewReader(buf[:n])), false)
+	}
+}
+
+// ServeTCP accepts connections until the listener is closed
+func (l *Listener) ServeTCP(ln net.Listener) error {
+	if !l.track(ln) {
+		return net.ErrClosed
+	}
+	defer l.untrack(ln)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		if !l.track(conn) {
+			conn.Close()
+			return nil
+		}
+		go func() {
+			defer l.untrack(conn)
+			defer conn.Close()
+			l.parse(bufio.NewReader(conn), true)
+		}()
+	}
+}
+
+// parse reads lines and frames until the end of r, a TCP stream waits
+// for room in the queue while a datagram drops what does not fit
+func (l *Listener) parse(r *bufio.Reader, wait bool) {
+	for {
+		first, err := r.Peek(1)
+		if err != nil {
+			return
+		}
+		if first[0] == frameMarker {
+			if err := l.parseFrame(r, wait); err != nil {
+				l.malformed.Add(1)
+				if !errors.Is(err, errMalformed) {
+					// the stream is out of step or over
+					return
+				}
+			}
+			continue
+		}
+		line, err := r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			// no line is that long, skip the rest of it
+			l.malformed.Add(1)
+			for err == bufio.ErrBufferFull {
+				_, err = r.ReadSlice('\n')
+			}
+			continue
+		}
+		if len(bytes.TrimSpace(line)) > 0 {
+			l.parseLine(line, wait)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+var errMalformed = errors.New("server: malformed sample")
+
+func (l *Listener) parseFrame(r *bufio.Reader, wait bool) error {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return err
+	}
+	name := make([]byte, head[1])
+	if _, err := io.ReadFull(r, name); err != nil {
+		return err
+	}
+	var amount uint16
+	if err := binary.Read(r, binary.BigEndian, &amount); err != nil {
+		return err
+	}
+	values := make([]float64, amount)
+	if err := binary.Read(r, binary.BigEndian, values); err != nil {
+		return err
+	}
+	if len(name) == 0 {
+		return fmt.Errorf("%w: a frame without a name", errMalformed)
+	}
+	for _, v := range values {
+		l.offer(sample{name: string(name), value: v, count: 1}, wait)
+	}
+	return nil
+}
+
+// parseLine reads name:value|type[|@rate][|#tags], with several
+// values as name:value:value|type
+func (l *Listener) parseLine(line []byte, wait bool) {
+	fields := bytes.Split(bytes.TrimSpace(line), []byte{'|'})
+	name, rest, ok := bytes.Cut(fields[0], []byte{':'})
+	if !ok || len(name) == 0 || len(fields) < 2 {
+		l.malformed.Add(1)
+		return
+	}
+	switch string(fields[1]) {
+	case "ms", "h", "d":
+	default:
+		l.ignored.Add(1)
+		return
+	}
+	count := 1
+	for _, f := range fields[2:] {
+		if len(f) > 1 && f[0] == '@' {
+			rate, err := strconv.ParseFloat(string(f[1:]), 64)
+			if err != nil || !(rate > 0 && rate <= 1) {
+				l.malformed.Add(1)
+				return
+			}
+			count = int(math.Round(1 / max(rate, l.config.MinSampleRate)))
+		}
+	}
+	values := bytes.Split(rest, []byte{':'})
+	parsed := make([]float64, len(values))
+	for i, x := range values {
+		v, err := strconv.ParseFloat(string(x), 64)
+		if err != nil {
+			l.malformed.Add(1)
+			return
+		}
+		parsed[i] = v
+	}
+	for _, v := range parsed {
+		l.offer(sample{name: string(name), value: v, count: count}, wait)
+	}
+}
+
+func (l *Listener) offer(s sample, wait bool) {
+	l.received.Add(int64(s.count))
+	if wait {
+		l.samples <- s
+		return
+	}
+	select {
+	case l.samples <- s:
+	default:
+		l.dropped.Add(int64(s.count))
+	}
+}
+
+// run hands the samples to the registry, as one batch per name
+// of what is buffered at a time
+func (l *Listener) run() {
+	defer l.worker.Done()
+	batches := map[string][]float64{}
+	for s := range l.samples {
+		add := func(s sample) {
+			for i := 0; i < s.count; i++ {
+				batches[s.name] = append(batches[s.name], s.value)
+			}
+		}
+		add(s)
+	drain:
+		for i := 1; i < cap(l.samples); i++ {
+			select {
+			case s, ok := <-l.samples:
+				if !ok {
+					break drain
+				}
+				add(s)
+			default:
+				break drain
+			}
+		}
+		for name, values := range batches {
+			l.enqueue(name, values)
+			delete(batches, name)
+		}
+	}
+}
+
+func (l *Listener) enqueue(name string, values []float64) {
+	h := l.registry.Get(name)
+	if h == nil && l.config.Create != nil {
+		if l.registry.Len() >= l.config.MaxHistograms {
+			l.refused.Add(int64(len(values)))
+			return
+		}
+		var err error
+		h, err = l.registry.Create(name, l.config.Create...)
+		if errors.Is(err, ErrExists) {
+			h = l.registry.Get(name)
+		} else if err != nil {
+			l.config.Logger.Warn("server: cannot create histogram", "name", name, "error", err)
+		}
+	}
+	if h == nil {
+		l.unknown.Add(int64(len(values)))
+		return
+	}
+	n, _ := h.EnqueueBatch(values)
+	l.enqueued.Add(int64(n))
+	l.rejected.Add(int64(len(values) - n))
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+	histogram "github.com/robin98sun/avlhist-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func newReader(s string) *bufio.Reader {
+	return bufio.NewReader(strings.NewReader(s))
+}
+
+// frame encodes the values of name in the binary framing
+func frame(name string, values ...float64) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(frameMarker)
+	buf.WriteByte(byte(len(name)))
+	buf.WriteString(name)
+	binary.Write(&buf, binary.BigEndian, uint16(len(values)))
+	binary.Write(&buf, binary.BigEndian, values)
+	return buf.Bytes()
+}
+
+// waitFor polls the stats of the listener until done holds
+func waitFor(t *testing.T, l *Listener, done func(ListenerStats) bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !done(l.Stats()) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out with %+v", l.Stats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestListener_TCP(t *testing.T) {
+	registry := NewRegistry()
+	api, _ := registry.Create("api", histogram.WithUnboundedWindow())
+	listener := NewListener(registry, ListenerConfig{})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- listener.ServeTCP(ln) }()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("api:10|ms\napi:20|h|#region:eu\napi:30:40|d\n"))
+	conn.Write(frame("api", 50, 60, 70))
+	conn.Write([]byte("api:5|ms|@0.5\nrequests:1|c\nnope:1|ms\napi:fast|ms\nbroken\n"))
+	conn.Write(frame("api", 80))
+	conn.Close()
+
+	waitFor(t, listener, func(s ListenerStats) bool { return s.Enqueued+s.Unknown == 11 })
+	assert.Nil(t, listener.Close(), "close")
+	assert.Nil(t, <-served, "served until closed")
+
+	stats := listener.Stats()
+	assert.Equal(t, int64(11), stats.Received, "received")
+	assert.Equal(t, int64(10), stats.Enqueued, "enqueued")
+	assert.Equal(t, int64(1), stats.Unknown, "no histogram")
+	assert.Equal(t, int64(1), stats.Ignored, "a counter")
+	assert.Equal(t, int64(2), stats.Malformed, "malformed")
+	assert.Equal(t, int64(10), api.Count, "in the histogram")
+	assert.Equal(t, float64(5), api.GetStatistics().Min, "sampled at a rate")
+	assert.Equal(t, float64(80), api.GetStatistics().Max, "the last frame")
+}
+
+func TestListener_UDP(t *testing.T) {
+	registry := NewRegistry()
+	listener := NewListener(registry, ListenerConfig{Create: []histogram.Option{histogram.WithWindowSize(100)}})
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go listener.ServeUDP(conn)
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.Write([]byte("db:1|ms\ndb:2|ms"))
+	client.Write(append(frame("db", 3, 4), frame("cache", 5)...))
+	waitFor(t, listener, func(s ListenerStats) bool { return s.Enqueued == 5 })
+	listener.Close()
+
+	assert.Equal(t, []string{"cache", "db"}, registry.Names(), "created on the first sample")
+	assert.Equal(t, int64(4), registry.Get("db").Count, "db")
+	assert.Equal(t, int64(0), listener.Stats().Dropped, "nothing dropped")
+}
+
+func TestListener_Backpressure(t *testing.T) {
+	registry := NewRegistry()
+	h, _ := registry.Create("api", histogram.WithUnboundedWindow())
+	// the alert callback runs on the worker, which it holds up until released
+	release := make(chan struct{})
+	blocked := make(chan struct{}, 1)
+	slo, _ := h.AddSLO(0, 0.99)
+	h.OnSLOAlert(slo, func(*histogram.SLOStatus) {
+		blocked <- struct{}{}
+		<-release
+	})
+	listener := NewListener(registry, ListenerConfig{QueueSize: 4})
+
+	listener.parse(newReader("api:1|ms\n"), false)
+	<-blocked
+	// four fit into the queue, the rest of a datagram is dropped
+	listener.parse(newReader("api:2|ms\napi:3|ms\napi:4|ms\napi:5|ms\napi:6|ms\napi:7|ms\n"), false)
+	assert.Equal(t, int64(2), listener.Stats().Dropped, "dropped")
+
+	// a stream waits for room instead
+	done := make(chan struct{})
+	go func() {
+		listener.parse(newReader("api:8|ms\n"), true)
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("a full queue did not hold up the stream")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	<-done
+	listener.Close()
+	assert.Equal(t, int64(6), h.Count, "all but the dropped")
+	assert.Equal(t, int64(8), listener.Stats().Received, "received")
+}
+
+func TestListener_Limits(t *testing.T) {
+	registry := NewRegistry()
+	listener := NewListener(registry, ListenerConfig{
+		Create:        []histogram.Option{histogram.WithUnboundedWindow()},
+		MaxHistograms: 2,
+	})
+	listener.parse(newReader("a:1|ms\nb:NaN|ms\nb:2|ms|@0.000001\n"), true)
+	waitFor(t, listener, func(s ListenerStats) bool { return s.Enqueued+s.Rejected == 102 })
+	listener.parse(newReader("c:3|ms\na:4|ms\n"), true)
+	listener.Close()
+
+	stats := listener.Stats()
+	assert.Equal(t, []string{"a", "b"}, registry.Names(), "no more than MaxHistograms")
+	assert.Equal(t, int64(1), stats.Refused, "a new name beyond the limit")
+	assert.Equal(t, int64(1), stats.Rejected, "NaN is dropped by the value policy")
+	assert.Equal(t, int64(102), stats.Enqueued, "what the histograms took")
+	assert.Equal(t, int64(100), registry.Get("b").Count, "a rate below MinSampleRate is raised to it")
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	histogram "github.com/robin98sun/avlhist-go"
+)
+
+var (
+	// ErrExists is returned when a name is taken
+	ErrExists = errors.New("server: histogram exists")
+	// ErrNotFound is returned for an unknown name
+	ErrNotFound = errors.New("server: no such histogram")
+)
+
+// Registry is a set of named histograms, safe for concurrent use
+type Registry struct {
+	histograms map[string]*histogram.Histogram
+	mutex      sync.RWMutex
+}
+
+func NewRegistry() *Registry {
+	return &Registry{histograms: map[string]*histogram.Histogram{}}
+}
+
+// Create builds a histogram from the options under a new name
+func (r *Registry) Create(name string, opts ...histogram.Option) (*histogram.Histogram, error) {
+	if name == "" {
+		return nil, fmt.Errorf("%w: empty name", histogram.ErrInvalidOption)
+	}
+	h, err := histogram.New(opts...)
+	if err != nil {
+		return nil, err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.histograms[name]; ok {
+		return nil, fmt.Errorf("%w: %v", ErrExists, name)
+	}
+	r.histograms[name] = h
+	return h, nil
+}
+
+// Get returns the histogram of the name, nil if there is none
+func (r *Registry) Get(name string) *histogram.Histogram {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.histograms[name]
+}
+
+// Delete removes the histogram of the name, false if there was none
+func (r *Registry) Delete(name string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	_, ok := r.histograms[name]
+	delete(r.histograms, name)
+	return ok
+}
+
+// Len returns the amount of histograms
+func (r *Registry) Len() int {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return len(r.histograms)
+}
+
+// Names returns the names in ascending order
+func (r *Registry) Names() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	names := make([]string, 0, len(r.histograms))
+	for name := range r.histograms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
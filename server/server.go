@@ -0,0 +1,471 @@
+// Package server exposes the histograms of a Registry over a REST API:
+//
+//	GET    /histograms                         the names
+//	POST   /histograms/{name}                  create, the body is a CreateRequest
+//	DELETE /histograms/{name}                  delete
+//	POST   /histograms/{name}/reset            empty the window
+//	POST   /histograms/{name}/samples          JSON or line protocol samples
+//	GET    /histograms/{name}/percentiles?p=   values at the percentiles
+//	GET    /histograms/{name}/cdf?points=      the CDF
+//	GET    /histograms/{name}/bins             distinct values and their counts
+//	GET    /histograms/{name}/statistics       the Statistics
+//	GET    /product?p=&name=                   CalcPercentileOfProduct over the names
+//
+// Errors are answered as {"error": "..."}: 400 for invalid input, 404 for an
+// unknown name, 409 for a name taken or a query on an empty histogram.
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	histogram "github.com/robin98sun/avlhist-go"
+)
+
+// the largest request body, a batch of about a million samples
+const maxBodyBytes = 32 << 20
+
+// Config configures a Server
+type Config struct {
+	// the samples a request may enqueue, counts included, 1<<20 by
+	// default; a request asking for more is refused with 400
+	MaxSamples int
+	// the percentiles and the thresholds a CreateRequest may track, 128
+	// each by default, every Enqueue settles all of them
+	MaxPercentiles int
+	MaxThresholds  int
+	// receives the responses that cannot be written, nil is slog.Default()
+	Logger *slog.Logger
+}
+
+// Server is an http.Handler over a Registry
+type Server struct {
+	registry *Registry
+	config   Config
+	mux      *http.ServeMux
+}
+
+func New(registry *Registry, c Config) *Server {
+	if c.MaxSamples <= 0 {
+		c.MaxSamples = 1 << 20
+	}
+	if c.MaxPercentiles <= 0 {
+		c.MaxPercentiles = 128
+	}
+	if c.MaxThresholds <= 0 {
+		c.MaxThresholds = 128
+	}
+	if c.Logger == nil {
+		c.Logger = slog.Default()
+	}
+	s := &Server{registry: registry, config: c, mux: http.NewServeMux()}
+	s.mux.HandleFunc("GET /histograms", s.list)
+	s.mux.HandleFunc("POST /histograms/{name}", s.create)
+	s.mux.HandleFunc("DELETE /histograms/{name}", s.delete)
+	s.mux.HandleFunc("POST /histograms/{name}/reset", s.reset)
+	s.mux.HandleFunc("POST /histograms/{name}/samples", s.samples)
+	s.mux.HandleFunc("GET /histograms/{name}/percentiles", s.percentiles)
+	s.mux.HandleFunc("GET /histograms/{name}/cdf", s.cdf)
+	s.mux.HandleFunc("GET /histograms/{name}/bins", s.bins)
+	s.mux.HandleFunc("GET /histograms/{name}/statistics", s.statistics)
+	s.mux.HandleFunc("GET /product", s.product)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// CreateRequest configures a new histogram, without a window it is unbounded
+type CreateRequest struct {
+	Window int64 `json:"window,omitempty"`
+	// a time.Duration such as "5m"
+	TimeWindow string `json:"timeWindow,omitempty"`
+	// decimal places kept, so integers by default
+	Accuracy          int       `json:"accuracy,omitempty"`
+	SignificantDigits int       `json:"significantDigits,omitempty"`
+	SubBucketSize     float64   `json:"subBucketSize,omitempty"`
+	Percentiles       []float64 `json:"percentiles,omitempty"`
+	Thresholds        []float64 `json:"thresholds,omitempty"`
+}
+
+// Options translates the request into the options of histogram.New
+func (c CreateRequest) Options() ([]histogram.Option, error) {
+	opts := []histogram.Option{histogram.WithAccuracy(c.Accuracy)}
+	if c.Window != 0 {
+		opts = append(opts, histogram.WithWindowSize(c.Window))
+	}
+	if c.TimeWindow != "" {
+		d, err := time.ParseDuration(c.TimeWindow)
+		if err != nil {
+			return nil, fmt.Errorf("%w: time window: %v", histogram.ErrInvalidOption, err)
+		}
+		opts = append(opts, histogram.WithTimeWindow(d))
+	}
+	if c.Window == 0 && c.TimeWindow == "" {
+		opts = append(opts, histogram.WithUnboundedWindow())
+	}
+	if c.SignificantDigits != 0 {
+		opts = append(opts, histogram.WithSignificantDigits(c.SignificantDigits))
+	}
+	if c.SubBucketSize != 0 {
+		opts = append(opts, histogram.WithSubBucketSize(c.SubBucketSize))
+	}
+	if len(c.Percentiles) > 0 {
+		opts = append(opts, histogram.WithPercentiles(c.Percentiles...))
+	}
+	if len(c.Thresholds) > 0 {
+		opts = append(opts, histogram.WithThresholds(c.Thresholds...))
+	}
+	return opts, nil
+}
+
+// SamplesRequest is the JSON body of samples, either Value with an optional
+// Count or a batch of Values
+type SamplesRequest struct {
+	Value  *float64  `json:"value,omitempty"`
+	Count  int       `json:"count,omitempty"`
+	Values []float64 `json:"values,omitempty"`
+}
+
+type PercentileValue struct {
+	Percentile float64 `json:"percentile"`
+	Value      float64 `json:"value"`
+}
+
+type Bin struct {
+	Value float64 `json:"value"`
+	Count int64   `json:"count"`
+}
+
+// StatisticsResponse is histogram.Statistics with null for NaN
+type StatisticsResponse struct {
+	Count                   int64                  `json:"count"`
+	Sum                     float64                `json:"sum"`
+	Min                     float64                `json:"min"`
+	Max                     float64                `json:"max"`
+	Mean                    float64                `json:"mean"`
+	Variance                float64                `json:"variance"`
+	StdDev                  float64                `json:"stdDev"`
+	Skewness                float64                `json:"skewness"`
+	Kurtosis                float64                `json:"kurtosis"`
+	GeometricMean           *float64               `json:"geometricMean"`
+	HarmonicMean            *float64               `json:"harmonicMean"`
+	Median                  float64                `json:"median"`
+	MedianAbsoluteDeviation float64                `json:"medianAbsoluteDeviation"`
+	InterquartileRange      float64                `json:"interquartileRange"`
+	Invalid                 histogram.ValueTallies `json:"invalid"`
+}
+
+func finite(v float64) *float64 {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil
+	}
+	return &v
+}
+
+// writeJSON answers v with the status; the status is sent by then, so a
+// failure to encode or write v can only be logged
+func (s *Server) writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.config.Logger.Warn("server: cannot write response", "status", status, "error", err)
+	}
+}
+
+// writeError picks the status from the error
+func (s *Server) writeError(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	switch {
+	case errors.Is(err, ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrExists), errors.Is(err, histogram.ErrEmpty):
+		status = http.StatusConflict
+	case errors.Is(err, histogram.ErrCorruptTree):
+		status = http.StatusInternalServerError
+	}
+	s.writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func (s *Server) lookup(w http.ResponseWriter, r *http.Request) *histogram.Histogram {
+	name := r.PathValue("name")
+	h := s.registry.Get(name)
+	if h == nil {
+		s.writeError(w, fmt.Errorf("%w: %v", ErrNotFound, name))
+	}
+	return h
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, http.StatusOK, map[string][]string{"names": s.registry.Names()})
+}
+
+func (s *Server) create(w http.ResponseWriter, r *http.Request) {
+	var c CreateRequest
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	if err == nil && len(strings.TrimSpace(string(body))) > 0 {
+		err = json.Unmarshal(body, &c)
+	}
+	if err == nil && len(c.Percentiles) > s.config.MaxPercentiles {
+		err = fmt.Errorf("%w: more than %v percentiles", histogram.ErrInvalidOption, s.config.MaxPercentiles)
+	}
+	if err == nil && len(c.Thresholds) > s.config.MaxThresholds {
+		err = fmt.Errorf("%w: more than %v thresholds", histogram.ErrInvalidOption, s.config.MaxThresholds)
+	}
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	opts, err := c.Options()
+	if err == nil {
+		_, err = s.registry.Create(r.PathValue("name"), opts...)
+	}
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusCreated, map[string]string{"name": r.PathValue("name")})
+}
+
+func (s *Server) delete(w http.ResponseWriter, r *http.Request) {
+	if !s.registry.Delete(r.PathValue("name")) {
+		s.writeError(w, fmt.Errorf("%w: %v", ErrNotFound, r.PathValue("name")))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) reset(w http.ResponseWriter, r *http.Request) {
+	h := s.lookup(w, r)
+	if h == nil {
+		return
+	}
+	h.Reset()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// samples takes a JSON SamplesRequest, or text with a value and an optional
+// count per line; the valid samples of a batch are enqueued even when
+// another is refused by the value policy
+func (s *Server) samples(w http.ResponseWriter, r *http.Request) {
+	h := s.lookup(w, r)
+	if h == nil {
+		return
+	}
+	body := http.MaxBytesReader(w, r.Body, maxBodyBytes)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	enqueued := 0
+	var err error
+	if mediaType == "text/plain" {
+		enqueued, err = enqueueLines(h, body, s.config.MaxSamples)
+	} else {
+		var req SamplesRequest
+		if err = json.NewDecoder(body).Decode(&req); err == nil {
+			enqueued, err = enqueueJSON(h, req, s.config.MaxSamples)
+		}
+	}
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusAccepted, map[string]int{"enqueued": enqueued})
+}
+
+func enqueueJSON(h *histogram.Histogram, req SamplesRequest, limit int) (int, error) {
+	if req.Value == nil {
+		if len(req.Values) > limit {
+			return 0, fmt.Errorf("%w: more than %v samples", histogram.ErrInvalidValue, limit)
+		}
+		return h.EnqueueBatch(req.Values)
+	}
+	if len(req.Values) > 0 {
+		return 0, fmt.Errorf("%w: either value or values", histogram.ErrInvalidValue)
+	}
+	count := max(req.Count, 1)
+	if count > limit {
+		return 0, fmt.Errorf("%w: count %v is more than %v samples", histogram.ErrInvalidValue, count, limit)
+	}
+	_, err := h.EnqueueE(*req.Value, count)
+	return count, err
+}
+
+// enqueueLines parses every line before enqueuing them, so a malformed
+// body or one of more than limit samples enqueues nothing; a value and its
+// count are enqueued at once rather than repeated
+func enqueueLines(h *histogram.Histogram, body io.Reader, limit int) (int, error) {
+	lines := []histogram.ValueCount{}
+	total := 0
+	scanner := bufio.NewScanner(body)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) > 2 {
+			return 0, fmt.Errorf("%w: line %v: a value and an optional count", histogram.ErrInvalidValue, n)
+		}
+		v, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return 0, fmt.Errorf("%w: line %v: %v", histogram.ErrInvalidValue, n, err)
+		}
+		count := 1
+		if len(fields) == 2 {
+			if count, err = strconv.Atoi(fields[1]); err != nil || count < 1 {
+				return 0, fmt.Errorf("%w: line %v: count %q", histogram.ErrInvalidValue, n, fields[1])
+			}
+		}
+		if count > limit-total {
+			return 0, fmt.Errorf("%w: line %v: more than %v samples", histogram.ErrInvalidValue, n, limit)
+		}
+		total += count
+		lines = append(lines, histogram.ValueCount{Value: v, Count: count})
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	var first error
+	for _, l := range lines {
+		if _, err := h.EnqueueE(l.Value, l.Count); err != nil && first == nil {
+			first = err
+		}
+	}
+	return total, first
+}
+
+// parsePercentiles reads the p parameters, 0.99 by default
+func parsePercentiles(r *http.Request) ([]float64, error) {
+	list := r.URL.Query()["p"]
+	if len(list) == 0 {
+		return []float64{0.99}, nil
+	}
+	ps := make([]float64, len(list))
+	for i, x := range list {
+		p, err := strconv.ParseFloat(x, 64)
+		if err != nil || math.IsNaN(p) || p < 0 || p > 1 {
+			return nil, fmt.Errorf("%w: percentile %q", histogram.ErrInvalidValue, x)
+		}
+		ps[i] = p
+	}
+	return ps, nil
+}
+
+func (s *Server) percentiles(w http.ResponseWriter, r *http.Request) {
+	h := s.lookup(w, r)
+	if h == nil {
+		return
+	}
+	ps, err := parsePercentiles(r)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	list := make([]PercentileValue, len(ps))
+	for i, p := range ps {
+		v, err := h.PercentileE(p)
+		if err != nil {
+			s.writeError(w, err)
+			return
+		}
+		list[i] = PercentileValue{Percentile: p, Value: v}
+	}
+	s.writeJSON(w, http.StatusOK, map[string][]PercentileValue{"percentiles": list})
+}
+
+func (s *Server) cdf(w http.ResponseWriter, r *http.Request) {
+	h := s.lookup(w, r)
+	if h == nil {
+		return
+	}
+	points := 100
+	if x := r.URL.Query().Get("points"); x != "" {
+		n, err := strconv.Atoi(x)
+		if err != nil || n < 1 || n > 10000 {
+			s.writeError(w, fmt.Errorf("%w: points %q out of 1 to 10000", histogram.ErrInvalidValue, x))
+			return
+		}
+		points = n
+	}
+	s.writeJSON(w, http.StatusOK, h.Snapshot().CDF(points))
+}
+
+func (s *Server) bins(w http.ResponseWriter, r *http.Request) {
+	h := s.lookup(w, r)
+	if h == nil {
+		return
+	}
+	list := []Bin{}
+	for v, count := range h.Snapshot().All() {
+		list = append(list, Bin{Value: v, Count: count})
+	}
+	s.writeJSON(w, http.StatusOK, map[string][]Bin{"bins": list})
+}
+
+func (s *Server) statistics(w http.ResponseWriter, r *http.Request) {
+	h := s.lookup(w, r)
+	if h == nil {
+		return
+	}
+	st := h.GetStatistics()
+	s.writeJSON(w, http.StatusOK, &StatisticsResponse{
+		Count:                   st.Count,
+		Sum:                     st.Sum,
+		Min:                     st.Min,
+		Max:                     st.Max,
+		Mean:                    st.Mean,
+		Variance:                st.Variance,
+		StdDev:                  st.StdDev,
+		Skewness:                st.Skewness,
+		Kurtosis:                st.Kurtosis,
+		GeometricMean:           finite(st.GeometricMean),
+		HarmonicMean:            finite(st.HarmonicMean),
+		Median:                  st.Median,
+		MedianAbsoluteDeviation: st.MedianAbsoluteDeviation,
+		InterquartileRange:      st.InterquartileRange,
+		Invalid:                 st.Invalid,
+	})
+}
+
+// product runs CalcPercentileOfProduct on clones, which it reads without
+// the locks of the histograms
+func (s *Server) product(w http.ResponseWriter, r *http.Request) {
+	names := r.URL.Query()["name"]
+	if len(names) == 0 {
+		s.writeError(w, fmt.Errorf("%w: no name", histogram.ErrInvalidValue))
+		return
+	}
+	ps, err := parsePercentiles(r)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	list := make([]*histogram.Histogram, len(names))
+	for i, name := range names {
+		if list[i] = s.registry.Get(name); list[i] == nil {
+			s.writeError(w, fmt.Errorf("%w: %v", ErrNotFound, name))
+			return
+		}
+	}
+	for i, name := range names {
+		if list[i] = list[i].Clone(); list[i].Count == 0 {
+			s.writeError(w, fmt.Errorf("%w: %v", histogram.ErrEmpty, name))
+			return
+		}
+	}
+	values := make([]PercentileValue, len(ps))
+	for i, p := range ps {
+		values[i] = PercentileValue{Percentile: p, Value: histogram.CalcPercentileOfProduct(p, list, false)}
+	}
+	s.writeJSON(w, http.StatusOK, map[string][]PercentileValue{"percentiles": values})
+}
@@ -0,0 +1,243 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+// call sends a request to the server and decodes the JSON answer into out
+func call(t *testing.T, ts *httptest.Server, method string, path string, contentType string, body string, out any) int {
+	req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if out != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, out); err != nil {
+			t.Fatalf("%v %v: %v in %s", method, path, err, data)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestServer_Lifecycle(t *testing.T) {
+	ts := httptest.NewServer(New(NewRegistry(), Config{}))
+	defer ts.Close()
+
+	status := call(t, ts, "POST", "/histograms/api", "application/json", `{"window": 1000, "percentiles": [0.5, 0.99]}`, nil)
+	assert.Equal(t, http.StatusCreated, status, "create")
+	status = call(t, ts, "POST", "/histograms/api", "", "", nil)
+	assert.Equal(t, http.StatusConflict, status, "the name is taken")
+	status = call(t, ts, "POST", "/histograms/db", "", "", nil)
+	assert.Equal(t, http.StatusCreated, status, "create with the defaults")
+
+	var names map[string][]string
+	call(t, ts, "GET", "/histograms", "", "", &names)
+	assert.Equal(t, []string{"api", "db"}, names["names"], "list")
+
+	var errorBody map[string]string
+	status = call(t, ts, "GET", "/histograms/api/percentiles?p=0.5", "", "", &errorBody)
+	assert.Equal(t, http.StatusConflict, status, "empty")
+	assert.Contains(t, errorBody["error"], "empty", "the reason")
+
+	var enqueued map[string]int
+	status = call(t, ts, "POST", "/histograms/api/samples", "application/json", `{"value": 7, "count": 3}`, &enqueued)
+	assert.Equal(t, http.StatusAccepted, status, "single")
+	assert.Equal(t, 3, enqueued["enqueued"], "single")
+	call(t, ts, "POST", "/histograms/api/samples", "application/json", `{"values": [1, 2, 3, 4, 5, 6]}`, &enqueued)
+	assert.Equal(t, 6, enqueued["enqueued"], "batch")
+	status = call(t, ts, "POST", "/histograms/api/samples", "text/plain; charset=utf-8", "8\n9 2\n\n10\n", &enqueued)
+	assert.Equal(t, http.StatusAccepted, status, "lines")
+	assert.Equal(t, 4, enqueued["enqueued"], "lines")
+
+	var percentiles map[string][]PercentileValue
+	status = call(t, ts, "GET", "/histograms/api/percentiles?p=0.5&p=0.99", "", "", &percentiles)
+	assert.Equal(t, http.StatusOK, status, "percentiles")
+	// 1 2 3 4 5 6 7 7 7 8 9 9 10
+	assert.Equal(t, []PercentileValue{{0.5, 6}, {0.99, 9}}, percentiles["percentiles"], "percentiles")
+
+	var bins map[string][]Bin
+	call(t, ts, "GET", "/histograms/api/bins", "", "", &bins)
+	assert.Equal(t, 10, len(bins["bins"]), "distinct values")
+	assert.Equal(t, Bin{Value: 7, Count: 3}, bins["bins"][6], "duplicates")
+
+	var cdf struct {
+		Points []struct{ Percentile, Value float64 } `json:"points"`
+	}
+	call(t, ts, "GET", "/histograms/api/cdf?points=4", "", "", &cdf)
+	assert.Equal(t, 4, len(cdf.Points), "points")
+	assert.Equal(t, float64(10), cdf.Points[3].Value, "the maximum")
+
+	var stats StatisticsResponse
+	call(t, ts, "GET", "/histograms/api/statistics", "", "", &stats)
+	assert.Equal(t, int64(13), stats.Count, "count")
+	assert.Equal(t, float64(78), stats.Sum, "sum")
+	assert.NotNil(t, stats.GeometricMean, "positive samples")
+	call(t, ts, "POST", "/histograms/db/samples", "application/json", `{"values": [0, 1]}`, nil)
+	stats = StatisticsResponse{}
+	status = call(t, ts, "GET", "/histograms/db/statistics", "", "", &stats)
+	assert.Equal(t, http.StatusOK, status, "NaN is not JSON")
+	assert.Nil(t, stats.GeometricMean, "null for a zero")
+
+	status = call(t, ts, "POST", "/histograms/api/reset", "", "", nil)
+	assert.Equal(t, http.StatusNoContent, status, "reset")
+	call(t, ts, "GET", "/histograms/api/statistics", "", "", &stats)
+	assert.Equal(t, int64(0), stats.Count, "reset")
+
+	status = call(t, ts, "DELETE", "/histograms/api", "", "", nil)
+	assert.Equal(t, http.StatusNoContent, status, "delete")
+	status = call(t, ts, "DELETE", "/histograms/api", "", "", nil)
+	assert.Equal(t, http.StatusNotFound, status, "deleted")
+	status = call(t, ts, "GET", "/histograms/api/bins", "", "", nil)
+	assert.Equal(t, http.StatusNotFound, status, "deleted")
+}
+
+func TestServer_BadRequests(t *testing.T) {
+	ts := httptest.NewServer(New(NewRegistry(), Config{}))
+	defer ts.Close()
+	call(t, ts, "POST", "/histograms/api", "application/json", `{"window": 10}`, nil)
+
+	for _, c := range []struct {
+		method, path, contentType, body string
+	}{
+		{"POST", "/histograms/other", "application/json", `{"window": -1}`},
+		{"POST", "/histograms/other", "application/json", `{"timeWindow": "soon"}`},
+		{"POST", "/histograms/other", "application/json", `{`},
+		{"POST", "/histograms/api/samples", "application/json", `{"value": 1, "values": [2]}`},
+		{"POST", "/histograms/api/samples", "text/plain", "1\nfast\n"},
+		{"POST", "/histograms/api/samples", "text/plain", "1 0\n"},
+		{"GET", "/histograms/api/percentiles?p=1.5", "", ""},
+		{"GET", "/histograms/api/cdf?points=0", "", ""},
+		{"GET", "/product?p=0.5", "", ""},
+	} {
+		var body map[string]string
+		status := call(t, ts, c.method, c.path, c.contentType, c.body, &body)
+		assert.Equal(t, http.StatusBadRequest, status, "%v %v %v", c.method, c.path, c.body)
+		assert.NotEmpty(t, body["error"], "%v %v %v", c.method, c.path, c.body)
+	}
+
+	var stats StatisticsResponse
+	call(t, ts, "GET", "/histograms/api/statistics", "", "", &stats)
+	assert.Equal(t, int64(0), stats.Count, "a malformed body enqueues nothing")
+	status := call(t, ts, "GET", "/product?p=0.5&name=api&name=nope", "", "", nil)
+	assert.Equal(t, http.StatusNotFound, status, "unknown name")
+}
+
+func TestServer_Product(t *testing.T) {
+	registry := NewRegistry()
+	ts := httptest.NewServer(New(registry, Config{}))
+	defer ts.Close()
+	for _, name := range []string{"a", "b"} {
+		call(t, ts, "POST", "/histograms/"+name, "application/json", `{"window": 100}`, nil)
+		call(t, ts, "POST", "/histograms/"+name+"/samples", "application/json", `{"values": [1, 2, 3, 4, 5, 6, 7, 8, 9, 10]}`, nil)
+	}
+
+	var percentiles map[string][]PercentileValue
+	status := call(t, ts, "GET", "/product?p=0.81&name=a&name=b", "", "", &percentiles)
+	assert.Equal(t, http.StatusOK, status, "product")
+	assert.Equal(t, float64(9), percentiles["percentiles"][0].Value, "0.9 * 0.9")
+
+	// the product reads clones while samples keep coming
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			registry.Get("a").Enqueue(float64(i%10+1), 1)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			call(t, ts, "GET", "/product?p=0.81&name=a&name=b", "", "", nil)
+		}
+	}()
+	wg.Wait()
+}
+
+func TestServer_SampleLimit(t *testing.T) {
+	registry := NewRegistry()
+	ts := httptest.NewServer(New(registry, Config{MaxSamples: 10}))
+	defer ts.Close()
+	call(t, ts, "POST", "/histograms/api", "", "", nil)
+
+	for _, c := range []struct {
+		contentType, body string
+	}{
+		{"text/plain", "1 2000000000\n"},
+		{"text/plain", "1 6\n2 5\n"},
+		{"application/json", `{"value": 1, "count": 2000000000}`},
+		{"application/json", `{"values": [1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11]}`},
+	} {
+		var body map[string]string
+		status := call(t, ts, "POST", "/histograms/api/samples", c.contentType, c.body, &body)
+		assert.Equal(t, http.StatusBadRequest, status, c.body)
+		assert.Contains(t, body["error"], "more than 10 samples", c.body)
+	}
+	assert.Equal(t, int64(0), registry.Get("api").Count, "nothing enqueued")
+
+	var enqueued map[string]int
+	status := call(t, ts, "POST", "/histograms/api/samples", "text/plain", "1 6\n2 4\n", &enqueued)
+	assert.Equal(t, http.StatusAccepted, status, "up to the limit")
+	assert.Equal(t, 10, enqueued["enqueued"], "up to the limit")
+	assert.Equal(t, int64(10), registry.Get("api").Count, "up to the limit")
+}
+
+func TestServer_CreateLimits(t *testing.T) {
+	registry := NewRegistry()
+	ts := httptest.NewServer(New(registry, Config{MaxPercentiles: 3, MaxThresholds: 2}))
+	defer ts.Close()
+
+	for _, c := range []struct {
+		body, reason string
+	}{
+		{`{"percentiles": [0.1, 0.5, 0.9, 0.99]}`, "more than 3 percentiles"},
+		{`{"thresholds": [1, 2, 3]}`, "more than 2 thresholds"},
+	} {
+		var body map[string]string
+		status := call(t, ts, "POST", "/histograms/api", "application/json", c.body, &body)
+		assert.Equal(t, http.StatusBadRequest, status, c.body)
+		assert.Contains(t, body["error"], c.reason, c.body)
+	}
+	assert.Nil(t, registry.Get("api"), "nothing created")
+
+	status := call(t, ts, "POST", "/histograms/api", "application/json", `{"percentiles": [0.5, 0.9, 0.99], "thresholds": [1, 2]}`, nil)
+	assert.Equal(t, http.StatusCreated, status, "up to the limits")
+	assert.Equal(t, []float64{0.5, 0.9, 0.99}, registry.Get("api").ListPercentilePoints(), "up to the limits")
+}
+
+// brokenWriter is a client gone before the answer is written
+type brokenWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (w brokenWriter) Write([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestServer_WriteFailureLogged(t *testing.T) {
+	var logs bytes.Buffer
+	s := New(NewRegistry(), Config{Logger: slog.New(slog.NewTextHandler(&logs, nil))})
+	w := brokenWriter{httptest.NewRecorder()}
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/histograms", nil))
+	assert.Equal(t, http.StatusOK, w.Code, "the status is sent")
+	assert.Contains(t, logs.String(), "cannot write response", "the failure is logged")
+	assert.Contains(t, logs.String(), "connection reset", "with its error")
+}
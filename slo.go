@@ -0,0 +1,226 @@
+package histogram
+
+import (
+	"fmt"
+	"math"
+)
+
+// SLO is a latency objective such as "99% of requests under 300ms"
+// over the sliding window of a histogram: a sample is good when its
+// unified value is no larger than the threshold
+type SLO struct {
+	Threshold float64
+	Objective float64
+	// alert when the burn rate of every window reaches this rate
+	BurnRateThreshold float64
+
+	good      int64
+	windows   []*burnWindow
+	alerting  bool
+	onAlert   func(*SLOStatus)
+	onResolve func(*SLOStatus)
+}
+
+// burnWindow counts the bad samples among the latest Size samples of the queue
+type burnWindow struct {
+	Size int64
+	bad  int64
+}
+
+type SLOStatus struct {
+	Threshold    float64
+	Objective    float64
+	Total        int64
+	Good         int64
+	GoodFraction float64
+	// 1 when no error is spent, negative once the budget is overspent
+	ErrorBudgetRemaining float64
+	// burn rate of each short window in the order they were added,
+	// followed by the burn rate of the whole sliding window
+	BurnRates []float64
+	Alerting  bool
+}
+
+// AddSLO attaches an objective to the histogram; the optional burn windows are
+// sizes in samples of the latest part of the sliding window, and an alert is
+// raised when all of them and the whole window burn the error budget at least
+// BurnRateThreshold times faster than sustainable, which defaults to 1;
+// the objective must be within (0, 1) and the threshold finite
+func (h *Histogram) AddSLO(threshold float64, objective float64, burnWindows ...int64) (*SLO, error) {
+	if !(objective > 0 && objective < 1) {
+		return nil, fmt.Errorf("%w: objective must be within (0, 1), got %v", ErrInvalidOption, objective)
+	}
+	if math.IsNaN(threshold) || math.IsInf(threshold, 0) {
+		return nil, fmt.Errorf("%w: threshold must be finite, got %v", ErrInvalidOption, threshold)
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	slo := &SLO{
+		Threshold:         threshold,
+		Objective:         objective,
+		BurnRateThreshold: 1,
+	}
+	for _, size := range burnWindows {
+		if size > 0 {
+			slo.windows = append(slo.windows, &burnWindow{Size: size})
+		}
+	}
+
+	// count what is already in the window
+	slo.good = h.tree.rank(threshold)
+	for _, w := range slo.windows {
+		for i := len(h.queue) - 1; i >= 0 && int64(len(h.queue)-i) <= w.Size; i-- {
+			if h.queue[i] > threshold {
+				w.bad++
+			}
+		}
+	}
+	slo.alerting = slo.isAlerting(h)
+
+	h.SLOs = append(h.SLOs, slo)
+	return slo, nil
+}
+
+func (h *Histogram) RemoveSLO(slo *SLO) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for i, s := range h.SLOs {
+		if s == slo {
+			h.SLOs = append(h.SLOs[:i], h.SLOs[i+1:]...)
+			return
+		}
+	}
+}
+
+// OnSLOAlert sets the callback run when the burn rates cross the threshold,
+// it runs after Enqueue or Dequeue released the histogram
+func (h *Histogram) OnSLOAlert(slo *SLO, f func(*SLOStatus)) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	slo.onAlert = f
+}
+
+// OnSLOResolve sets the callback run when an alerting SLO recovers
+func (h *Histogram) OnSLOResolve(slo *SLO, f func(*SLOStatus)) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	slo.onResolve = f
+}
+
+func (h *Histogram) GetSLOStatus(slo *SLO) *SLOStatus {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return slo.status(h)
+}
+
+func (slo *SLO) reset() {
+	slo.good = 0
+	for _, w := range slo.windows {
+		w.bad = 0
+	}
+}
+
+// enter accounts for a sample just appended to the queue,
+// and for the sample it pushes out of every short window
+func (slo *SLO) enter(h *Histogram, v float64) {
+	if v <= slo.Threshold {
+		slo.good++
+	}
+	l := int64(len(h.queue))
+	for _, w := range slo.windows {
+		if v > slo.Threshold {
+			w.bad++
+		}
+		if l > w.Size && h.queue[l-1-w.Size] > slo.Threshold {
+			w.bad--
+		}
+	}
+}
+
+// leave accounts for the sample about to be removed from the head of the queue,
+// which is still inside the short windows as large as the queue
+func (slo *SLO) leave(h *Histogram, v float64) {
+	if v <= slo.Threshold {
+		slo.good--
+	}
+	l := int64(len(h.queue))
+	for _, w := range slo.windows {
+		if l <= w.Size && v > slo.Threshold {
+			w.bad--
+		}
+	}
+}
+
+// burnRate is how many times faster than sustainable the bad samples
+// spend the error budget
+func (slo *SLO) burnRate(bad int64, total int64) float64 {
+	if total == 0 || bad == 0 {
+		return 0
+	}
+	budget := 1 - slo.Objective
+	if budget <= 0 {
+		return math.Inf(1)
+	}
+	return float64(bad) / float64(total) / budget
+}
+
+// windowTotal is the amount of samples in a short window
+func (w *burnWindow) windowTotal(h *Histogram) int64 {
+	if l := int64(len(h.queue)); l < w.Size {
+		return l
+	}
+	return w.Size
+}
+
+func (slo *SLO) isAlerting(h *Histogram) bool {
+	total := int64(len(h.queue))
+	if total == 0 || slo.burnRate(total-slo.good, total) < slo.BurnRateThreshold {
+		return false
+	}
+	for _, w := range slo.windows {
+		if slo.burnRate(w.bad, w.windowTotal(h)) < slo.BurnRateThreshold {
+			return false
+		}
+	}
+	return true
+}
+
+func (slo *SLO) status(h *Histogram) *SLOStatus {
+	s := &SLOStatus{
+		Threshold:            slo.Threshold,
+		Objective:            slo.Objective,
+		Total:                int64(len(h.queue)),
+		Good:                 slo.good,
+		GoodFraction:         1,
+		ErrorBudgetRemaining: 1,
+		Alerting:             slo.isAlerting(h),
+	}
+	for _, w := range slo.windows {
+		s.BurnRates = append(s.BurnRates, slo.burnRate(w.bad, w.windowTotal(h)))
+	}
+	full := slo.burnRate(s.Total-s.Good, s.Total)
+	s.BurnRates = append(s.BurnRates, full)
+	if s.Total > 0 {
+		s.GoodFraction = float64(s.Good) / float64(s.Total)
+		s.ErrorBudgetRemaining = 1 - full
+	}
+	return s
+}
+
+// evaluate queues the alert or resolve callback when the state changes
+func (slo *SLO) evaluate(h *Histogram) {
+	alerting := slo.isAlerting(h)
+	if alerting == slo.alerting {
+		return
+	}
+	slo.alerting = alerting
+	f := slo.onResolve
+	if alerting {
+		f = slo.onAlert
+	}
+	if f != nil {
+		s := slo.status(h)
+		h.callbacks = append(h.callbacks, func() { f(s) })
+	}
+}
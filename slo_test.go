@@ -0,0 +1,122 @@
+package histogram
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSLO_AlertAndResolve(t *testing.T) {
+	histogram := NewHistogram(1000, float64(10), 1)
+	slo, err := histogram.AddSLO(300, 0.99, 100)
+	assert.Nil(t, err, "a valid objective")
+
+	alerts, resolves := []*SLOStatus{}, []*SLOStatus{}
+	histogram.OnSLOAlert(slo, func(s *SLOStatus) {
+		// the histogram is released before the callback runs
+		assert.Equal(t, s.Total, histogram.Count, "the callback can query the histogram")
+		alerts = append(alerts, s)
+	})
+	histogram.OnSLOResolve(slo, func(s *SLOStatus) {
+		resolves = append(resolves, s)
+	})
+
+	for i := 0; i < 1000; i++ {
+		histogram.Enqueue(100, 1)
+	}
+	status := histogram.GetSLOStatus(slo)
+	assert.Equal(t, float64(1), status.GoodFraction, "every sample is good")
+	assert.Equal(t, float64(1), status.ErrorBudgetRemaining, "no budget is spent")
+	assert.False(t, status.Alerting, "should not alert without bad samples")
+
+	// a burst of slow requests burns both windows
+	for i := 0; i < 20; i++ {
+		histogram.Enqueue(500, 1)
+	}
+	assert.Equal(t, 1, len(alerts), "the alert should fire once when crossing")
+	status = histogram.GetSLOStatus(slo)
+	assert.Equal(t, int64(980), status.Good, "good samples in the window")
+	assert.InDelta(t, 0.98, status.GoodFraction, 1e-9, "fraction of good samples")
+	assert.InDelta(t, float64(-1), status.ErrorBudgetRemaining, 1e-9, "the budget is overspent twice")
+	assert.InDelta(t, float64(20), status.BurnRates[0], 1e-9, "short window burn rate")
+	assert.InDelta(t, float64(2), status.BurnRates[1], 1e-9, "whole window burn rate")
+
+	// the short window recovers first and resolves the multi-window alert
+	for i := 0; i < 100; i++ {
+		histogram.Enqueue(100, 1)
+	}
+	assert.Equal(t, 1, len(resolves), "the resolve should fire once the short window recovers")
+	assert.False(t, histogram.GetSLOStatus(slo).Alerting, "should not be alerting anymore")
+}
+
+func TestSLO_MatchesWindow(t *testing.T) {
+	rng := rand.New(rand.NewSource(30))
+	histogram := NewHistogram(500, float64(10), 0)
+	values := []float64{}
+	for i := 0; i < 300; i++ {
+		v := float64(rng.Intn(400))
+		values = append(values, v)
+		histogram.Enqueue(v, 1)
+	}
+	// attached after data exists and with a window larger than the queue
+	slo, _ := histogram.AddSLO(250, 0.9, 50, 2000)
+
+	for i := 0; i < 2000; i++ {
+		if rng.Intn(10) == 0 && histogram.Count > 0 {
+			histogram.Dequeue()
+			values = values[1:]
+		} else {
+			v := float64(rng.Intn(400))
+			count := 1 + rng.Intn(2)
+			for c := 0; c < count; c++ {
+				values = append(values, v)
+			}
+			histogram.Enqueue(v, count)
+			if len(values) > 500 {
+				values = values[len(values)-500:]
+			}
+		}
+
+		bad, bad_short := 0, 0
+		for j, v := range values {
+			if v > 250 {
+				bad++
+				if j >= len(values)-50 {
+					bad_short++
+				}
+			}
+		}
+		status := histogram.GetSLOStatus(slo)
+		short_total := len(values)
+		if short_total > 50 {
+			short_total = 50
+		}
+		assert.Equal(t, int64(len(values)-bad), status.Good, "good samples should match the window")
+		assert.InDelta(t, float64(bad_short)/float64(short_total)/0.1, status.BurnRates[0], 1e-9, "short window burn rate")
+		assert.InDelta(t, float64(bad)/float64(len(values))/0.1, status.BurnRates[1], 1e-9, "window larger than the queue")
+		if t.Failed() {
+			break
+		}
+	}
+
+	histogram.RemoveSLO(slo)
+	assert.Empty(t, histogram.SLOs, "the slo should be detached")
+}
+
+func TestSLO_InvalidObjective(t *testing.T) {
+	histogram := NewHistogram(100, float64(10), 1)
+	for _, c := range []struct {
+		threshold float64
+		objective float64
+	}{
+		{300, 0}, {300, 1}, {300, -0.5}, {300, 99}, {300, math.NaN()},
+		{math.NaN(), 0.99}, {math.Inf(1), 0.99}, {math.Inf(-1), 0.99},
+	} {
+		slo, err := histogram.AddSLO(c.threshold, c.objective)
+		assert.True(t, errors.Is(err, ErrInvalidOption), "threshold %v objective %v", c.threshold, c.objective)
+		assert.Nil(t, slo, "threshold %v objective %v", c.threshold, c.objective)
+	}
+	assert.Equal(t, 0, len(histogram.SLOs), "nothing attached")
+}
@@ -0,0 +1,141 @@
+package histogram
+
+import (
+	"iter"
+	"sort"
+	"time"
+)
+
+// Snapshot is an immutable point-in-time view of a histogram for the read
+// queries, so reports run without holding the lock of the histogram.
+// The tree cannot be shared by path copying, its nodes link their parents
+// and their neighbours in the sorted list, so a snapshot flattens the
+// distinct values and their cumulative counts into two arrays instead:
+// O(d) to take for d distinct values, O(log d) to query
+type Snapshot struct {
+	Count    int64
+	Min      float64
+	Max      float64
+	Mean     float64
+	Variance float64
+	// the invalid samples seen by the histogram
+	Invalid ValueTallies
+	// when it was taken, by the clock of the histogram
+	Time time.Time
+
+	method     PercentileMethod
+	values     []float64
+	cumulative []int64
+}
+
+// Snapshot takes a snapshot of the window in O(d) under the lock
+func (h *Histogram) Snapshot() *Snapshot {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	s := &Snapshot{
+		Count:    h.Count,
+		Mean:     h.Mean,
+		Variance: h.Variance,
+		Invalid:  h.tallies,
+		Time:     h.now(),
+		method:   h.config.PercentileMethod,
+	}
+	t := &h.tree
+	if t.root == 0 {
+		return s
+	}
+	s.Min, s.Max = t.value(t.min), t.value(t.max)
+	total := int64(0)
+	for i := t.min; i != 0; i = t.nodes[i].larger {
+		total += t.nodes[i].duplications
+		s.values = append(s.values, t.nodes[i].value)
+		s.cumulative = append(s.cumulative, total)
+	}
+	return s
+}
+
+// valueAtRank returns the value of the sample at the 0-based rank
+func (s *Snapshot) valueAtRank(rank int64) float64 {
+	i := sort.Search(len(s.cumulative), func(i int) bool { return s.cumulative[i] > rank })
+	return s.values[min(i, len(s.values)-1)]
+}
+
+// upTo returns the largest value with at most count samples up to it,
+// false if even the smallest one has more
+func (s *Snapshot) upTo(count int64) (float64, bool) {
+	i := sort.Search(len(s.cumulative), func(i int) bool { return s.cumulative[i] > count })
+	if i == 0 {
+		return 0, false
+	}
+	return s.values[i-1], true
+}
+
+// GetValueAtPercentile returns the value at p by the rule the histogram
+// uses for every percentile, tracked or not, so the two always agree;
+// with InterpolatedPercentile it interpolates between the two closest ranks
+func (s *Snapshot) GetValueAtPercentile(p float64) float64 {
+	if len(s.values) == 0 {
+		return 0
+	}
+	if s.method == InterpolatedPercentile {
+		if p <= 0 {
+			return s.Min
+		}
+		if p >= 1 {
+			return s.Max
+		}
+		pos := p * float64(s.Count-1)
+		lower_rank := int64(pos)
+		lower := s.valueAtRank(lower_rank)
+		if float64(lower_rank) == pos {
+			return lower
+		}
+		upper := s.valueAtRank(lower_rank + 1)
+		return lower + (upper-lower)*(pos-float64(lower_rank))
+	}
+	return atPercentile(p, s.Count, s.upTo, s.values[0])
+}
+
+// GetPercentileForValue returns the fraction of the samples no larger than v
+func (s *Snapshot) GetPercentileForValue(v float64) float64 {
+	i := sort.Search(len(s.values), func(i int) bool { return s.values[i] > v })
+	if i == 0 {
+		return 0
+	}
+	return float64(s.cumulative[i-1]) / float64(s.Count)
+}
+
+// All iterates over the distinct values in ascending order
+// with the amount of samples of each
+func (s *Snapshot) All() iter.Seq2[float64, int64] {
+	return func(yield func(float64, int64) bool) {
+		before := int64(0)
+		for i, v := range s.values {
+			if !yield(v, s.cumulative[i]-before) {
+				return
+			}
+			before = s.cumulative[i]
+		}
+	}
+}
+
+// CDF exports the values at the percentiles 1/points, 2/points, ... 1,
+// which CDF.Histogram turns back into a histogram of points samples
+func (s *Snapshot) CDF(points int) *CDF {
+	if points <= 0 {
+		return NewCDF(0)
+	}
+	cdf := NewCDF(points)
+	if len(s.values) == 0 {
+		return cdf
+	}
+	// no mass below the first point, which CDF.Histogram would pad with zeros
+	cdf.StartPoint = 0
+	cdf.Increment = float64(1) / float64(points)
+	for i := range cdf.Points {
+		p := float64(i+1) / float64(points)
+		cdf.Points[i] = &CDFPoint{Percentile: p, Value: s.GetValueAtPercentile(p)}
+	}
+	return cdf
+}
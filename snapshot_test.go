@@ -0,0 +1,122 @@
+package histogram
+
+import (
+	"math/rand"
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshot_CloneIsIndependent(t *testing.T) {
+	histogram, _ := New(WithWindowSize(500), WithAccuracy(1), WithPercentiles(0.5, 0.99), WithThresholds(40))
+	rng := rand.New(rand.NewSource(11))
+	for i := 0; i < 800; i++ {
+		histogram.Enqueue(float64(rng.Intn(1000))/10, 1)
+	}
+	clone := histogram.Clone()
+	assert.Nil(t, clone.Verify(), "verify the clone")
+	assertSameHistogram(t, histogram, clone, "clone")
+	buckets := func(h *Histogram) [][3]int64 {
+		list := [][3]int64{}
+		for idx, sbh := range h.BucketHistogram.All() {
+			for b, count := range sbh.All() {
+				list = append(list, [3]int64{idx, b, count})
+			}
+		}
+		return list
+	}
+	assert.Equal(t, buckets(histogram), buckets(clone), "the same bucket counts")
+
+	// the two histograms go their own ways
+	want := histogram.Clone()
+	for i := 0; i < 300; i++ {
+		v := float64(rng.Intn(1000)) / 10
+		clone.Enqueue(v, 1)
+		want.Enqueue(v, 1)
+	}
+	histogram.Reset()
+	assert.Nil(t, histogram.Verify(), "verify the original")
+	assert.Equal(t, int64(0), histogram.Count, "the original is reset")
+	assertSameHistogram(t, want, clone, "after the clone diverged")
+}
+
+func TestSnapshot_Queries(t *testing.T) {
+	histogram, _ := New(WithWindowSize(1000), WithPercentiles(0.1, 0.5, 0.9, 0.99))
+	empty := histogram.Snapshot()
+	assert.Equal(t, float64(0), empty.GetValueAtPercentile(0.5), "empty")
+	assert.Equal(t, float64(0), empty.GetPercentileForValue(1), "empty")
+
+	rng := rand.New(rand.NewSource(5))
+	for i := 0; i < 1500; i++ {
+		histogram.Enqueue(float64(rng.Intn(200)), 1)
+	}
+	snapshot := histogram.Snapshot()
+	for _, p := range []float64{0.1, 0.5, 0.9, 0.99} {
+		assert.Equal(t, histogram.GetValueAtPercentile(p), snapshot.GetValueAtPercentile(p), "percentile %v", p)
+	}
+	// an untracked percentile is answered as if it were tracked
+	for p := 0.005; p < 1; p += 0.01 {
+		tracked := histogram.Clone()
+		tracked.AddPercentilePoint(p)
+		assert.Equal(t, tracked.GetValueAtPercentile(p), snapshot.GetValueAtPercentile(p), "untracked percentile %v", p)
+	}
+	for v := float64(-1); v <= 200; v += 0.5 {
+		assert.Equal(t, histogram.GetPercentileForValue(v), snapshot.GetPercentileForValue(v), "rank of %v", v)
+	}
+	total := int64(0)
+	previous := float64(-1)
+	for v, count := range snapshot.All() {
+		assert.Less(t, previous, v, "ascending")
+		previous = v
+		total += count
+	}
+	assert.Equal(t, histogram.Count, total, "all samples")
+
+	// the snapshot does not move with the histogram
+	p99 := snapshot.GetValueAtPercentile(0.99)
+	for i := 0; i < 1000; i++ {
+		histogram.Enqueue(1000, 1)
+	}
+	assert.Equal(t, float64(1000), histogram.GetValueAtPercentile(0.99), "the histogram moved")
+	assert.Equal(t, p99, snapshot.GetValueAtPercentile(0.99), "the snapshot did not")
+
+	cdf := snapshot.CDF(100)
+	assert.Equal(t, 100, len(cdf.Points), "points")
+	assert.Equal(t, snapshot.GetValueAtPercentile(0.5), cdf.Points[49].Value, "median")
+	assert.Equal(t, snapshot.Max, cdf.Points[99].Value, "maximum")
+	assert.Equal(t, int64(100), cdf.Histogram().Count, "back into a histogram")
+}
+
+func TestSnapshot_Interpolated(t *testing.T) {
+	histogram, _ := New(WithWindowSize(100), WithPercentileMethod(InterpolatedPercentile))
+	for i := 1; i <= 10; i++ {
+		histogram.Enqueue(float64(i), 1+i%2)
+	}
+	snapshot := histogram.Snapshot()
+	for _, p := range []float64{0, 0.05, 0.25, 0.5, 0.77, 1} {
+		assert.InDelta(t, histogram.GetValueAtPercentile(p), snapshot.GetValueAtPercentile(p), 1e-9, "percentile %v", p)
+	}
+}
+
+func TestSnapshot_UntrackedPercentiles(t *testing.T) {
+	// the histogram and its snapshot share the rule of the percentiles,
+	// the exact fractions of the window included
+	histogram, _ := New(WithWindowSize(700), WithAccuracy(3))
+	rng := rand.New(rand.NewSource(17))
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 400; i++ {
+			histogram.Enqueue(float64(rng.Intn(50*(round+1)))/3, 1+rng.Intn(3))
+		}
+		snapshot := histogram.Snapshot()
+		percentiles := []float64{0, 1, 1e-9, 1 - 1e-9}
+		for i := int64(0); i <= histogram.Count; i += 7 {
+			percentiles = append(percentiles, float64(i)/float64(histogram.Count))
+		}
+		for i := 0; i < 200; i++ {
+			percentiles = append(percentiles, rng.Float64())
+		}
+		for _, p := range percentiles {
+			assert.Equal(t, histogram.GetValueAtPercentile(p), snapshot.GetValueAtPercentile(p), "round %v percentile %v", round, p)
+		}
+		assert.Nil(t, histogram.Verify(), "round %v", round)
+	}
+}
@@ -0,0 +1,144 @@
+package histogram
+
+import (
+	"iter"
+	"sort"
+)
+
+// entries of a page of a sparseList
+const sparsePageBits = 6
+const sparsePageSize = 1 << sparsePageBits
+
+// sparseList is an array indexed by non-negative int64 holding only the
+// pages with at least one non-zero entry, sorted by index, so far apart
+// entries such as an outlier do not allocate the gap between them
+type sparseList[T comparable] struct {
+	pages []*sparsePage[T]
+	// where the emptied pages go and the new ones come from, nil allocates
+	pool *sparsePool[T]
+}
+
+// sparsePool keeps the emptied pages of the lists sharing it for reuse
+type sparsePool[T comparable] struct {
+	pages []*sparsePage[T]
+}
+
+func (p *sparsePool[T]) get(base int64) *sparsePage[T] {
+	if p == nil || len(p.pages) == 0 {
+		return &sparsePage[T]{base: base}
+	}
+	page := p.pages[len(p.pages)-1]
+	p.pages[len(p.pages)-1] = nil
+	p.pages = p.pages[:len(p.pages)-1]
+	page.base = base
+	return page
+}
+
+// put takes a page whose entries are all zero
+func (p *sparsePool[T]) put(page *sparsePage[T]) {
+	if p != nil {
+		p.pages = append(p.pages, page)
+	}
+}
+
+type sparsePage[T comparable] struct {
+	base  int64
+	used  int
+	items [sparsePageSize]T
+}
+
+// find returns the position of the page holding idx, or where it belongs
+func (l *sparseList[T]) find(idx int64) (int, bool) {
+	base := idx &^ (sparsePageSize - 1)
+	i := sort.Search(len(l.pages), func(i int) bool { return l.pages[i].base >= base })
+	return i, i < len(l.pages) && l.pages[i].base == base
+}
+
+func (l *sparseList[T]) get(idx int64) T {
+	var zero T
+	if idx < 0 {
+		return zero
+	}
+	i, ok := l.find(idx)
+	if !ok {
+		return zero
+	}
+	return l.pages[i].items[idx&(sparsePageSize-1)]
+}
+
+// set stores v at idx, the zero value clears the entry and drops its page once empty
+func (l *sparseList[T]) set(idx int64, v T) {
+	var zero T
+	i, ok := l.find(idx)
+	if !ok {
+		if v == zero {
+			return
+		}
+		page := l.pool.get(idx &^ (sparsePageSize - 1))
+		l.pages = append(l.pages, nil)
+		copy(l.pages[i+1:], l.pages[i:])
+		l.pages[i] = page
+	}
+	page := l.pages[i]
+	slot := &page.items[idx&(sparsePageSize-1)]
+	if *slot == zero && v != zero {
+		page.used++
+	} else if *slot != zero && v == zero {
+		page.used--
+	}
+	*slot = v
+	if page.used == 0 {
+		copy(l.pages[i:], l.pages[i+1:])
+		l.pages[len(l.pages)-1] = nil
+		l.pages = l.pages[:len(l.pages)-1]
+		l.pool.put(page)
+	}
+}
+
+// length is the highest index with a non-zero entry plus one
+func (l *sparseList[T]) length() int64 {
+	var zero T
+	if len(l.pages) == 0 {
+		return 0
+	}
+	page := l.pages[len(l.pages)-1]
+	for j := sparsePageSize - 1; j >= 0; j-- {
+		if page.items[j] != zero {
+			return page.base + int64(j) + 1
+		}
+	}
+	return page.base
+}
+
+// count is the amount of non-zero entries
+func (l *sparseList[T]) count() int {
+	n := 0
+	for _, page := range l.pages {
+		n += page.used
+	}
+	return n
+}
+
+// all yields the non-zero entries in ascending order of index
+func (l *sparseList[T]) all() iter.Seq2[int64, T] {
+	return func(yield func(int64, T) bool) {
+		var zero T
+		for _, page := range l.pages {
+			for j := range page.items {
+				if v := page.items[j]; v != zero && !yield(page.base+int64(j), v) {
+					return
+				}
+			}
+		}
+	}
+}
+
+func (l *sparseList[T]) reset() {
+	for _, page := range l.pages {
+		clear(page.items[:])
+		page.used = 0
+		l.pool.put(page)
+	}
+	clear(l.pages)
+	l.pages = l.pages[:0]
+}
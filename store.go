@@ -0,0 +1,430 @@
+package histogram
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// segment files are named after the UnixNano of their start
+const segmentSuffix = ".seg"
+
+// StoreConfig configures a Store, a zero SegmentDuration is an hour
+type StoreConfig struct {
+	// the span of the starts of the records of a segment file,
+	// which is also the longest record
+	SegmentDuration time.Duration
+	// Compact deletes the segments that ended longer ago, 0 keeps them
+	Retention time.Duration
+	// Compact merges the records of the segments that ended longer ago than
+	// DownsampleAfter into intervals of DownsampleInterval per label set,
+	// DownsampleInterval divides SegmentDuration
+	DownsampleAfter    time.Duration
+	DownsampleInterval time.Duration
+	// the options of the histograms returned by Query, whose window is unbounded
+	Options []Option
+	// replaces time.Now for Compact, mostly for tests
+	Clock func() time.Time
+}
+
+// Record is a stored snapshot of the samples of [Start, End)
+type Record struct {
+	Start  time.Time         `json:"start"`
+	End    time.Time         `json:"end"`
+	Labels map[string]string `json:"labels,omitempty"`
+	// the distinct values in ascending order and their amounts of samples
+	Values  []float64    `json:"values"`
+	Counts  []int64      `json:"counts"`
+	Invalid ValueTallies `json:"invalid"`
+}
+
+// matches is true when the record has all the labels
+func (r *Record) matches(labels map[string]string) bool {
+	for k, v := range labels {
+		if r.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Store keeps histogram snapshots with their intervals and labels in a
+// directory of append-only segment files, one JSON record per line, for
+// range queries such as the P99 between 14:00 and 14:05 yesterday
+type Store struct {
+	dir    string
+	config StoreConfig
+	mutex  sync.Mutex
+}
+
+// OpenStore opens the store in dir, creating the directory if needed
+func OpenStore(dir string, c StoreConfig) (*Store, error) {
+	if c.SegmentDuration == 0 {
+		c.SegmentDuration = time.Hour
+	}
+	if c.SegmentDuration < 0 || c.Retention < 0 || c.DownsampleAfter < 0 || c.DownsampleInterval < 0 {
+		return nil, fmt.Errorf("%w: negative durations in the store configuration", ErrInvalidOption)
+	}
+	if c.DownsampleInterval > 0 && c.SegmentDuration%c.DownsampleInterval != 0 {
+		return nil, fmt.Errorf("%w: downsample interval %v does not divide the segments of %v",
+			ErrInvalidOption, c.DownsampleInterval, c.SegmentDuration)
+	}
+	if c.DownsampleAfter > 0 && c.DownsampleInterval == 0 {
+		return nil, fmt.Errorf("%w: downsampling needs an interval", ErrInvalidOption)
+	}
+	if _, err := New(c.queryOptions()...); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir, config: c}, nil
+}
+
+func (c StoreConfig) queryOptions() []Option {
+	return append(append([]Option(nil), c.Options...), WithUnboundedWindow())
+}
+
+func (s *Store) now() time.Time {
+	if s.config.Clock != nil {
+		return s.config.Clock()
+	}
+	return time.Now()
+}
+
+func (s *Store) segmentPath(start time.Time) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%v", start.UnixNano(), segmentSuffix))
+}
+
+// Append stores the snapshot of the samples of [start, end) with the labels,
+// in the segment of start. It returns once the record is synced to disk,
+// along with the directory entry of a segment it creates, so a snapshot it
+// acknowledged survives a crash; a crash during Append tears at most the
+// record being appended
+func (s *Store) Append(snapshot *Snapshot, start time.Time, end time.Time, labels map[string]string) error {
+	if !start.Before(end) || end.Sub(start) > s.config.SegmentDuration {
+		return fmt.Errorf("%w: interval [%v, %v) is empty or longer than a segment", ErrInvalidValue, start, end)
+	}
+	r := Record{Start: start, End: end, Labels: labels, Invalid: snapshot.Invalid}
+	for v, count := range snapshot.All() {
+		r.Values = append(r.Values, v)
+		r.Counts = append(r.Counts, count)
+	}
+	line, err := json.Marshal(&r)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	path := s.segmentPath(start.Truncate(s.config.SegmentDuration))
+	_, err = os.Stat(path)
+	created := os.IsNotExist(err)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := truncateTornLine(f); err != nil {
+		f.Close()
+		return err
+	}
+	// a single write, so a crash tears at most the last line
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if created {
+		return syncDir(s.dir)
+	}
+	return nil
+}
+
+// truncateTornLine cuts a segment after its last newline, dropping the
+// record a crash tore so the next one is not appended to it
+func truncateTornLine(f *os.File) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	end := info.Size()
+	buf := make([]byte, 4096)
+	for offset := end; offset > 0; {
+		n := min(offset, int64(len(buf)))
+		offset -= n
+		if _, err := f.ReadAt(buf[:n], offset); err != nil {
+			return err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			if offset+int64(i)+1 == end {
+				return nil
+			}
+			return f.Truncate(offset + int64(i) + 1)
+		}
+	}
+	if end == 0 {
+		return nil
+	}
+	return f.Truncate(0)
+}
+
+// syncDir makes the files created, renamed or removed in dir durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := d.Sync(); err != nil {
+		d.Close()
+		return err
+	}
+	return d.Close()
+}
+
+// segments lists the starts of the segment files in ascending order
+func (s *Store) segments() ([]time.Time, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	starts := []time.Time{}
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), segmentSuffix)
+		if !ok || e.IsDir() {
+			continue
+		}
+		if n, err := strconv.ParseInt(name, 10, 64); err == nil {
+			starts = append(starts, time.Unix(0, n))
+		}
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+	return starts, nil
+}
+
+// readSegment returns the records of a segment file, skipping a torn last
+// line left by a crash during an append; a broken record anywhere else is
+// an error, since Append cuts a torn line before appending
+func readSegment(path string) ([]Record, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	records := []Record{}
+	lines := bytes.Split(data, []byte{'\n'})
+	for i, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+		var r Record
+		err := json.Unmarshal(line, &r)
+		if err == nil && len(r.Values) != len(r.Counts) {
+			err = fmt.Errorf("%v values and %v counts", len(r.Values), len(r.Counts))
+		}
+		if err != nil {
+			// only the last line can be without a newline
+			if i == len(lines)-1 {
+				break
+			}
+			return nil, fmt.Errorf("%v: record %v: %w", path, i+1, err)
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// Records returns the records overlapping [from, to) that have all the
+// labels, in order of segment and of append
+func (s *Store) Records(from time.Time, to time.Time, labels map[string]string) ([]Record, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	starts, err := s.segments()
+	if err != nil {
+		return nil, err
+	}
+	list := []Record{}
+	for _, start := range starts {
+		// no longer than a segment, a record ends before the next segment does
+		if !start.Before(to) || !start.Add(2*s.config.SegmentDuration).After(from) {
+			continue
+		}
+		records, err := readSegment(s.segmentPath(start))
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range records {
+			if r.Start.Before(to) && r.End.After(from) && r.matches(labels) {
+				list = append(list, r)
+			}
+		}
+	}
+	return list, nil
+}
+
+// Query merges the records overlapping [from, to) that have all the labels
+// into a histogram configured by StoreConfig.Options; the records are taken
+// whole, so the range widens to the intervals it touches
+func (s *Store) Query(from time.Time, to time.Time, labels map[string]string) (*Histogram, error) {
+	records, err := s.Records(from, to, labels)
+	if err != nil {
+		return nil, err
+	}
+	pairs := []ValueCount{}
+	tallies := ValueTallies{}
+	for _, r := range records {
+		for i, v := range r.Values {
+			pairs = append(pairs, ValueCount{Value: v, Count: int(r.Counts[i])})
+		}
+		tallies.add(r.Invalid)
+	}
+	h, err := New(s.config.queryOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.BulkLoad(mergeValueCounts(pairs)); err != nil {
+		return nil, err
+	}
+	h.tallies.add(tallies)
+	return h, nil
+}
+
+// Percentiles returns the values at the percentiles over [from, to)
+// of the records that have all the labels
+func (s *Store) Percentiles(from time.Time, to time.Time, labels map[string]string, ps ...float64) ([]float64, error) {
+	h, err := s.Query(from, to, labels)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]float64, len(ps))
+	for i, p := range ps {
+		// tracked, for the nearest rank semantics of the tracked percentiles
+		h.AddPercentilePoint(p)
+		values[i] = h.GetValueAtPercentile(p)
+	}
+	return values, nil
+}
+
+// Compact deletes the segments past the retention and downsamples the old
+// ones. A downsampled segment is written to a temporary file renamed over
+// it, so a crash leaves one version or the other
+func (s *Store) Compact() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := s.now()
+	starts, err := s.segments()
+	if err != nil {
+		return err
+	}
+	for _, start := range starts {
+		end := start.Add(s.config.SegmentDuration)
+		path := s.segmentPath(start)
+		if s.config.Retention > 0 && !end.After(now.Add(-s.config.Retention)) {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			continue
+		}
+		if s.config.DownsampleInterval > 0 && !end.After(now.Add(-s.config.DownsampleAfter)) {
+			if err := s.downsample(path); err != nil {
+				return err
+			}
+		}
+	}
+	return syncDir(s.dir)
+}
+
+// labelsKey is the same for equal label sets
+func labelsKey(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k, v := range labels {
+		keys = append(keys, k+"="+v)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, "\x00")
+}
+
+// downsample merges the records of a segment by label set into aligned
+// intervals, leaving the file alone when they already are
+func (s *Store) downsample(path string) error {
+	records, err := readSegment(path)
+	if err != nil {
+		return err
+	}
+	interval := s.config.DownsampleInterval
+	merged := []Record{}
+	pairs := [][]ValueCount{}
+	index := map[string]int{}
+	changed := false
+	for _, r := range records {
+		start := r.Start.Truncate(interval)
+		end := start.Add(interval)
+		if r.End.After(end) {
+			end = r.End
+		}
+		key := fmt.Sprintf("%d\x01%v", start.UnixNano(), labelsKey(r.Labels))
+		i, ok := index[key]
+		if !ok {
+			i = len(merged)
+			index[key] = i
+			merged = append(merged, Record{Start: start, End: end, Labels: r.Labels})
+			pairs = append(pairs, nil)
+		}
+		changed = changed || ok || !r.Start.Equal(start) || !r.End.Equal(end)
+		if r.End.After(merged[i].End) {
+			merged[i].End = r.End
+		}
+		merged[i].Invalid.add(r.Invalid)
+		for j, v := range r.Values {
+			pairs[i] = append(pairs[i], ValueCount{Value: v, Count: int(r.Counts[j])})
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	for i := range merged {
+		for _, p := range mergeValueCounts(pairs[i]) {
+			merged[i].Values = append(merged[i].Values, p.Value)
+			merged[i].Counts = append(merged[i].Counts, int64(p.Count))
+		}
+		line, err := json.Marshal(&merged[i])
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
@@ -0,0 +1,137 @@
+package histogram
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+)
+
+// fillStore appends an hour of 10s intervals from base for two services,
+// the samples of interval i of service a are 1000+i, those of b are i
+func fillStore(t *testing.T, store *Store, base time.Time) {
+	for i := 0; i < 360; i++ {
+		start := base.Add(time.Duration(i) * 10 * time.Second)
+		a, b := NewHistogram(0, 10, 0), NewHistogram(0, 10, 0)
+		a.Enqueue(float64(1000+i), 2)
+		b.Enqueue(float64(i), 1)
+		assert.Nil(t, store.Append(a.Snapshot(), start, start.Add(10*time.Second), map[string]string{"service": "a"}), "append")
+		assert.Nil(t, store.Append(b.Snapshot(), start, start.Add(10*time.Second), map[string]string{"service": "b"}), "append")
+	}
+}
+
+func TestStore_RangeQuery(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenStore(dir, StoreConfig{SegmentDuration: 15 * time.Minute})
+	assert.Nil(t, err, "open")
+	base := time.Date(2024, 5, 1, 14, 0, 0, 0, time.UTC)
+	fillStore(t, store, base)
+	files, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+	assert.Equal(t, 4, len(files), "an hour in segments of 15 minutes")
+
+	// 14:00 to 14:05 is intervals 0 to 29
+	from, to := base, base.Add(5*time.Minute)
+	h, err := store.Query(from, to, map[string]string{"service": "b"})
+	assert.Nil(t, err, "query")
+	assert.Nil(t, h.Verify(), "verify")
+	assert.Equal(t, int64(30), h.Count, "thirty intervals")
+	assert.Equal(t, float64(0), h.GetStatistics().Min, "min")
+	assert.Equal(t, float64(29), h.GetStatistics().Max, "max")
+
+	ps, err := store.Percentiles(from, to, map[string]string{"service": "a"}, 0.5, 0.99)
+	assert.Nil(t, err, "percentiles")
+	assert.Equal(t, []float64{1014, 1028}, ps, "of service a")
+
+	h, _ = store.Query(from, to, nil)
+	assert.Equal(t, int64(90), h.Count, "both services")
+	// the range widens to the intervals it touches
+	h, _ = store.Query(base.Add(14*time.Minute+55*time.Second), base.Add(15*time.Minute+1*time.Second), map[string]string{"service": "b"})
+	assert.Equal(t, int64(2), h.Count, "across segments")
+
+	// reopened, the store reads what is on disk
+	store, _ = OpenStore(dir, StoreConfig{SegmentDuration: 15 * time.Minute})
+	h, _ = store.Query(base, base.Add(time.Hour), map[string]string{"service": "a"})
+	assert.Equal(t, int64(720), h.Count, "the whole hour")
+}
+
+func TestStore_TornAppend(t *testing.T) {
+	dir := t.TempDir()
+	store, _ := OpenStore(dir, StoreConfig{})
+	base := time.Date(2024, 5, 1, 14, 0, 0, 0, time.UTC)
+	h := NewHistogram(0, 10, 0)
+	h.Enqueue(5, 3)
+	assert.Nil(t, store.Append(h.Snapshot(), base, base.Add(time.Minute), nil), "append")
+
+	path := store.segmentPath(base)
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	f.Write([]byte(`{"start":"2024-05-01T14:01:00Z","end":`))
+	f.Close()
+	h, err := store.Query(base, base.Add(time.Hour), nil)
+	assert.Nil(t, err, "a torn last line is skipped")
+	assert.Equal(t, int64(3), h.Count, "the complete record")
+
+	// the next append cuts the torn line instead of continuing it
+	h.Enqueue(7, 2)
+	assert.Nil(t, store.Append(h.Snapshot(), base.Add(time.Minute), base.Add(2*time.Minute), nil), "append")
+	h, err = store.Query(base, base.Add(time.Hour), nil)
+	assert.Nil(t, err, "readable after the append")
+	assert.Equal(t, int64(8), h.Count, "both complete records")
+	records, _ := store.Records(base, base.Add(time.Hour), nil)
+	assert.Equal(t, 2, len(records), "the torn line is gone")
+
+	// a broken record followed by others is an error
+	os.WriteFile(path, []byte("{\n{}\n"), 0o644)
+	_, err = store.Query(base, base.Add(time.Hour), nil)
+	assert.NotNil(t, err, "a broken record")
+
+	err = store.Append(h.Snapshot(), base, base.Add(2*time.Hour), nil)
+	assert.True(t, errors.Is(err, ErrInvalidValue), "longer than a segment")
+}
+
+func TestStore_Compact(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Date(2024, 5, 1, 14, 0, 0, 0, time.UTC)
+	now := base.Add(2 * time.Hour)
+	store, err := OpenStore(dir, StoreConfig{
+		SegmentDuration:    15 * time.Minute,
+		Retention:          100 * time.Minute,
+		DownsampleAfter:    time.Hour,
+		DownsampleInterval: time.Minute,
+		Clock:              func() time.Time { return now },
+	})
+	assert.Nil(t, err, "open")
+	fillStore(t, store, base)
+	before, _ := store.Percentiles(base.Add(30*time.Minute), base.Add(45*time.Minute), map[string]string{"service": "a"}, 0.5, 0.9)
+
+	// 14:00 to 14:15 is past the retention, 14:15 to 15:00 is downsampled
+	assert.Nil(t, store.Compact(), "compact")
+	files, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+	assert.Equal(t, 3, len(files), "a segment is deleted")
+	h, _ := store.Query(base, base.Add(15*time.Minute), nil)
+	assert.Equal(t, int64(0), h.Count, "deleted")
+
+	records, _ := store.Records(base.Add(30*time.Minute), base.Add(45*time.Minute), map[string]string{"service": "a"})
+	assert.Equal(t, 15, len(records), "one record per minute")
+	assert.Equal(t, time.Minute, records[0].End.Sub(records[0].Start), "a minute")
+	assert.Equal(t, []int64{2, 2, 2, 2, 2, 2}, records[0].Counts, "six intervals")
+	after, _ := store.Percentiles(base.Add(30*time.Minute), base.Add(45*time.Minute), map[string]string{"service": "a"}, 0.5, 0.9)
+	assert.Equal(t, before, after, "the same percentiles over aligned ranges")
+
+	// compacting again changes nothing
+	info, _ := os.Stat(files[0])
+	assert.Nil(t, store.Compact(), "compact")
+	again, _ := os.Stat(files[0])
+	assert.Equal(t, info.ModTime(), again.ModTime(), "not rewritten")
+}
+
+func TestStore_Config(t *testing.T) {
+	dir := t.TempDir()
+	_, err := OpenStore(dir, StoreConfig{SegmentDuration: time.Hour, DownsampleInterval: 7 * time.Minute})
+	assert.True(t, errors.Is(err, ErrInvalidOption), "does not divide")
+	_, err = OpenStore(dir, StoreConfig{DownsampleAfter: time.Hour})
+	assert.True(t, errors.Is(err, ErrInvalidOption), "no interval")
+	_, err = OpenStore(dir, StoreConfig{Options: []Option{WithWindowSize(10)}})
+	assert.True(t, errors.Is(err, ErrInvalidOption), "a window")
+}
@@ -0,0 +1,91 @@
+package histogram
+
+import (
+	"sort"
+	"strconv"
+)
+
+// ThresholdItem is the inverse of a PercentileItem: the value is fixed
+// and Count, the amount of samples no larger than it, is maintained
+// by Enqueue and Dequeue in O(1) per threshold
+type ThresholdItem struct {
+	Value float64
+	Key   string
+	Count int64
+	// Count over the samples in the window, filled in by the read methods
+	Fraction float64
+}
+
+func ThresholdKey(v float64) string {
+	return strconv.FormatFloat(v, 'E', -1, 64)
+}
+
+func NewThresholdItem(v float64) *ThresholdItem {
+	return &ThresholdItem{
+		Value: v,
+		Key:   ThresholdKey(v),
+	}
+}
+
+// AddThresholdPoint tracks the fraction of samples no larger than v,
+// counting what is already in the window in O(log n)
+func (h *Histogram) AddThresholdPoint(v float64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.addThresholdPoint(v)
+}
+
+func (h *Histogram) addThresholdPoint(v float64) {
+	if h.Thresholds == nil {
+		h.Thresholds = make(map[string]*ThresholdItem)
+	}
+	key := ThresholdKey(v)
+	if _, ok := h.Thresholds[key]; ok {
+		return
+	}
+	item := NewThresholdItem(v)
+	item.Count = h.tree.rank(v)
+	h.Thresholds[key] = item
+}
+
+func (h *Histogram) RemoveThresholdPoint(v float64) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	key := ThresholdKey(v)
+	if _, ok := h.Thresholds[key]; !ok {
+		return false
+	}
+	delete(h.Thresholds, key)
+	return true
+}
+
+// GetFractionBelow returns the fraction of samples no larger than v,
+// in O(1) if v is a tracked threshold and in O(log n) from the tree otherwise
+func (h *Histogram) GetFractionBelow(v float64) float64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.Count <= 0 {
+		return 0
+	}
+	if t, ok := h.Thresholds[ThresholdKey(v)]; ok {
+		return float64(t.Count) / float64(h.Count)
+	}
+	return float64(h.tree.rank(v)) / float64(h.Count)
+}
+
+// GetThresholdPoints returns a copy of every tracked threshold in ascending
+// order of value, all taken at the same point of the window
+func (h *Histogram) GetThresholdPoints() []ThresholdItem {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	list := make([]ThresholdItem, 0, len(h.Thresholds))
+	for _, t := range h.Thresholds {
+		item := *t
+		if h.Count > 0 {
+			item.Fraction = float64(t.Count) / float64(h.Count)
+		}
+		list = append(list, item)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Value < list[j].Value })
+	return list
+}
@@ -0,0 +1,63 @@
+package histogram
+
+import (
+	"math/rand"
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThresholdPoints_SlidingWindow(t *testing.T) {
+	rng := rand.New(rand.NewSource(33))
+	histogram := NewHistogram(1000, float64(10), 0)
+	thresholds := []float64{100, 250, 250.5, 400}
+	histogram.AddThresholdPoint(250)
+
+	window := []float64{}
+	for i := 0; i < 5000; i++ {
+		v := float64(rng.Intn(500))
+		count := 1 + rng.Intn(2)
+		histogram.Enqueue(v, count)
+		for c := 0; c < count; c++ {
+			window = append(window, v)
+		}
+		if len(window) > 1000 {
+			window = window[len(window)-1000:]
+		}
+		if i == 2000 {
+			// added to a populated window
+			for _, x := range thresholds {
+				histogram.AddThresholdPoint(x)
+			}
+		}
+		if i%10 == 0 && histogram.Count > 1 {
+			histogram.Dequeue()
+			window = window[1:]
+		}
+		if i < 2000 {
+			continue
+		}
+
+		points := histogram.GetThresholdPoints()
+		assert.Equal(t, len(thresholds), len(points), "every threshold should be listed once")
+		for j, x := range thresholds {
+			below := 0
+			for _, v := range window {
+				if v <= x {
+					below++
+				}
+			}
+			assert.Equal(t, x, points[j].Value, "thresholds should be in ascending order")
+			assert.Equal(t, int64(below), points[j].Count, "count below %v should match the window", x)
+			assert.Equal(t, float64(below)/float64(len(window)), points[j].Fraction, "fraction below %v", x)
+			assert.Equal(t, points[j].Fraction, histogram.GetFractionBelow(x), "O(1) read should match the snapshot")
+		}
+		if t.Failed() {
+			break
+		}
+	}
+
+	assert.Equal(t, histogram.GetPercentileForValue(300), histogram.GetFractionBelow(300), "untracked values fall back to the tree")
+	assert.True(t, histogram.RemoveThresholdPoint(100), "tracked threshold should be removed")
+	assert.False(t, histogram.RemoveThresholdPoint(100), "untracked threshold cannot be removed")
+	assert.Equal(t, 3, len(histogram.GetThresholdPoints()), "removed threshold should not be listed")
+}
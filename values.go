@@ -0,0 +1,115 @@
+package histogram
+
+import (
+	"fmt"
+	"math"
+)
+
+// ValuePolicy decides what happens to NaN, ±Inf and the samples outside
+// of the range set by Config.MinValue and Config.MaxValue; they never reach
+// the tree, where NaN breaks every comparison of the descent and Inf the
+// index math of the buckets
+type ValuePolicy int
+
+const (
+	// drop them and count them in the tallies
+	CountInvalidValues ValuePolicy = iota
+	// drop them, count them and return ErrInvalidValue from EnqueueE
+	RejectInvalidValues
+	// replace the values out of the range by the nearest bound and count them,
+	// NaN is still dropped as is ±Inf without a range
+	ClampInvalidValues
+)
+
+func (p ValuePolicy) String() string {
+	switch p {
+	case CountInvalidValues:
+		return "count"
+	case RejectInvalidValues:
+		return "reject"
+	case ClampInvalidValues:
+		return "clamp"
+	}
+	return fmt.Sprintf("ValuePolicy(%d)", int(p))
+}
+
+// ValueTallies counts the invalid samples since the histogram was created
+// or reset, these are not part of the sliding window
+type ValueTallies struct {
+	NaN int64 `json:"nan"`
+	// below MinValue or -Inf
+	Underflow int64 `json:"underflow"`
+	// above MaxValue or +Inf
+	Overflow int64 `json:"overflow"`
+	// the part of Underflow and Overflow kept at the bounds by ClampInvalidValues
+	Clamped int64 `json:"clamped"`
+}
+
+func (t *ValueTallies) add(o ValueTallies) {
+	t.NaN += o.NaN
+	t.Underflow += o.Underflow
+	t.Overflow += o.Overflow
+	t.Clamped += o.Clamped
+}
+
+// Total is the amount of invalid samples, clamped or not
+func (t ValueTallies) Total() int64 {
+	return t.NaN + t.Underflow + t.Overflow
+}
+
+func (c Config) hasValueRange() bool {
+	return c.MinValue != 0 || c.MaxValue != 0
+}
+
+// valueRange returns the bounds of the valid values, the finite ones without a range
+func (c Config) valueRange() (float64, float64) {
+	if c.hasValueRange() {
+		return c.MinValue, c.MaxValue
+	}
+	return -math.MaxFloat64, math.MaxFloat64
+}
+
+// admit applies the value policy to a raw value, counting count samples
+// in the tallies when it is invalid, and returns it unified; ok is false
+// when it has to be dropped. A count below 1 is refused with ErrInvalidValue
+// whatever the policy
+func (h *Histogram) admit(v float64, count int) (float64, bool, error) {
+	if count < 1 {
+		return v, false, fmt.Errorf("%w: count %v of %v", ErrInvalidValue, count, v)
+	}
+	lower, upper := h.config.valueRange()
+	n := int64(count)
+	var err error
+	switch {
+	case math.IsNaN(v):
+		h.tallies.NaN += n
+		err = fmt.Errorf("%w: NaN", ErrInvalidValue)
+	case v < lower:
+		h.tallies.Underflow += n
+		if h.config.ValuePolicy == ClampInvalidValues && h.config.hasValueRange() && !math.IsInf(lower, -1) {
+			h.tallies.Clamped += n
+			return h.UnifiedValue(lower), true, nil
+		}
+		err = fmt.Errorf("%w: %v is below %v", ErrInvalidValue, v, lower)
+	case v > upper:
+		h.tallies.Overflow += n
+		if h.config.ValuePolicy == ClampInvalidValues && h.config.hasValueRange() && !math.IsInf(upper, 1) {
+			h.tallies.Clamped += n
+			return h.UnifiedValue(upper), true, nil
+		}
+		err = fmt.Errorf("%w: %v is above %v", ErrInvalidValue, v, upper)
+	default:
+		return h.UnifiedValue(v), true, nil
+	}
+	if h.config.ValuePolicy != RejectInvalidValues {
+		err = nil
+	}
+	return v, false, err
+}
+
+// GetValueTallies returns the counts of the invalid samples
+func (h *Histogram) GetValueTallies() ValueTallies {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.tallies
+}
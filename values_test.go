@@ -0,0 +1,119 @@
+package histogram
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValues_InvalidValuesAreCounted(t *testing.T) {
+	histogram := NewHistogram(100, 1, 1)
+	for i := 1; i <= 10; i++ {
+		histogram.Enqueue(float64(i), 1)
+	}
+	assert.Nil(t, histogram.Enqueue(math.NaN(), 2), "NaN is dropped")
+	assert.Nil(t, histogram.Enqueue(math.Inf(1), 1), "+Inf is dropped")
+	assert.Nil(t, histogram.Enqueue(math.Inf(-1), 1), "-Inf is dropped")
+	item, err := histogram.EnqueueE(math.NaN(), 1)
+	assert.Nil(t, item, "NaN is dropped")
+	assert.Nil(t, err, "counted, not rejected")
+
+	assert.Equal(t, int64(10), histogram.Count, "the window only holds the valid samples")
+	assert.Equal(t, float64(10), histogram.GetStatistics().Max, "maximum")
+	assert.Equal(t, float64(1), histogram.GetStatistics().Min, "minimum")
+	assert.Equal(t, float64(5), histogram.GetValueAtPercentile(0.5), "median")
+	assert.Equal(t, ValueTallies{NaN: 3, Underflow: 1, Overflow: 1}, histogram.GetValueTallies(), "tallies")
+	assert.Equal(t, int64(5), histogram.GetValueTallies().Total(), "total")
+	assert.Equal(t, histogram.GetValueTallies(), histogram.GetStatistics().Invalid, "tallies in the statistics")
+
+	histogram.Reset()
+	assert.Equal(t, ValueTallies{}, histogram.GetValueTallies(), "zeroed by Reset")
+	histogram.Enqueue(math.NaN(), 1)
+	assert.Equal(t, int64(1), histogram.GetStatistics().Invalid.NaN, "tallies of an empty window")
+}
+
+func TestValues_RejectInvalidValues(t *testing.T) {
+	histogram, err := New(WithWindowSize(100), WithValuePolicy(RejectInvalidValues), WithValueRange(0, 100))
+	assert.Nil(t, err, "valid options")
+
+	_, err = histogram.EnqueueE(math.NaN(), 1)
+	assert.True(t, errors.Is(err, ErrInvalidValue), "NaN")
+	_, err = histogram.EnqueueE(-1, 1)
+	assert.True(t, errors.Is(err, ErrInvalidValue), "below the range")
+	_, err = histogram.EnqueueE(math.Inf(1), 1)
+	assert.True(t, errors.Is(err, ErrInvalidValue), "above the range")
+	_, err = histogram.EnqueueE(100, 1)
+	assert.Nil(t, err, "the bounds are valid")
+
+	assert.Equal(t, int64(1), histogram.Count, "only the valid sample")
+	assert.Equal(t, ValueTallies{NaN: 1, Underflow: 1, Overflow: 1}, histogram.GetValueTallies(), "tallies")
+}
+
+func TestValues_ClampInvalidValues(t *testing.T) {
+	histogram, err := New(WithWindowSize(100), WithValuePolicy(ClampInvalidValues), WithValueRange(0, 50))
+	assert.Nil(t, err, "valid options")
+
+	histogram.Enqueue(-3, 2)
+	histogram.Enqueue(10, 1)
+	histogram.Enqueue(70, 1)
+	histogram.Enqueue(math.Inf(1), 1)
+	histogram.Enqueue(math.NaN(), 1)
+	assert.Equal(t, int64(5), histogram.Count, "clamped samples are kept")
+	assert.Equal(t, float64(0), histogram.GetStatistics().Min, "clamped to the lower bound")
+	assert.Equal(t, float64(50), histogram.GetStatistics().Max, "clamped to the upper bound")
+	assert.Equal(t, int64(2), histogram.tree.nodes[histogram.tree.max].duplications, "both above the range")
+	assert.Equal(t, ValueTallies{NaN: 1, Underflow: 2, Overflow: 2, Clamped: 4}, histogram.GetValueTallies(), "tallies")
+
+	// without a range only ±Inf and NaN are invalid, and they are dropped
+	histogram, _ = New(WithWindowSize(100), WithValuePolicy(ClampInvalidValues))
+	histogram.Enqueue(math.Inf(-1), 1)
+	histogram.Enqueue(-1e300, 1)
+	assert.Equal(t, int64(1), histogram.Count, "-Inf is dropped")
+	assert.Equal(t, ValueTallies{Underflow: 1}, histogram.GetValueTallies(), "nothing to clamp to")
+
+	for _, opt := range []Option{WithValueRange(1, 1), WithValueRange(math.NaN(), 1), WithValuePolicy(ValuePolicy(7))} {
+		_, err = New(WithUnboundedWindow(), opt)
+		assert.True(t, errors.Is(err, ErrInvalidOption), "invalid value options")
+	}
+}
+
+func TestValues_AdmittedBeforeUnified(t *testing.T) {
+	// finite values whose rounding would overflow stay valid
+	histogram, _ := New(WithWindowSize(100), WithAccuracy(3), WithValuePolicy(RejectInvalidValues))
+	_, err := histogram.EnqueueE(1e307, 1)
+	assert.Nil(t, err, "a huge value at the accuracy")
+	_, err = histogram.EnqueueE(-math.MaxFloat64, 1)
+	assert.Nil(t, err, "the smallest float")
+	histogram, _ = New(WithWindowSize(100), WithSignificantDigits(2), WithValuePolicy(RejectInvalidValues))
+	_, err = histogram.EnqueueE(math.MaxFloat64, 1)
+	assert.Nil(t, err, "the largest float at two digits")
+	assert.Equal(t, 1.7e308, histogram.GetStatistics().Max, "rounded down instead of up to +Inf")
+	assert.Equal(t, ValueTallies{}, histogram.GetValueTallies(), "nothing invalid")
+
+	// the range applies to the value as given, not as rounded into it
+	histogram, _ = New(WithWindowSize(100), WithAccuracy(0), WithValuePolicy(ClampInvalidValues), WithValueRange(0, 50))
+	histogram.Enqueue(50.4, 1)
+	histogram.Enqueue(-0.3, 1)
+	assert.Equal(t, ValueTallies{Underflow: 1, Overflow: 1, Clamped: 2}, histogram.GetValueTallies(), "out of the range before rounding")
+	assert.Equal(t, float64(50), histogram.GetStatistics().Max, "clamped to the upper bound")
+	assert.Equal(t, float64(0), histogram.GetStatistics().Min, "clamped to the lower bound")
+}
+
+func TestValues_ReconfigureValueRange(t *testing.T) {
+	histogram := NewHistogram(100, 1, 1)
+	histogram.Enqueue(math.NaN(), 1)
+	for i := 1; i <= 20; i++ {
+		histogram.Enqueue(float64(i), 1)
+	}
+	c := histogram.GetConfig()
+	c.MinValue, c.MaxValue = 5, 15
+	assert.Nil(t, histogram.Reconfigure(c), "valid range")
+	assert.Equal(t, int64(11), histogram.Count, "the samples out of the range are dropped")
+	assert.Equal(t, float64(5), histogram.GetStatistics().Min, "minimum")
+	assert.Equal(t, float64(15), histogram.GetStatistics().Max, "maximum")
+	assert.Equal(t, ValueTallies{NaN: 1, Underflow: 4, Overflow: 5}, histogram.GetValueTallies(), "the tallies carry over")
+
+	c.MinValue, c.MaxValue = 15, 5
+	assert.True(t, errors.Is(histogram.Reconfigure(c), ErrInvalidOption), "inverted range")
+}
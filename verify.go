@@ -0,0 +1,212 @@
+package histogram
+
+import (
+	"fmt"
+)
+
+// Verify checks the invariants of the histogram: the AVL balance, heights,
+// subtree counts, BST order and parent links of the tree, the sorted list
+// of smaller and larger links with the minimum and maximum at its ends, the
+// values counted by the buckets both ways, the totals of the queue and the counts of the tracked
+// percentiles and thresholds; the first violation is returned wrapping
+// ErrCorruptTree, after which Rebuild restores the histogram from the queue
+func (h *Histogram) Verify() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.verify()
+}
+
+func (h *Histogram) verify() error {
+	t := &h.tree
+	if int64(len(h.queue)) != h.Count {
+		return corruption("the queue holds %v samples, Count is %v", len(h.queue), h.Count)
+	}
+	if h.config.TimeWindow > 0 && len(h.timestamps) != len(h.queue) {
+		return corruption("%v timestamps for %v samples", len(h.timestamps), len(h.queue))
+	}
+	if len(t.nodes) > 0 && t.nodes[0] != (node{}) {
+		return corruption("the node standing for no node is linked")
+	}
+	if t.root == 0 {
+		if h.Count != 0 || t.min != 0 || t.max != 0 {
+			return corruption("an empty tree with %v samples", h.Count)
+		}
+		for _, p := range h.Percentiles {
+			if p.node != 0 || p.Count != 0 {
+				return corruption("percentile %v is set on an empty tree", p.Percentile)
+			}
+		}
+		return nil
+	}
+	if !t.inArena(t.root) {
+		return corruption("the root %v is out of the arena", t.root)
+	}
+	if t.nodes[t.root].parent != 0 {
+		return corruption("the root %v has a parent", t.nodes[t.root].value)
+	}
+
+	// in order, so the nodes are checked against the sorted list as well
+	nodes := make([]int32, 0, h.Count)
+	visited := make([]bool, len(t.nodes))
+	if err := h.verifyNode(t.root, 0, 0, &nodes, visited); err != nil {
+		return err
+	}
+	if t.total() != h.Count {
+		return corruption("the tree holds %v samples, Count is %v", t.total(), h.Count)
+	}
+
+	if t.min != nodes[0] || t.nodes[t.min].smaller != 0 {
+		return corruption("the minimum is not the smallest value %v", t.nodes[nodes[0]].value)
+	}
+	if last := nodes[len(nodes)-1]; t.max != last || t.nodes[t.max].larger != 0 {
+		return corruption("the maximum is not the largest value %v", t.nodes[last].value)
+	}
+	for i, n := range nodes[1:] {
+		if t.nodes[n].smaller != nodes[i] || t.nodes[nodes[i]].larger != n {
+			return corruption("%v and %v are not linked in order", t.nodes[nodes[i]].value, t.nodes[n].value)
+		}
+	}
+
+	queued := make(map[float64]int64, len(nodes))
+	for _, v := range h.queue {
+		queued[v]++
+	}
+	for _, n := range nodes {
+		x := &t.nodes[n]
+		if queued[x.value] != x.duplications {
+			return corruption("%v is queued %v times, duplicated %v times", x.value, queued[x.value], x.duplications)
+		}
+		delete(queued, x.value)
+	}
+	for v := range queued {
+		return corruption("the queued sample %v is not in the tree", v)
+	}
+
+	// the distinct values of the tree falling into each bucket
+	bucketed := make(map[[2]int64]int64)
+	for _, n := range nodes {
+		if idx, b := h.BucketHistogram.position(t.nodes[n].value); idx >= 0 && b >= 0 {
+			bucketed[[2]int64{idx, b}]++
+		}
+	}
+	for idx, sbh := range h.BucketHistogram.All() {
+		for b, count := range sbh.All() {
+			if c := bucketed[[2]int64{idx, b}]; count != c {
+				return corruption("bucket %v of sub histogram %v counts %v values, the tree %v", b, idx, count, c)
+			}
+		}
+	}
+	// and every value of the tree is reachable through its bucket
+	for _, n := range nodes {
+		v := t.nodes[n].value
+		idx, b := h.BucketHistogram.position(v)
+		if idx < 0 || b < 0 {
+			continue
+		}
+		sbh := h.BucketHistogram.GetSubHistogram(idx)
+		if sbh == nil {
+			return corruption("%v has no sub histogram %v", v, idx)
+		}
+		if count := sbh.GetBucket(b); count != bucketed[[2]int64{idx, b}] {
+			return corruption("%v is not counted in bucket %v of sub histogram %v", v, b, idx)
+		}
+	}
+
+	for _, p := range h.Percentiles {
+		if !t.inArena(p.node) || !visited[p.node] {
+			return corruption("percentile %v points out of the tree", p.Percentile)
+		}
+		if p.Value != t.nodes[p.node].value {
+			return corruption("percentile %v is %v, its node %v", p.Percentile, p.Value, t.nodes[p.node].value)
+		}
+		if c := t.cumulative(p.node); p.Count != c {
+			return corruption("percentile %v counts %v samples up to %v, the tree %v", p.Percentile, p.Count, p.Value, c)
+		}
+	}
+	for _, th := range h.Thresholds {
+		if c := t.rank(th.Value); th.Count != c {
+			return corruption("threshold %v counts %v samples, the tree %v", th.Value, th.Count, c)
+		}
+	}
+	return nil
+}
+
+// verifyNode checks the subtree of node i, whose values are within the
+// values of the nodes lower and upper, 0 for no bound, and appends its
+// nodes in order
+func (h *Histogram) verifyNode(i int32, lower int32, upper int32, nodes *[]int32, visited []bool) error {
+	t := &h.tree
+	if visited[i] {
+		return corruption("the tree has a cycle at %v", t.nodes[i].value)
+	}
+	visited[i] = true
+	n := &t.nodes[i]
+	if (lower != 0 && n.value <= t.nodes[lower].value) || (upper != 0 && n.value >= t.nodes[upper].value) {
+		return corruption("%v is out of order", n.value)
+	}
+	if n.duplications <= 0 {
+		return corruption("%v is duplicated %v times", n.value, n.duplications)
+	}
+
+	count, leftHeight, rightHeight := n.duplications, int32(0), int32(0)
+	if n.left != 0 {
+		if !t.inArena(n.left) {
+			return corruption("the left child of %v is out of the arena", n.value)
+		}
+		if t.nodes[n.left].parent != i {
+			return corruption("the left child %v of %v has another parent", t.nodes[n.left].value, n.value)
+		}
+		if err := h.verifyNode(n.left, lower, i, nodes, visited); err != nil {
+			return err
+		}
+		count += t.nodes[n.left].count
+		leftHeight = t.nodes[n.left].height
+	}
+	*nodes = append(*nodes, i)
+	if n.right != 0 {
+		if !t.inArena(n.right) {
+			return corruption("the right child of %v is out of the arena", n.value)
+		}
+		if t.nodes[n.right].parent != i {
+			return corruption("the right child %v of %v has another parent", t.nodes[n.right].value, n.value)
+		}
+		if err := h.verifyNode(n.right, i, upper, nodes, visited); err != nil {
+			return err
+		}
+		count += t.nodes[n.right].count
+		rightHeight = t.nodes[n.right].height
+	}
+
+	if n.count != count {
+		return corruption("%v counts %v samples in its subtree, %v are there", n.value, n.count, count)
+	}
+	if n.height != max(leftHeight, rightHeight)+1 {
+		return corruption("%v is %v high, its subtrees %v and %v", n.value, n.height, leftHeight, rightHeight)
+	}
+	if leftHeight-rightHeight > 1 || rightHeight-leftHeight > 1 {
+		return corruption("%v is unbalanced, its subtrees are %v and %v high", n.value, leftHeight, rightHeight)
+	}
+	return nil
+}
+
+func corruption(format string, args ...any) error {
+	return fmt.Errorf("%w: "+format, append([]any{ErrCorruptTree}, args...)...)
+}
+
+// Rebuild reconstructs the tree, the buckets and the tracked percentiles
+// and thresholds from the queue, which keeps the values in FIFO order
+// however broken the links of the tree are
+func (h *Histogram) Rebuild() {
+	h.mutex.Lock()
+	h.rebuild(h.config)
+	for _, slo := range h.SLOs {
+		slo.evaluate(h)
+	}
+	h.notifyWatchers()
+	callbacks := h.takeCallbacks()
+	h.mutex.Unlock()
+
+	for _, f := range callbacks {
+		f()
+	}
+}
@@ -0,0 +1,80 @@
+package histogram
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerify_SlidingWindow(t *testing.T) {
+	rng := rand.New(rand.NewSource(41))
+	configs := map[string][]Option{
+		"linear":      {WithWindowSize(50), WithAccuracy(1)},
+		"significant": {WithWindowSize(200), WithSignificantDigits(2)},
+		"log-linear":  {WithWindowSize(500), WithAccuracy(3), WithBucketLayout(NewLogLinearLayout(5))},
+	}
+	for name, opts := range configs {
+		histogram, err := New(append(opts, WithPercentiles(0.01, 0.5, 0.9, 0.99), WithThresholds(5, 50))...)
+		assert.Nil(t, err, name)
+		assert.Nil(t, histogram.Verify(), "%v: empty", name)
+		for i := 0; i < 5000; i++ {
+			// heavy on duplicates so that deleted nodes are replaced from both sides
+			histogram.Enqueue(float64(rng.Intn(1000))/10, 1+rng.Intn(3))
+			if rng.Intn(4) == 0 {
+				histogram.Dequeue()
+			}
+			if err := histogram.Verify(); err != nil {
+				t.Fatalf("%v: after %v operations: %v", name, i, err)
+			}
+		}
+		for histogram.Dequeue() != nil {
+		}
+		assert.Nil(t, histogram.Verify(), "%v: emptied", name)
+	}
+}
+
+func TestVerify_Rebuild(t *testing.T) {
+	corruptions := map[string]func(h *Histogram){
+		"count":      func(h *Histogram) { h.tree.nodes[h.tree.nodes[h.tree.root].left].count++ },
+		"height":     func(h *Histogram) { h.tree.nodes[h.tree.root].height++ },
+		"parent":     func(h *Histogram) { h.tree.nodes[h.tree.nodes[h.tree.root].right].parent = h.tree.nodes[h.tree.root].left },
+		"order":      func(h *Histogram) { h.tree.nodes[h.tree.nodes[h.tree.root].left].value = h.tree.nodes[h.tree.root].value + 1 },
+		"list":       func(h *Histogram) { h.tree.nodes[h.tree.min].larger = h.tree.max },
+		"min":        func(h *Histogram) { h.tree.min = h.tree.nodes[h.tree.min].larger },
+		"arena":      func(h *Histogram) { h.tree.nodes[h.tree.root].left = int32(len(h.tree.nodes)) },
+		"queue":      func(h *Histogram) { h.queue = h.queue[1:] },
+		"bucket":     func(h *Histogram) { h.BucketHistogram.GetSubHistogram(0).buckets.set(1, 2) },
+		"unbucketed": func(h *Histogram) { h.BucketHistogram.GetSubHistogram(0).buckets.set(1, 0) },
+		"no sub histogram": func(h *Histogram) {
+			idx, _ := h.BucketHistogram.position(30)
+			h.BucketHistogram.subHistograms.set(idx, nil)
+		},
+		"percentile": func(h *Histogram) { h.GetPercentileItem(0.5).Count-- },
+		"threshold":  func(h *Histogram) { h.Thresholds[ThresholdKey(30)].Count++ },
+		"unbalanced": func(h *Histogram) {
+			// detach the left subtree, fixing the counts and heights above
+			h.tree.nodes[h.tree.root].left = 0
+			h.tree.update(h.tree.root)
+		},
+	}
+	for name, corrupt := range corruptions {
+		histogram, _ := New(WithWindowSize(100), WithPercentiles(0.5), WithThresholds(30))
+		for i := 0; i < 150; i++ {
+			histogram.Enqueue(float64(i%60), 1)
+		}
+		assert.Nil(t, histogram.Verify(), name)
+		median := histogram.GetValueAtPercentile(0.5)
+		fraction := histogram.GetFractionBelow(30)
+
+		corrupt(histogram)
+		assert.True(t, errors.Is(histogram.Verify(), ErrCorruptTree), name)
+
+		histogram.Rebuild()
+		assert.Nil(t, histogram.Verify(), "%v: rebuilt", name)
+		if name != "queue" {
+			assert.Equal(t, median, histogram.GetValueAtPercentile(0.5), "%v: median", name)
+			assert.Equal(t, fraction, histogram.GetFractionBelow(30), "%v: threshold", name)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package histogram
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// capacity of the channel of a watcher when WatchOptions.Buffer is not set
+const defaultWatchBuffer = 64
+
+type WatchOptions struct {
+	// only notify when the value crosses Threshold, after moving at least
+	// Hysteresis beyond it, so a value hovering around it does not flap
+	CrossThreshold bool
+	Threshold      float64
+	Hysteresis     float64
+	// ignore changes smaller than MinChange since the last notification
+	MinChange float64
+	// notify at most once per Debounce, delivering the latest value
+	// at the end of the interval
+	Debounce time.Duration
+	// capacity of the channel, events are dropped and counted when it is full
+	Buffer int
+}
+
+type PercentileEvent struct {
+	Percentile float64
+	// the value of the last notification, NaN if the percentile had no value
+	Old float64
+	New float64
+	// set when the value went above or below the threshold
+	Crossed bool
+	Above   bool
+}
+
+// Watcher is a subscription to a tracked percentile; events are sent
+// without blocking while the histogram is locked, so a slow consumer
+// only loses events instead of stalling Enqueue
+type Watcher struct {
+	C          <-chan *PercentileEvent
+	Percentile float64
+
+	c         chan *PercentileEvent
+	key       string
+	options   WatchOptions
+	last      float64
+	above     bool
+	lastSent  time.Time
+	pending   *PercentileEvent
+	timer     *time.Timer
+	dropped   int64
+	histogram *Histogram
+}
+
+// Watch calls f from its own goroutine whenever the item of the tracked
+// percentile p changes, the percentile is tracked if it was not yet
+func (h *Histogram) Watch(p float64, f func(old float64, new float64)) *Watcher {
+	return h.WatchWithOptions(p, WatchOptions{}, f)
+}
+
+func (h *Histogram) WatchWithOptions(p float64, options WatchOptions, f func(old float64, new float64)) *Watcher {
+	w := h.Subscribe(p, options)
+	go func() {
+		for e := range w.C {
+			f(e.Old, e.New)
+		}
+	}()
+	return w
+}
+
+// Subscribe returns a watcher whose channel receives the changes
+// of the tracked percentile p until Unwatch closes it
+func (h *Histogram) Subscribe(p float64, options WatchOptions) *Watcher {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.addPercentilePoint(p)
+	if options.Buffer <= 0 {
+		options.Buffer = defaultWatchBuffer
+	}
+	c := make(chan *PercentileEvent, options.Buffer)
+	w := &Watcher{
+		C:          c,
+		Percentile: p,
+		c:          c,
+		key:        PercentileKey(p),
+		options:    options,
+		last:       math.NaN(),
+		histogram:  h,
+	}
+	if item := h.Percentiles[w.key]; item.node != 0 {
+		w.last = item.Value
+	}
+	w.above = w.last > options.Threshold
+	h.watchers = append(h.watchers, w)
+	return w
+}
+
+// Unwatch stops the notifications and closes the channel of the watcher
+func (h *Histogram) Unwatch(w *Watcher) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for i, x := range h.watchers {
+		if x == w {
+			h.watchers = append(h.watchers[:i], h.watchers[i+1:]...)
+			w.stop()
+			return
+		}
+	}
+}
+
+// stop cancels the pending notification and closes the channel,
+// the histogram must be locked
+func (w *Watcher) stop() {
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	close(w.c)
+}
+
+// Dropped returns the amount of events lost because the channel was full
+func (w *Watcher) Dropped() int64 {
+	return atomic.LoadInt64(&w.dropped)
+}
+
+// notifyWatchers compares every watched percentile with its last notification
+func (h *Histogram) notifyWatchers() {
+	for _, w := range h.watchers {
+		p, ok := h.Percentiles[w.key]
+		if !ok || p.node == 0 || p.Value == w.last {
+			continue
+		}
+		w.observe(p.Value)
+	}
+}
+
+func (w *Watcher) observe(v float64) {
+	o := &w.options
+	e := &PercentileEvent{Percentile: w.Percentile, Old: w.last, New: v}
+
+	if o.CrossThreshold {
+		if !w.above && v > o.Threshold+o.Hysteresis {
+			e.Crossed, e.Above = true, true
+		} else if w.above && v < o.Threshold-o.Hysteresis {
+			e.Crossed, e.Above = true, false
+		} else {
+			return
+		}
+	} else {
+		if !math.IsNaN(w.last) && math.Abs(v-w.last) < o.MinChange {
+			return
+		}
+		e.Above = v > o.Threshold
+	}
+	w.above = e.Above
+	w.last = v
+
+	if o.Debounce > 0 {
+		now := time.Now()
+		if wait := w.lastSent.Add(o.Debounce).Sub(now); wait > 0 {
+			// coalesce into the event delivered at the end of the interval
+			if w.pending != nil {
+				e.Old = w.pending.Old
+				e.Crossed = e.Crossed || w.pending.Crossed
+			}
+			w.pending = e
+			if w.timer == nil {
+				w.timer = time.AfterFunc(wait, w.flush)
+			}
+			return
+		}
+		w.lastSent = now
+	}
+	w.send(e)
+}
+
+// flush delivers the event held back by the debounce
+func (w *Watcher) flush() {
+	h := w.histogram
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	w.timer = nil
+	if w.pending == nil {
+		return
+	}
+	for _, x := range h.watchers {
+		if x == w {
+			w.lastSent = time.Now()
+			w.send(w.pending)
+			break
+		}
+	}
+	w.pending = nil
+}
+
+func (w *Watcher) send(e *PercentileEvent) {
+	select {
+	case w.c <- e:
+	default:
+		atomic.AddInt64(&w.dropped, 1)
+	}
+}
@@ -0,0 +1,112 @@
+package histogram
+
+import (
+	"math"
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatch_SubscribePercentileChanges(t *testing.T) {
+	histogram := NewHistogram(100, float64(10), 1)
+	w := histogram.Subscribe(0.5, WatchOptions{Buffer: 1000})
+
+	values := []float64{}
+	for i := 1; i <= 50; i++ {
+		histogram.Enqueue(float64(i), 1)
+		values = append(values, histogram.GetValueAtPercentile(0.5))
+	}
+	histogram.Unwatch(w)
+
+	last := math.NaN()
+	events := 0
+	for e := range w.C {
+		if events == 0 {
+			assert.True(t, math.IsNaN(e.Old), "the first event has no previous value")
+		} else {
+			assert.Equal(t, last, e.Old, "old should be the previous notification")
+		}
+		assert.NotEqual(t, e.Old, e.New, "only changes should be notified")
+		last = e.New
+		events++
+	}
+	assert.Equal(t, values[len(values)-1], last, "the last event is the current percentile")
+	assert.Greater(t, events, 10, "the median moves as values grow")
+	assert.Equal(t, int64(0), w.Dropped(), "nothing should be dropped with a large buffer")
+}
+
+func TestWatch_ThresholdWithHysteresis(t *testing.T) {
+	histogram := NewHistogram(10, float64(10), 1)
+	w := histogram.Subscribe(0.9, WatchOptions{CrossThreshold: true, Threshold: 100, Hysteresis: 10})
+
+	for i := 0; i < 10; i++ {
+		histogram.Enqueue(50, 1)
+	}
+	// hovering around the threshold stays quiet
+	histogram.Enqueue(105, 5)
+	histogram.Enqueue(95, 5)
+	assert.Equal(t, 0, len(w.C), "values inside the hysteresis should not notify")
+
+	histogram.Enqueue(200, 10)
+	histogram.Enqueue(80, 10)
+	histogram.Unwatch(w)
+
+	events := []*PercentileEvent{}
+	for e := range w.C {
+		events = append(events, e)
+	}
+	assert.Equal(t, 2, len(events), "up and down crossings")
+	if len(events) == 2 {
+		assert.True(t, events[0].Crossed && events[0].Above, "first crossing goes above")
+		assert.Equal(t, float64(200), events[0].New, "value above the threshold")
+		assert.True(t, events[1].Crossed && !events[1].Above, "second crossing goes below")
+		assert.Equal(t, float64(80), events[1].New, "value below the threshold")
+	}
+}
+
+func TestWatch_SlowConsumerDoesNotStall(t *testing.T) {
+	histogram := NewHistogram(1000, float64(10), 1)
+	block := make(chan struct{})
+	calls := 0
+	w := histogram.WatchWithOptions(0.99, WatchOptions{Buffer: 2}, func(old float64, new float64) {
+		calls++
+		<-block
+	})
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10000; i++ {
+			histogram.Enqueue(float64(i%1000), 1)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("a blocked consumer should not stall the ingestion")
+	}
+	assert.Greater(t, w.Dropped(), int64(0), "events beyond the buffer are dropped")
+	histogram.Unwatch(w)
+	close(block)
+}
+
+func TestWatch_Debounce(t *testing.T) {
+	histogram := NewHistogram(100, float64(10), 0)
+	w := histogram.Subscribe(0.5, WatchOptions{Debounce: 50 * time.Millisecond})
+
+	for i := 1; i <= 50; i++ {
+		histogram.Enqueue(float64(i), 1)
+	}
+	first := <-w.C
+	assert.True(t, math.IsNaN(first.Old), "the first change is delivered right away")
+
+	select {
+	case e := <-w.C:
+		assert.Equal(t, first.New, e.Old, "the coalesced event starts from the last delivered value")
+		assert.Equal(t, histogram.GetValueAtPercentile(0.5), e.New, "the coalesced event carries the latest value")
+	case <-time.After(time.Second):
+		t.Fatal("the latest value should be delivered at the end of the interval")
+	}
+	assert.Equal(t, 0, len(w.C), "changes inside the interval are coalesced")
+	histogram.Unwatch(w)
+}